@@ -0,0 +1,192 @@
+// Package calib describes how the lidar is mounted on the servo and solves for
+// the mount parameters from raw scans of planar surfaces.
+package calib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// Mount is the chain of transforms from the lidar to the base frame. A
+// measurement is a point in the scan plane of the lidar frame (see
+// LidarPoint). LidarToHead moves it to the head, which the servo tilts about
+// the axis, and HeadToBase moves the tilted head to the base frame.
+type Mount struct {
+	LidarToHead cloud.Transform // lidar frame to the head frame
+	Axis        Axis            // servo axis in the head frame
+	HeadToBase  cloud.Transform // head frame at the tilt 0 to the base frame
+	ServoUnit   float64         // degrees per servo position unit
+	ServoZero   float64         // tilt in degrees at the servo calibration position
+}
+
+// Axis is a rotation axis. Positive angles rotate counterclockwise when looking
+// against the direction.
+type Axis struct {
+	Direction cloud.Point // unit vector
+	Point     cloud.Point // any point on the axis
+}
+
+// SimpleMount returns the mount of a head whose lidar center lies on the servo
+// axis, with the scan plane rotated by cloudRotation radians. The servo axis
+// is the Y axis of the base frame and the scan plane is its XY plane at the
+// tilt 0, as assumed by the first versions of sync.
+func SimpleMount(cloudRotation, servoUnit float64) Mount {
+	return Mount{
+		// the Y axis of the scan plane is flipped, which is rotating the lidar
+		// upside down
+		LidarToHead: cloud.Transform{R: cloud.RotationFromEuler(math.Pi, 0, -cloudRotation)},
+		Axis:        Axis{Direction: cloud.Point{Y: -1}},
+		HeadToBase:  cloud.IdentityTransform,
+		ServoUnit:   servoUnit,
+	}
+}
+
+// Prototype is the hand-tuned mount of the first lidar head prototype.
+var Prototype = SimpleMount(-math.Pi/4, -0.05)
+
+// LidarPoint returns the raw measurement (angle in degrees, distance in
+// millimeters) as a point of the lidar frame.
+func LidarPoint(angle, dist float64) cloud.Point {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	return cloud.Point{X: dist * cos, Y: dist * sin}
+}
+
+// Tilt returns the tilt in degrees at the servo position.
+func (m *Mount) Tilt(pos, calibPos int) float64 {
+	return float64(pos-calibPos)*m.ServoUnit + m.ServoZero
+}
+
+// TiltTransform returns the rotation of the head by the tilt in degrees about
+// the servo axis.
+func (m *Mount) TiltTransform(tilt float64) cloud.Transform {
+	r := cloud.RotationFromVector(m.Axis.Direction.Scale(tilt * math.Pi / 180))
+	return cloud.Transform{R: r, T: m.Axis.Point.Sub(r.MulVec(m.Axis.Point))}
+}
+
+// LidarToBase returns the transform of the lidar frame to the base frame at
+// the tilt in degrees.
+func (m *Mount) LidarToBase(tilt float64) cloud.Transform {
+	return m.HeadToBase.Mul(m.TiltTransform(tilt)).Mul(m.LidarToHead)
+}
+
+// Point returns the raw measurement taken at the tilt in the base frame.
+// Compose LidarToBase once to transform many measurements at the same tilt.
+func (m *Mount) Point(angle, dist, tilt float64) cloud.Point {
+	return m.LidarToBase(tilt).Apply(LidarPoint(angle, dist))
+}
+
+// TransformJSON is a transform in a calibration file, other files describing
+// the rig (e.g. scenes of scan-dummy) use it too.
+type TransformJSON struct {
+	Translation [3]float64 `json:"translation"` // millimeters
+	RPY         [3]float64 `json:"rpy"`         // roll, pitch, yaw in degrees, see cloud.RotationFromEuler
+}
+
+// NewTransformJSON returns the transform to be encoded.
+func NewTransformJSON(tf cloud.Transform) *TransformJSON {
+	roll, pitch, yaw := tf.R.Euler()
+	deg := 180 / math.Pi
+	return &TransformJSON{
+		Translation: [3]float64{tf.T.X, tf.T.Y, tf.T.Z},
+		RPY:         [3]float64{roll * deg, pitch * deg, yaw * deg},
+	}
+}
+
+// Transform returns the decoded transform, the identity if j is nil (the
+// transform was omitted).
+func (j *TransformJSON) Transform() cloud.Transform {
+	if j == nil {
+		return cloud.IdentityTransform
+	}
+	rad := math.Pi / 180
+	return cloud.Transform{
+		R: cloud.RotationFromEuler(j.RPY[0]*rad, j.RPY[1]*rad, j.RPY[2]*rad),
+		T: cloud.Point{X: j.Translation[0], Y: j.Translation[1], Z: j.Translation[2]},
+	}
+}
+
+type axisJSON struct {
+	Direction [3]float64 `json:"direction"`
+	Point     [3]float64 `json:"point"` // millimeters
+}
+
+// mountJSON is the calibration file. Files of the first calibrations have only
+// the cloud rotation and the offset of the lidar from the axis of a SimpleMount.
+type mountJSON struct {
+	LidarToHead *TransformJSON `json:"lidar_to_head,omitempty"`
+	ServoAxis   *axisJSON      `json:"servo_axis,omitempty"`
+	HeadToBase  *TransformJSON `json:"head_to_base,omitempty"`
+	ServoUnit   float64        `json:"servo_unit"`
+	ServoZero   float64        `json:"servo_zero"`
+
+	CloudRotation *float64    `json:"cloud_rotation,omitempty"` // radians
+	AxisOffset    *[3]float64 `json:"axis_offset,omitempty"`    // millimeters
+}
+
+// Load reads the mount from a JSON calibration file.
+func Load(path string) (Mount, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Mount{}, err
+	}
+	var j mountJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return Mount{}, fmt.Errorf("%s: %v", path, err)
+	}
+	m, err := j.mount()
+	if err != nil {
+		return Mount{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func (j *mountJSON) mount() (Mount, error) {
+	if j.CloudRotation != nil {
+		m := SimpleMount(*j.CloudRotation, j.ServoUnit)
+		if j.AxisOffset != nil {
+			m.LidarToHead.T = cloud.Point{X: j.AxisOffset[0], Y: j.AxisOffset[1], Z: j.AxisOffset[2]}
+		}
+		m.ServoZero = j.ServoZero
+		return m, nil
+	}
+
+	if j.LidarToHead == nil || j.ServoAxis == nil {
+		return Mount{}, errors.New("lidar_to_head and servo_axis are required")
+	}
+	d := cloud.Point{X: j.ServoAxis.Direction[0], Y: j.ServoAxis.Direction[1], Z: j.ServoAxis.Direction[2]}
+	if d.Norm() == 0 {
+		return Mount{}, errors.New("zero servo axis direction")
+	}
+	return Mount{
+		LidarToHead: j.LidarToHead.Transform(),
+		Axis: Axis{
+			Direction: d.Scale(1 / d.Norm()),
+			Point:     cloud.Point{X: j.ServoAxis.Point[0], Y: j.ServoAxis.Point[1], Z: j.ServoAxis.Point[2]},
+		},
+		HeadToBase: j.HeadToBase.Transform(),
+		ServoUnit:  j.ServoUnit,
+		ServoZero:  j.ServoZero,
+	}, nil
+}
+
+// Save writes the mount to a JSON calibration file.
+func (m Mount) Save(path string) error {
+	d, p := m.Axis.Direction, m.Axis.Point
+	j := mountJSON{
+		LidarToHead: NewTransformJSON(m.LidarToHead),
+		ServoAxis:   &axisJSON{Direction: [3]float64{d.X, d.Y, d.Z}, Point: [3]float64{p.X, p.Y, p.Z}},
+		HeadToBase:  NewTransformJSON(m.HeadToBase),
+		ServoUnit:   m.ServoUnit,
+		ServoZero:   m.ServoZero,
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
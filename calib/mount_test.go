@@ -0,0 +1,77 @@
+package calib
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// assertSameMount fails if the mounts place measurements at different points.
+func assertSameMount(t *testing.T, got, want Mount) {
+	t.Helper()
+	if got.ServoUnit != want.ServoUnit || got.ServoZero != want.ServoZero {
+		t.Errorf("servo unit %g and zero %g, want %g and %g", got.ServoUnit, got.ServoZero, want.ServoUnit, want.ServoZero)
+	}
+	for _, tilt := range []float64{-60, 0, 25} {
+		for _, angle := range []float64{0, 90, 200} {
+			p, q := got.Point(angle, 1000, tilt), want.Point(angle, 1000, tilt)
+			if d := p.Sub(q).Norm(); d > 1e-6 {
+				t.Errorf("measurement %g at tilt %g is at %v, want %v", angle, tilt, p, q)
+			}
+		}
+	}
+}
+
+func TestMountSaveLoad(t *testing.T) {
+	want := Mount{
+		LidarToHead: cloud.Transform{R: cloud.RotationFromEuler(math.Pi, 0.02, 0.7), T: cloud.Point{X: 40, Y: -3, Z: 25}},
+		Axis:        Axis{Direction: cloud.Point{X: 0.1, Y: -1}.Scale(1 / math.Hypot(0.1, 1)), Point: cloud.Point{X: 5, Z: -12}},
+		HeadToBase:  cloud.Transform{R: cloud.RotationFromEuler(0.01, -0.03, 0), T: cloud.Point{Z: 300}},
+		ServoUnit:   -0.047,
+		ServoZero:   3.5,
+	}
+	path := filepath.Join(t.TempDir(), "mount.json")
+	if err := want.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameMount(t, got, want)
+}
+
+func TestLoadLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mount.json")
+	data := `{"cloud_rotation": -0.7, "axis_offset": [40, 0, 25], "servo_unit": -0.05, "servo_zero": 2}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SimpleMount(-0.7, -0.05)
+	want.LidarToHead.T = cloud.Point{X: 40, Z: 25}
+	want.ServoZero = 2
+	assertSameMount(t, got, want)
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"servo_axis": {"direction": [0, -1, 0]}, "servo_unit": -0.05}`,                     // no lidar_to_head
+		`{"lidar_to_head": {}, "servo_axis": {"direction": [0, 0, 0]}, "servo_unit": -0.05}`, // zero axis
+		`{"lidar_to_head": {}, "servo_axis": {"direction": [0, -1, 0]}, "servo_unit": -0.05`, // cut
+	} {
+		path := filepath.Join(t.TempDir(), "mount.json")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%s) succeeded", data)
+		}
+	}
+}
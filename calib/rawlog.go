@@ -0,0 +1,100 @@
+package calib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Measurement is a single raw lidar measurement.
+type Measurement struct {
+	Angle float64 // degrees
+	Dist  float64 // millimeters, 0 if there was no return
+}
+
+// RawScan is a raw 2D scan with the servo position it was taken at.
+type RawScan struct {
+	ServoPos     int
+	Measurements []Measurement
+}
+
+// RawLog is a recording of raw scans, as written by sync --rawlog.
+type RawLog struct {
+	ServoCalib int // servo calibration position the tilt is measured from
+	Scans      []RawScan
+}
+
+// The raw log is a text file. It starts with the "servocalib = pos" line,
+// followed by scans: one "angle dist" line per measurement and the
+// "servo = pos" line after every scan.
+
+// WriteRawHeader writes the header of a raw log.
+func WriteRawHeader(w io.Writer, servoCalib int) error {
+	_, err := fmt.Fprintf(w, "servocalib = %d\n", servoCalib)
+	return err
+}
+
+// WriteRawScan writes a scan to a raw log.
+func WriteRawScan(w io.Writer, scan *RawScan) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range scan.Measurements {
+		fmt.Fprintf(bw, "%.4f\t%.2f\n", m.Angle, m.Dist)
+	}
+	fmt.Fprintf(bw, "servo = %d\n", scan.ServoPos)
+	return bw.Flush()
+}
+
+// ReadRawLog reads a raw log. A scan which is not ended by a servo line (the
+// recording was cut) is dropped.
+func ReadRawLog(r io.Reader) (*RawLog, error) {
+	scanner := bufio.NewScanner(r)
+	raw := &RawLog{ServoCalib: -1}
+	var measurements []Measurement
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if i := strings.IndexByte(text, '='); i >= 0 {
+			key := strings.TrimSpace(text[:i])
+			value, err := strconv.Atoi(strings.TrimSpace(text[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			switch key {
+			case "servocalib":
+				raw.ServoCalib = value
+			case "servo":
+				raw.Scans = append(raw.Scans, RawScan{ServoPos: value, Measurements: measurements})
+				measurements = nil
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", line, key)
+			}
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected angle and distance", line)
+		}
+		angle, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		dist, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		measurements = append(measurements, Measurement{angle, dist})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if raw.ServoCalib < 0 {
+		return nil, fmt.Errorf("no servocalib line")
+	}
+	return raw, nil
+}
@@ -0,0 +1,50 @@
+package calib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRawLogRoundTrip(t *testing.T) {
+	want := &RawLog{
+		ServoCalib: 2500,
+		Scans: []RawScan{
+			{ServoPos: 1000, Measurements: []Measurement{{0, 1234.5}, {0.5625, 0}, {359.4375, 87.25}}},
+			{ServoPos: 1020, Measurements: []Measurement{{12.125, 4000}}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteRawHeader(&buf, want.ServoCalib); err != nil {
+		t.Fatal(err)
+	}
+	for i := range want.Scans {
+		if err := WriteRawScan(&buf, &want.Scans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the recording was cut in the middle of a scan
+	buf.WriteString("1.5\t100.00\n")
+
+	got, err := ReadRawLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+}
+
+func TestReadRawLogErrors(t *testing.T) {
+	for _, log := range []string{
+		"1.0\t100.0\nservo = 1000\n",                  // no servocalib
+		"servocalib = 2500\n1.0\nservo = 1000\n",      // no distance
+		"servocalib = 2500\n1.0\t100.0\nservo = x\n",  // bad position
+		"servocalib = 2500\n1.0\t100.0\nspeed = 10\n", // unknown key
+	} {
+		if _, err := ReadRawLog(strings.NewReader(log)); err == nil {
+			t.Errorf("ReadRawLog(%q) succeeded", log)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package calib
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// SolveOptions configure the calibration.
+type SolveOptions struct {
+	RANSAC      cloud.RANSACOptions // plane extraction in the last round
+	Rounds      int                 // rounds of plane extraction and optimization
+	Iterations  int                 // max Levenberg-Marquardt iterations per round
+	MaxPoints   int                 // measurements used, evenly sampled from the log
+	PlanePoints int                 // max measurements of a plane used by the optimization
+	Level       bool                // solve ServoZero so that the floor or the ceiling is horizontal
+}
+
+// DefaultSolveOptions are suitable for a sweep of a room.
+var DefaultSolveOptions = SolveOptions{
+	RANSAC:      cloud.DefaultRANSACOptions,
+	Rounds:      3,
+	Iterations:  30,
+	MaxPoints:   30000,
+	PlanePoints: 500,
+	Level:       true,
+}
+
+// levelMaxTilt is the max angle in degrees between the normal of a plane
+// leveled by Solve and the Z axis.
+const levelMaxTilt = 30
+
+// Result is the solved mount and the fit quality.
+type Result struct {
+	Mount      Mount
+	Planes     int     // planes used in the last round
+	Points     int     // measurements on them
+	RMSEBefore float64 // distance of the measurements from their planes with the initial mount
+	RMSE       float64 // and with the solved mount
+	Leveled    bool    // ServoZero was solved
+}
+
+// sample is a measurement with the servo position.
+type sample struct {
+	pos         int
+	angle, dist float64
+}
+
+// Solve finds the mount which makes planar surfaces in the log flattest. It
+// alternates extraction of planes with the current mount and minimizing
+// distances of their points from the planes (refitted for every mount) by the
+// rotation of the lidar in its scan plane, its offset from the servo axis
+// perpendicular to the axis and the servo unit. The other parameters of the
+// chain are kept from the initial mount: the offset along the axis shifts the
+// whole cloud and the servo zero rotates it, neither changes flatness. The
+// zero is solved by leveling the most horizontal plane instead, if opts.Level
+// is set.
+//
+// The mount is rough in the first rounds, so points of a plane spread off it.
+// The RANSAC threshold of a round is opts.RANSAC.Threshold times the number of
+// rounds left, shrinking to the threshold itself in the last round.
+//
+// The log should cover a wide range of the servo and at least three
+// non-parallel planes, e.g. the floor and two walls of a room.
+func Solve(raw *RawLog, initial Mount, opts SolveOptions) (Result, error) {
+	var samples []sample
+	for _, scan := range raw.Scans {
+		for _, m := range scan.Measurements {
+			if m.Dist > 0 {
+				samples = append(samples, sample{scan.ServoPos, m.Angle, m.Dist})
+			}
+		}
+	}
+	samples = subsample(samples, opts.MaxPoints)
+	if len(samples) < opts.RANSAC.MinInliers {
+		return Result{}, fmt.Errorf("calib: %d measurements, at least %d are needed", len(samples), opts.RANSAC.MinInliers)
+	}
+
+	params := newMountParams(initial)
+	x := params.initial()
+	mount := initial
+	var planes [][]sample
+	for round := 0; round < opts.Rounds; round++ {
+		ransac := opts.RANSAC
+		ransac.Threshold *= float64(opts.Rounds - round)
+		planes = extractPlanes(samples, &mount, raw.ServoCalib, ransac, opts.PlanePoints)
+		if len(planes) < 3 {
+			return Result{}, fmt.Errorf("calib: %d planes found, at least 3 non-parallel planes are needed", len(planes))
+		}
+		x = optimize(planes, params, x, raw.ServoCalib, opts.Iterations)
+		mount = params.mount(x)
+
+		// points off their planes let the optimization shrink the servo
+		// unit, which flattens the sweep to a single plane
+		if ratio := mount.ServoUnit / initial.ServoUnit; ratio < 0.5 || ratio > 2 {
+			return Result{}, fmt.Errorf("calib: the servo unit diverged to %g, try a better initial guess or a lower threshold", mount.ServoUnit)
+		}
+	}
+
+	result := Result{
+		Mount:      mount,
+		Planes:     len(planes),
+		RMSEBefore: fitRMSE(planes, &initial, raw.ServoCalib),
+		RMSE:       fitRMSE(planes, &mount, raw.ServoCalib),
+	}
+	for _, plane := range planes {
+		result.Points += len(plane)
+	}
+	if opts.Level {
+		result.Leveled = level(planes, &result.Mount, raw.ServoCalib)
+	}
+	return result, nil
+}
+
+// subsample returns at most n evenly spaced samples.
+func subsample(samples []sample, n int) []sample {
+	if n <= 0 || len(samples) <= n {
+		return samples
+	}
+	result := make([]sample, n)
+	for i := range result {
+		result[i] = samples[i*len(samples)/n]
+	}
+	return result
+}
+
+// points returns the samples as points with the mount.
+func points(samples []sample, mount *Mount, calibPos int) []cloud.Point {
+	transforms := make(map[int]cloud.Transform)
+	result := make([]cloud.Point, len(samples))
+	for i, s := range samples {
+		tf, ok := transforms[s.pos]
+		if !ok {
+			tf = mount.LidarToBase(mount.Tilt(s.pos, calibPos))
+			transforms[s.pos] = tf
+		}
+		result[i] = tf.Apply(LidarPoint(s.angle, s.dist))
+	}
+	return result
+}
+
+// extractPlanes returns samples of planes found with the mount.
+func extractPlanes(samples []sample, mount *Mount, calibPos int, opts cloud.RANSACOptions, planePoints int) [][]sample {
+	segments, _ := cloud.SegmentPlanes(points(samples, mount, calibPos), opts)
+	planes := make([][]sample, len(segments))
+	for i, s := range segments {
+		inliers := make([]sample, len(s.Inliers))
+		for j, index := range s.Inliers {
+			inliers[j] = samples[index]
+		}
+		planes[i] = subsample(inliers, planePoints)
+	}
+	return planes
+}
+
+// fitPlanes returns the least squares planes of the samples of each plane with
+// the mount, normals face the lidar.
+func fitPlanes(planes [][]sample, mount *Mount, calibPos int) []cloud.Plane {
+	fitted := make([]cloud.Plane, len(planes))
+	for i, samples := range planes {
+		plane, _ := cloud.FitPlane(points(samples, mount, calibPos))
+		if plane.D < 0 {
+			plane = cloud.Plane{Normal: plane.Normal.Scale(-1), D: -plane.D}
+		}
+		fitted[i] = plane
+	}
+	return fitted
+}
+
+// residuals returns distances of the samples from their planes.
+func residuals(planes [][]sample, fitted []cloud.Plane, mount *Mount, calibPos int) []float64 {
+	var r []float64
+	for i, samples := range planes {
+		for _, p := range points(samples, mount, calibPos) {
+			r = append(r, fitted[i].Distance(p))
+		}
+	}
+	return r
+}
+
+// fitRMSE returns the root mean square distance of the samples from the planes
+// fitted with the mount.
+func fitRMSE(planes [][]sample, mount *Mount, calibPos int) float64 {
+	return rmse(residuals(planes, fitPlanes(planes, mount, calibPos), mount, calibPos))
+}
+
+func rmse(r []float64) float64 {
+	return math.Sqrt(sumSquares(r) / float64(len(r)))
+}
+
+func sumSquares(r []float64) float64 {
+	sum := 0.0
+	for _, v := range r {
+		sum += v * v
+	}
+	return sum
+}
+
+// paramSteps are steps of numerical derivatives by the optimized parameters,
+// see mountParams.
+var paramSteps = [...]float64{1e-6, 1e-3, 1e-3, 1e-7}
+
+const numParams = len(paramSteps)
+
+// mountParams maps the optimized parameters to mounts: the rotation of the
+// lidar in its scan plane in radians and its offset along two directions
+// perpendicular to the servo axis, relative to the base mount, and the servo
+// unit.
+type mountParams struct {
+	base   Mount
+	e1, e2 cloud.Point // head frame
+}
+
+func newMountParams(base Mount) *mountParams {
+	// the coordinate axis least aligned with the servo axis, made
+	// perpendicular to it
+	u := base.Axis.Direction
+	a := cloud.Point{X: 1}
+	if math.Abs(u.Y) < math.Abs(u.X) && math.Abs(u.Y) <= math.Abs(u.Z) {
+		a = cloud.Point{Y: 1}
+	} else if math.Abs(u.Z) < math.Abs(u.X) && math.Abs(u.Z) < math.Abs(u.Y) {
+		a = cloud.Point{Z: 1}
+	}
+	e1 := a.Sub(u.Scale(u.Dot(a)))
+	e1 = e1.Scale(1 / e1.Norm())
+	return &mountParams{base: base, e1: e1, e2: u.Cross(e1)}
+}
+
+func (p *mountParams) initial() [numParams]float64 {
+	return [numParams]float64{0, 0, 0, p.base.ServoUnit}
+}
+
+func (p *mountParams) mount(x [numParams]float64) Mount {
+	m := p.base
+	m.LidarToHead.R = m.LidarToHead.R.Mul(cloud.RotationFromVector(cloud.Point{Z: x[0]}))
+	m.LidarToHead.T = m.LidarToHead.T.Add(p.e1.Scale(x[1])).Add(p.e2.Scale(x[2]))
+	m.ServoUnit = x[3]
+	return m
+}
+
+// optimize minimizes distances of the samples from their planes, refitted for
+// every mount, by the mount parameters with the Levenberg-Marquardt method.
+func optimize(planes [][]sample, params *mountParams, x [numParams]float64, calibPos int, iterations int) [numParams]float64 {
+	eval := func(x [numParams]float64) []float64 {
+		m := params.mount(x)
+		return residuals(planes, fitPlanes(planes, &m, calibPos), &m, calibPos)
+	}
+
+	r := eval(x)
+	cost := sumSquares(r)
+	lambda := 1e-3
+	for it := 0; it < iterations; it++ {
+		// central differences
+		var jacobian [numParams][]float64
+		for j := range jacobian {
+			hi, lo := x, x
+			hi[j] += paramSteps[j]
+			lo[j] -= paramSteps[j]
+			rHi, rLo := eval(hi), eval(lo)
+			jacobian[j] = make([]float64, len(r))
+			for i := range r {
+				jacobian[j][i] = (rHi[i] - rLo[i]) / (2 * paramSteps[j])
+			}
+		}
+		var a [numParams][numParams]float64
+		var g [numParams]float64
+		for j := 0; j < numParams; j++ {
+			for k := 0; k <= j; k++ {
+				for i := range r {
+					a[j][k] += jacobian[j][i] * jacobian[k][i]
+				}
+				a[k][j] = a[j][k]
+			}
+			for i := range r {
+				g[j] -= jacobian[j][i] * r[i]
+			}
+		}
+
+		improved, converged := false, false
+		for attempt := 0; attempt < 10 && !improved; attempt++ {
+			damped := a
+			for j := range damped {
+				damped[j][j] += lambda * math.Max(a[j][j], 1e-12)
+			}
+			if step, ok := solve(damped, g); ok {
+				next := x
+				for j := range next {
+					next[j] += step[j]
+				}
+				rNext := eval(next)
+				if c := sumSquares(rNext); c < cost {
+					improved, converged = true, cost-c < 1e-9*cost
+					x, r, cost = next, rNext, c
+					lambda = math.Max(lambda/10, 1e-9)
+					continue
+				}
+			}
+			lambda *= 10
+		}
+		if !improved || converged {
+			break
+		}
+	}
+	return x
+}
+
+// solve solves a x = b by Gaussian elimination with partial pivoting. ok is
+// false if a is singular.
+func solve(a [numParams][numParams]float64, b [numParams]float64) (x [numParams]float64, ok bool) {
+	for col := 0; col < numParams; col++ {
+		pivot := col
+		for row := col + 1; row < numParams; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-300 {
+			return x, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < numParams; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < numParams; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+	for row := numParams - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < numParams; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, true
+}
+
+// level sets ServoZero so that the most horizontal plane is horizontal. It
+// returns false if no plane is within levelMaxTilt of horizontal.
+func level(planes [][]sample, mount *Mount, calibPos int) bool {
+	best := cloud.Point{}
+	for _, samples := range planes {
+		plane, _ := cloud.FitPlane(points(samples, mount, calibPos))
+		if math.Abs(plane.Normal.Z) > math.Abs(best.Z) {
+			best = plane.Normal
+		}
+	}
+	if math.Abs(best.Z) < math.Cos(levelMaxTilt*math.Pi/180) {
+		return false
+	}
+	if best.Z < 0 {
+		best = best.Scale(-1)
+	}
+	// tilting by delta rotates the normal about the servo axis in the base
+	// frame, the normal is the closest to Z at atan2(b, a), see the Rodrigues'
+	// rotation formula
+	u := mount.HeadToBase.R.MulVec(mount.Axis.Direction)
+	a := best.Z - u.Z*u.Dot(best)
+	b := u.Cross(best).Z
+	mount.ServoZero += math.Atan2(b, a) * 180 / math.Pi
+	return true
+}
@@ -0,0 +1,88 @@
+package calib
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// room is the level box the synthetic logs are recorded in, in millimeters.
+var roomMin, roomMax = cloud.Point{X: -2500, Y: -3000, Z: -1200}, cloud.Point{X: 3500, Y: 2000, Z: 1800}
+
+// raycast returns the distance from o along the unit vector u to the room walls.
+func raycast(o, u cloud.Point) float64 {
+	t := math.Inf(1)
+	for _, face := range [][3]float64{
+		{u.X, o.X, roomMin.X}, {u.X, o.X, roomMax.X},
+		{u.Y, o.Y, roomMin.Y}, {u.Y, o.Y, roomMax.Y},
+		{u.Z, o.Z, roomMin.Z}, {u.Z, o.Z, roomMax.Z},
+	} {
+		if face[0] != 0 {
+			if s := (face[2] - face[1]) / face[0]; s > 0 && s < t {
+				t = s
+			}
+		}
+	}
+	return t
+}
+
+// recordRoom returns the raw log of a servo sweep of the room with the mount,
+// the distances have gaussian noise of the standard deviation.
+func recordRoom(mount Mount, noise float64, rng *rand.Rand) *RawLog {
+	raw := &RawLog{ServoCalib: 2500}
+	for pos := 1000; pos <= 3000; pos += 20 {
+		tf := mount.LidarToBase(mount.Tilt(pos, raw.ServoCalib))
+		scan := RawScan{ServoPos: pos}
+		for angle := 0.0; angle < 360; angle++ {
+			dist := raycast(tf.T, tf.R.MulVec(LidarPoint(angle, 1)))
+			scan.Measurements = append(scan.Measurements, Measurement{angle, dist + rng.NormFloat64()*noise})
+		}
+		raw.Scans = append(raw.Scans, scan)
+	}
+	return raw
+}
+
+// rotationAngle returns the angle in degrees of the rotation between a and b.
+func rotationAngle(a, b cloud.Mat3) float64 {
+	d := a.Transpose().Mul(b)
+	c := (d[0][0] + d[1][1] + d[2][2] - 1) / 2
+	return math.Acos(math.Max(-1, math.Min(1, c))) * 180 / math.Pi
+}
+
+func TestSolve(t *testing.T) {
+	truth := SimpleMount(-math.Pi/4+0.06, -0.047)
+	truth.LidarToHead.T = cloud.Point{X: 40, Z: 25} // off the axis, perpendicular to it
+	truth.ServoZero = 3
+	raw := recordRoom(truth, 1, rand.New(rand.NewSource(1)))
+
+	// the offset along the scan plane is weakly constrained by a box room, it
+	// takes more rounds than the default to settle
+	opts := DefaultSolveOptions
+	opts.Rounds = 6
+	result, err := Solve(raw, Prototype, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Mount
+
+	if result.Planes < 3 || result.Points == 0 {
+		t.Errorf("solved with %d planes of %d points", result.Planes, result.Points)
+	}
+	if !(result.RMSE < result.RMSEBefore) || result.RMSE > 5 {
+		t.Errorf("RMSE %g, %g before", result.RMSE, result.RMSEBefore)
+	}
+	if d := math.Abs(m.ServoUnit - truth.ServoUnit); d > 1e-3 {
+		t.Errorf("servo unit %g, want %g", m.ServoUnit, truth.ServoUnit)
+	}
+	if d := rotationAngle(m.LidarToHead.R, truth.LidarToHead.R); d > 0.2 {
+		t.Errorf("lidar rotation is %g deg off", d)
+	}
+	if d := m.LidarToHead.T.Sub(truth.LidarToHead.T).Norm(); d > 5 {
+		t.Errorf("lidar offset %v, want %v", m.LidarToHead.T, truth.LidarToHead.T)
+	}
+	if !result.Leveled || math.Abs(m.ServoZero-truth.ServoZero) > 0.2 {
+		t.Errorf("servo zero %g (leveled %v), want %g", m.ServoZero, result.Leveled, truth.ServoZero)
+	}
+}
@@ -0,0 +1,73 @@
+// Package cloud contains the point cloud type shared by offline tools and
+// readers of the formats clouds are saved in.
+package cloud
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// Point is a single cartesian point. Units are the ones of the source, sync
+// outputs millimeters.
+type Point = spatial.Point
+
+// Cloud is a set of points.
+type Cloud struct {
+	Points  []Point
+	Labels  []int   // optional segment ID of each point, nil if not segmented
+	Normals []Point // optional unit normal of each point, nil if not estimated
+	Colors  []Color // optional color of each point, nil if not colored
+}
+
+// Color is an RGB color.
+type Color struct {
+	R, G, B uint8
+}
+
+// Len returns the number of points.
+func (c *Cloud) Len() int {
+	return len(c.Points)
+}
+
+// Bounds returns the minimum and maximum coordinates of the points. Both are
+// zero for an empty cloud.
+func (c *Cloud) Bounds() (min Point, max Point) {
+	if len(c.Points) == 0 {
+		return
+	}
+
+	min, max = c.Points[0], c.Points[0]
+	for _, p := range c.Points[1:] {
+		min.X = math.Min(min.X, p.X)
+		min.Y = math.Min(min.Y, p.Y)
+		min.Z = math.Min(min.Z, p.Z)
+		max.X = math.Max(max.X, p.X)
+		max.Y = math.Max(max.Y, p.Y)
+		max.Z = math.Max(max.Z, p.Z)
+	}
+	return
+}
+
+// Scale multiplies all coordinates by s, e.g. 0.001 converts millimeters to meters.
+func (c *Cloud) Scale(s float64) {
+	for i := range c.Points {
+		c.Points[i] = c.Points[i].Scale(s)
+	}
+}
+
+// checkAttributes returns an error if the cloud has labels, normals or colors,
+// but not one per point.
+func (c *Cloud) checkAttributes() error {
+	if c.Labels != nil && len(c.Labels) != len(c.Points) {
+		return fmt.Errorf("%d labels for %d points", len(c.Labels), len(c.Points))
+	}
+	if c.Normals != nil && len(c.Normals) != len(c.Points) {
+		return fmt.Errorf("%d normals for %d points", len(c.Normals), len(c.Points))
+	}
+	if c.Colors != nil && len(c.Colors) != len(c.Points) {
+		return fmt.Errorf("%d colors for %d points", len(c.Colors), len(c.Points))
+	}
+	return nil
+}
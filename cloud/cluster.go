@@ -0,0 +1,198 @@
+package cloud
+
+import (
+	"math"
+	"sort"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// ClusterOptions configure Euclidean cluster extraction.
+type ClusterOptions struct {
+	Tolerance float64 // max distance between neighboring points of a cluster
+	MinSize   int     // smaller clusters are discarded
+	MaxSize   int     // larger clusters are discarded, 0 for no limit
+}
+
+// DefaultClusterOptions are suitable for sync's clouds (millimeters).
+var DefaultClusterOptions = ClusterOptions{
+	Tolerance: 100,
+	MinSize:   30,
+	MaxSize:   0,
+}
+
+// Box is an axis-aligned bounding box.
+type Box struct {
+	Min, Max Point
+}
+
+// Center returns the center of the box.
+func (b Box) Center() Point {
+	return b.Min.Add(b.Max).Scale(0.5)
+}
+
+// Size returns the edge lengths of the box.
+func (b Box) Size() Point {
+	return b.Max.Sub(b.Min)
+}
+
+// OrientedBox is an upright bounding box rotated about Z.
+type OrientedBox struct {
+	Center Point
+	Axes   Mat3  // rotation, columns are the unit box axes, the first along the largest spread
+	Size   Point // edge lengths along the axes
+}
+
+// Volume returns the volume of the box.
+func (b OrientedBox) Volume() float64 {
+	return b.Size.X * b.Size.Y * b.Size.Z
+}
+
+// Cluster is a set of points connected by gaps no larger than the tolerance.
+type Cluster struct {
+	ID       int   // label of the points, the first (largest) cluster is 1
+	Indices  []int // indices of the points
+	Centroid Point
+	Box      Box
+	Oriented OrientedBox
+}
+
+// EuclideanClusters groups the points into clusters, the largest first.
+// Clusters are grown from a seed point by adding all points within the
+// tolerance of any point already in the cluster. labels contain the cluster ID
+// of each point, 0 for points in discarded clusters.
+func EuclideanClusters(points []Point, opts ClusterOptions) (clusters []Cluster, labels []int) {
+	tree := spatial.NewKDTree(points)
+	visited := make([]bool, len(points))
+	var groups [][]int
+	for seed := range points {
+		if visited[seed] {
+			continue
+		}
+		visited[seed] = true
+		group := []int{seed}
+		for i := 0; i < len(group); i++ { // group grows as it is scanned
+			for _, n := range tree.Radius(points[group[i]], opts.Tolerance) {
+				if !visited[n] {
+					visited[n] = true
+					group = append(group, n)
+				}
+			}
+		}
+		if len(group) >= opts.MinSize && (opts.MaxSize <= 0 || len(group) <= opts.MaxSize) {
+			groups = append(groups, group)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i]) > len(groups[j]) })
+
+	labels = make([]int, len(points))
+	clusters = make([]Cluster, len(groups))
+	for i, group := range groups {
+		sort.Ints(group)
+		selected := make([]Point, len(group))
+		for j, index := range group {
+			selected[j] = points[index]
+			labels[index] = i + 1
+		}
+		min, max := (&Cloud{Points: selected}).Bounds()
+		centroid, oriented := orientedBox(selected)
+		clusters[i] = Cluster{
+			ID:       i + 1,
+			Indices:  group,
+			Centroid: centroid,
+			Box:      Box{Min: min, Max: max},
+			Oriented: oriented,
+		}
+	}
+	return clusters, labels
+}
+
+// orientedBox returns the centroid and the upright bounding box of the points.
+// The box is rotated about Z to the minimum area rectangle enclosing the points
+// projected on the XY plane, as obstacles stand on the ground. One of its sides
+// lies on an edge of the convex hull of the projected points.
+func orientedBox(points []Point) (centroid Point, box OrientedBox) {
+	for _, p := range points {
+		centroid = centroid.Add(p)
+	}
+	centroid = centroid.Scale(1 / float64(len(points)))
+
+	hull := convexHull2D(points)
+	yaw, bestArea := 0.0, math.Inf(1)
+	for i := range hull {
+		edge := hull[(i+1)%len(hull)].Sub(hull[i])
+		angle := math.Atan2(edge.Y, edge.X)
+		lo, hi := extents(hull, angle)
+		if area := (hi[0] - lo[0]) * (hi[1] - lo[1]); area < bestArea {
+			yaw, bestArea = angle, area
+		}
+	}
+
+	// the first axis along the longer side, yaw in (-90, 90] degrees
+	if lo, hi := extents(hull, yaw); hi[0]-lo[0] < hi[1]-lo[1] {
+		yaw += math.Pi / 2
+	}
+	yaw = math.Mod(yaw, math.Pi)
+	if yaw <= -math.Pi/2 {
+		yaw += math.Pi
+	} else if yaw > math.Pi/2 {
+		yaw -= math.Pi
+	}
+
+	lo, hi := extents(points, yaw)
+	box.Axes = RotationFromEuler(0, 0, yaw)
+	box.Center = box.Axes.MulVec(Point{X: (lo[0] + hi[0]) / 2, Y: (lo[1] + hi[1]) / 2, Z: (lo[2] + hi[2]) / 2})
+	box.Size = Point{X: hi[0] - lo[0], Y: hi[1] - lo[1], Z: hi[2] - lo[2]}
+	return centroid, box
+}
+
+// extents returns the min and max coordinates of the points in the frame
+// rotated by yaw about Z.
+func extents(points []Point, yaw float64) (lo, hi [3]float64) {
+	c, s := math.Cos(yaw), math.Sin(yaw)
+	lo = [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi = [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range points {
+		v := [3]float64{c*p.X + s*p.Y, -s*p.X + c*p.Y, p.Z}
+		for j := range v {
+			lo[j] = math.Min(lo[j], v[j])
+			hi[j] = math.Max(hi[j], v[j])
+		}
+	}
+	return lo, hi
+}
+
+// convexHull2D returns the convex hull of the points projected on the XY
+// plane in counterclockwise order (Andrew's monotone chain).
+func convexHull2D(points []Point) []Point {
+	sorted := append([]Point(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].X != sorted[j].X {
+			return sorted[i].X < sorted[j].X
+		}
+		return sorted[i].Y < sorted[j].Y
+	})
+	if len(sorted) < 3 {
+		return sorted
+	}
+
+	cross := func(o, a, b Point) float64 {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	hull := make([]Point, 0, 2*len(sorted))
+	for _, p := range sorted { // lower hull
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(sorted) - 2; i >= 0; i-- { // upper hull
+		p := sorted[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
@@ -0,0 +1,25 @@
+package cloud
+
+import "github.com/knei-knurow/lidar-tools/spatial"
+
+// Changes compares a scan with a reference scan of the same scene, both in the
+// same frame. Scan points farther than the threshold from every reference
+// point appeared, reference points farther than the threshold from every scan
+// point disappeared. The indices of such points are returned.
+func Changes(reference, scan []Point, threshold float64) (appeared, disappeared []int) {
+	appeared = farPoints(scan, spatial.NewKDTree(reference), threshold)
+	disappeared = farPoints(reference, spatial.NewKDTree(scan), threshold)
+	return appeared, disappeared
+}
+
+// farPoints returns the indices of points farther than the threshold from all
+// points of the tree.
+func farPoints(points []Point, tree *spatial.KDTree, threshold float64) []int {
+	var far []int
+	for i, p := range points {
+		if n, ok := tree.NearestOne(p); !ok || n.Dist2 > threshold*threshold {
+			far = append(far, i)
+		}
+	}
+	return far
+}
@@ -0,0 +1,51 @@
+package cloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+// boxScene returns a floor with a box of the size standing on it at x, y and
+// the indices of the box points higher than minZ above the floor.
+func boxScene(x, y, size, minZ float64) (points []Point, high []int) {
+	const step = 20
+	for px := -1000.0; px <= 1000; px += step {
+		for py := -1000.0; py <= 1000; py += step {
+			points = append(points, Point{X: px, Y: py})
+		}
+	}
+	add := func(p Point) {
+		if p.Z > minZ {
+			high = append(high, len(points))
+		}
+		points = append(points, p)
+	}
+	for a := 0.0; a <= size; a += step {
+		for b := 0.0; b <= size; b += step {
+			add(Point{X: x + a, Y: y + b, Z: size}) // top
+			add(Point{X: x, Y: y + a, Z: b})        // sides
+			add(Point{X: x + size, Y: y + a, Z: b})
+			add(Point{X: x + a, Y: y, Z: b})
+			add(Point{X: x + a, Y: y + size, Z: b})
+		}
+	}
+	return points, high
+}
+
+func TestChanges(t *testing.T) {
+	const threshold = 50
+	reference, moved := boxScene(300, 200, 300, threshold)
+	scan, placed := boxScene(-700, -500, 300, threshold)
+
+	appeared, disappeared := Changes(reference, scan, threshold)
+	if !reflect.DeepEqual(appeared, placed) {
+		t.Errorf("%d points appeared, want the %d points of the box at its new place", len(appeared), len(placed))
+	}
+	if !reflect.DeepEqual(disappeared, moved) {
+		t.Errorf("%d points disappeared, want the %d points of the box at its old place", len(disappeared), len(moved))
+	}
+
+	if appeared, disappeared := Changes(reference, reference, threshold); len(appeared) != 0 || len(disappeared) != 0 {
+		t.Errorf("%d points appeared and %d disappeared in the same scan", len(appeared), len(disappeared))
+	}
+}
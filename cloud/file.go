@@ -0,0 +1,64 @@
+package cloud
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadFile reads a cloud, the format is chosen by the extension: ".ply" for
+// PLY, ".pcd" for PCD, text (see XYZReader) otherwise. "-" reads text from
+// stdin.
+func ReadFile(path string) (*Cloud, error) {
+	if path == "-" {
+		return ReadXYZ(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c *Cloud
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ply":
+		c, err = ReadPLY(f)
+	case ".pcd":
+		c, err = ReadPCD(f)
+	default:
+		c, err = ReadXYZ(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// WriteFile writes a cloud, the format is chosen by the extension: ".ply" for
+// binary PLY, ".pcd" for binary PCD, text otherwise. "-" writes text to stdout.
+// Labels, normals and colors are written only to PLY and PCD.
+func WriteFile(path string, c *Cloud) error {
+	if path == "-" {
+		return WriteXYZ(os.Stdout, c)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	write := WriteXYZ
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ply":
+		write = WritePLY
+	case ".pcd":
+		write = WritePCD
+	}
+	if err := write(f, c); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,297 @@
+package cloud
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// Filter removes or replaces points of a cloud.
+type Filter interface {
+	// Apply returns the filtered points, the input is not modified.
+	Apply(points []Point) []Point
+	// String returns the filter in the form accepted by ParseFilters.
+	String() string
+}
+
+// VoxelGrid downsamples the cloud by replacing all points within each cube
+// (voxel) of the grid by their centroid.
+type VoxelGrid struct {
+	Size float64 // voxel edge length
+}
+
+func (f VoxelGrid) Apply(points []Point) []Point {
+	type voxel struct {
+		sum   Point
+		count int
+	}
+	voxels := make(map[[3]int64]*voxel)
+	var order [][3]int64 // keeps the output deterministic
+	for _, p := range points {
+		key := [3]int64{
+			int64(math.Floor(p.X / f.Size)),
+			int64(math.Floor(p.Y / f.Size)),
+			int64(math.Floor(p.Z / f.Size)),
+		}
+		v, ok := voxels[key]
+		if !ok {
+			v = &voxel{}
+			voxels[key] = v
+			order = append(order, key)
+		}
+		v.sum = v.sum.Add(p)
+		v.count++
+	}
+
+	result := make([]Point, len(order))
+	for i, key := range order {
+		v := voxels[key]
+		result[i] = v.sum.Scale(1 / float64(v.count))
+	}
+	return result
+}
+
+func (f VoxelGrid) String() string {
+	return fmt.Sprintf("voxel:size=%g", f.Size)
+}
+
+// RadiusOutlier removes points with less than MinNeighbors other points within
+// Radius.
+type RadiusOutlier struct {
+	Radius       float64
+	MinNeighbors int
+}
+
+func (f RadiusOutlier) Apply(points []Point) []Point {
+	tree := spatial.NewKDTree(points)
+	result := make([]Point, 0, len(points))
+	for _, p := range points {
+		if len(tree.Radius(p, f.Radius))-1 >= f.MinNeighbors { // the point itself is found too
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (f RadiusOutlier) String() string {
+	return fmt.Sprintf("radius:r=%g:min=%d", f.Radius, f.MinNeighbors)
+}
+
+// StatisticalOutlier removes points whose mean distance to their K nearest
+// neighbors is greater than the mean of these distances over the whole cloud
+// plus StdMul standard deviations.
+type StatisticalOutlier struct {
+	K      int
+	StdMul float64
+}
+
+func (f StatisticalOutlier) Apply(points []Point) []Point {
+	if len(points) <= f.K {
+		return append([]Point(nil), points...)
+	}
+
+	tree := spatial.NewKDTree(points)
+	meanDists := make([]float64, len(points))
+	var sum, sum2 float64
+	for i, p := range points {
+		neighbors := tree.Nearest(p, f.K+1) // the point itself is the nearest one
+		var d float64
+		for _, n := range neighbors[1:] {
+			d += math.Sqrt(n.Dist2)
+		}
+		d /= float64(len(neighbors) - 1)
+		meanDists[i] = d
+		sum += d
+		sum2 += d * d
+	}
+
+	n := float64(len(points))
+	mean := sum / n
+	std := math.Sqrt(math.Max(0, sum2/n-mean*mean))
+	threshold := mean + f.StdMul*std
+
+	result := make([]Point, 0, len(points))
+	for i, p := range points {
+		if meanDists[i] <= threshold {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (f StatisticalOutlier) String() string {
+	return fmt.Sprintf("sor:k=%d:std=%g", f.K, f.StdMul)
+}
+
+// RangeCrop keeps points whose distance from the origin (the lidar) is within
+// [Min, Max].
+type RangeCrop struct {
+	Min, Max float64
+}
+
+func (f RangeCrop) Apply(points []Point) []Point {
+	result := make([]Point, 0, len(points))
+	for _, p := range points {
+		if r := p.Norm(); r >= f.Min && r <= f.Max {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (f RangeCrop) String() string {
+	return fmt.Sprintf("range:min=%g:max=%g", f.Min, f.Max)
+}
+
+// AngleCrop keeps points whose azimuth (in the XY plane, from the X axis towards
+// Y) and elevation (from the XY plane towards Z) are within the limits, in
+// degrees. The azimuth range may wrap around, e.g. from 270 to 90.
+type AngleCrop struct {
+	AzimuthMin, AzimuthMax     float64
+	ElevationMin, ElevationMax float64
+}
+
+func (f AngleCrop) Apply(points []Point) []Point {
+	azMin := normalizeDeg(f.AzimuthMin)
+	azMax := normalizeDeg(f.AzimuthMax)
+	fullCircle := f.AzimuthMax-f.AzimuthMin >= 360
+
+	result := make([]Point, 0, len(points))
+	for _, p := range points {
+		az := normalizeDeg(math.Atan2(p.Y, p.X) * 180 / math.Pi)
+		el := math.Atan2(p.Z, math.Hypot(p.X, p.Y)) * 180 / math.Pi
+		inAz := fullCircle || (azMin <= azMax && az >= azMin && az <= azMax) || (azMin > azMax && (az >= azMin || az <= azMax))
+		if inAz && el >= f.ElevationMin && el <= f.ElevationMax {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (f AngleCrop) String() string {
+	return fmt.Sprintf("angle:azmin=%g:azmax=%g:elmin=%g:elmax=%g", f.AzimuthMin, f.AzimuthMax, f.ElevationMin, f.ElevationMax)
+}
+
+// normalizeDeg returns the angle in [0, 360).
+func normalizeDeg(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// Pipeline applies filters in order.
+type Pipeline []Filter
+
+func (pipeline Pipeline) Apply(points []Point) []Point {
+	for _, f := range pipeline {
+		points = f.Apply(points)
+	}
+	return points
+}
+
+func (pipeline Pipeline) String() string {
+	specs := make([]string, len(pipeline))
+	for i, f := range pipeline {
+		specs[i] = f.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+// FilterHelp describes the filter specification accepted by ParseFilters.
+const FilterHelp = `comma separated filters applied in order, each "name:param=value:...":
+  voxel:size=S               replace points in each SxSxS voxel by their centroid
+  radius:r=R:min=N           remove points with less than N neighbors within R
+  sor:k=K:std=M              remove points whose mean distance to K nearest neighbors
+                             exceeds the cloud mean by more than M standard deviations
+  range:min=A:max=B          keep points with the distance from the lidar in [A, B]
+  angle:azmin=A:azmax=B:elmin=C:elmax=D
+                             keep points with the azimuth in [A, B] and elevation in [C, D] (degrees)
+distances are in cloud units (millimeters for sync)`
+
+// ParseFilters parses the filter specification, see FilterHelp. An empty spec
+// results in an empty pipeline.
+func ParseFilters(spec string) (Pipeline, error) {
+	var pipeline Pipeline
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		params := make(map[string]float64)
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("filter %q: invalid parameter %q", item, param)
+			}
+			v, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("filter %q: invalid value of %s", item, kv[0])
+			}
+			params[kv[0]] = v
+		}
+
+		f, err := newFilter(parts[0], params)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %v", item, err)
+		}
+		pipeline = append(pipeline, f)
+	}
+	return pipeline, nil
+}
+
+// newFilter creates the filter, params are removed as they are used.
+func newFilter(name string, params map[string]float64) (Filter, error) {
+	get := func(key string, def float64) float64 {
+		v, ok := params[key]
+		if !ok {
+			return def
+		}
+		delete(params, key)
+		return v
+	}
+
+	var f Filter
+	switch name {
+	case "voxel":
+		size := get("size", 0)
+		if size <= 0 {
+			return nil, fmt.Errorf("size must be positive")
+		}
+		f = VoxelGrid{Size: size}
+	case "radius":
+		r := get("r", 0)
+		if r <= 0 {
+			return nil, fmt.Errorf("r must be positive")
+		}
+		f = RadiusOutlier{Radius: r, MinNeighbors: int(get("min", 1))}
+	case "sor":
+		k := int(get("k", 16))
+		if k < 1 {
+			return nil, fmt.Errorf("k must be positive")
+		}
+		f = StatisticalOutlier{K: k, StdMul: get("std", 1)}
+	case "range":
+		f = RangeCrop{Min: get("min", 0), Max: get("max", math.Inf(1))}
+	case "angle":
+		f = AngleCrop{
+			AzimuthMin:   get("azmin", 0),
+			AzimuthMax:   get("azmax", 360),
+			ElevationMin: get("elmin", -90),
+			ElevationMax: get("elmax", 90),
+		}
+	default:
+		return nil, fmt.Errorf("unknown filter %q", name)
+	}
+
+	for key := range params {
+		return nil, fmt.Errorf("unknown parameter %q", key)
+	}
+	return f, nil
+}
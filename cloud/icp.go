@@ -0,0 +1,216 @@
+package cloud
+
+import (
+	"errors"
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// ICPOptions configure ICP registration.
+type ICPOptions struct {
+	MaxIterations   int
+	MaxDistance     float64 // correspondences farther apart are rejected
+	Tolerance       float64 // stop when the RMSE changes less than that
+	NormalNeighbors int     // neighbors used to estimate target normals
+	Damping         float64 // weight of the displacement of source points by a step, see ICP
+}
+
+// DefaultICPOptions are suitable for sync's clouds (millimeters).
+var DefaultICPOptions = ICPOptions{
+	MaxIterations:   50,
+	MaxDistance:     500,
+	Tolerance:       1e-4,
+	NormalNeighbors: 16,
+}
+
+// ICPResult is the outcome of the registration.
+type ICPResult struct {
+	Transform  Transform // moves the source onto the target
+	Fitness    float64   // fraction of source points with a correspondence
+	RMSE       float64   // root mean square distance of corresponding points
+	Iterations int
+	Converged  bool
+}
+
+// ErrICPDegenerate is returned when the correspondences do not constrain the
+// transform, e.g. there are too few of them or they all lie on a plane.
+var ErrICPDegenerate = errors.New("icp: degenerate correspondences")
+
+// Target is a cloud prepared to be registered against.
+type Target struct {
+	Points  []Point
+	Normals []Point
+	tree    *spatial.KDTree
+}
+
+// NewTarget builds the search tree and estimates normals of the points.
+func NewTarget(points []Point, normalNeighbors int) *Target {
+	tree := spatial.NewKDTree(points)
+	return &Target{
+		Points:  points,
+		Normals: EstimateNormals(points, tree, normalNeighbors, Point{}),
+		tree:    tree,
+	}
+}
+
+// ICP registers source against target with the point-to-plane ICP starting
+// from the initial transform. With opts.Damping, every step also minimizes the
+// squared displacements of the source points by it, weighted by Damping.
+// The transform then stays put in directions the correspondences don't
+// constrain, e.g. along a corridor, instead of following the noise.
+func ICP(source []Point, target *Target, initial Transform, opts ICPOptions) (ICPResult, error) {
+	result := ICPResult{Transform: initial, RMSE: math.Inf(1)}
+	if len(source) == 0 || len(target.Points) == 0 {
+		return result, ErrICPDegenerate
+	}
+
+	for result.Iterations < opts.MaxIterations {
+		result.Iterations++
+
+		// linearized point-to-plane error with small rotation w and translation t:
+		// e = (s x n).w + n.t + (s - q).n
+		var ata [6][6]float64
+		var atb [6]float64
+		var sum2 float64
+		var sumS Point          // sum of the source points
+		var sumSS [3][3]float64 // sum of their outer products
+		inliers := 0
+		for _, p := range source {
+			s := result.Transform.Apply(p)
+			nearest, ok := target.tree.NearestOne(s)
+			if !ok || nearest.Dist2 > opts.MaxDistance*opts.MaxDistance {
+				continue
+			}
+			n := target.Normals[nearest.Index]
+			if n == (Point{}) {
+				continue
+			}
+			inliers++
+			sum2 += nearest.Dist2
+			if opts.Damping > 0 {
+				sumS = sumS.Add(s)
+				v := [3]float64{s.X, s.Y, s.Z}
+				for i := 0; i < 3; i++ {
+					for j := 0; j < 3; j++ {
+						sumSS[i][j] += v[i] * v[j]
+					}
+				}
+			}
+
+			c := s.Cross(n)
+			row := [6]float64{c.X, c.Y, c.Z, n.X, n.Y, n.Z}
+			e := s.Sub(target.Points[nearest.Index]).Dot(n)
+			for i := 0; i < 6; i++ {
+				for j := 0; j < 6; j++ {
+					ata[i][j] += row[i] * row[j]
+				}
+				atb[i] -= row[i] * e
+			}
+		}
+		if inliers < 6 {
+			return result, ErrICPDegenerate
+		}
+
+		rmse := math.Sqrt(sum2 / float64(inliers))
+		result.Fitness = float64(inliers) / float64(len(source))
+		if math.Abs(result.RMSE-rmse) < opts.Tolerance {
+			result.RMSE = rmse
+			result.Converged = true
+			break
+		}
+		result.RMSE = rmse
+
+		if opts.Damping > 0 {
+			// the displacement of s by w and t is w x s + t, its square summed
+			// over the points is a quadratic form of (w, t)
+			d := opts.Damping
+			s2 := sumSS[0][0] + sumSS[1][1] + sumSS[2][2]
+			cross := [3][3]float64{{0, -sumS.Z, sumS.Y}, {sumS.Z, 0, -sumS.X}, {-sumS.Y, sumS.X, 0}}
+			for i := 0; i < 3; i++ {
+				ata[i][i] += d * s2
+				ata[i+3][i+3] += d * float64(inliers)
+				for j := 0; j < 3; j++ {
+					ata[i][j] -= d * sumSS[i][j]
+					ata[i][j+3] += d * cross[i][j]
+					ata[j+3][i] += d * cross[i][j]
+				}
+			}
+		}
+		x, ok := solve6(ata, atb)
+		if !ok {
+			return result, ErrICPDegenerate
+		}
+		delta := Transform{
+			R: RotationFromVector(Point{X: x[0], Y: x[1], Z: x[2]}),
+			T: Point{X: x[3], Y: x[4], Z: x[5]},
+		}
+		result.Transform = delta.Mul(result.Transform)
+	}
+
+	if !result.Converged { // the metrics are of the transform before the last update
+		result.Fitness, result.RMSE = Evaluate(source, target, result.Transform, opts.MaxDistance)
+	}
+	return result, nil
+}
+
+// Evaluate returns the fitness and RMSE (see ICPResult) of source transformed
+// by tf against target.
+func Evaluate(source []Point, target *Target, tf Transform, maxDistance float64) (fitness, rmse float64) {
+	var sum2 float64
+	inliers := 0
+	for _, p := range source {
+		nearest, ok := target.tree.NearestOne(tf.Apply(p))
+		if ok && nearest.Dist2 <= maxDistance*maxDistance {
+			inliers++
+			sum2 += nearest.Dist2
+		}
+	}
+	if inliers == 0 || len(source) == 0 {
+		return 0, math.Inf(1)
+	}
+	return float64(inliers) / float64(len(source)), math.Sqrt(sum2 / float64(inliers))
+}
+
+// solve6 solves a x = b using Gaussian elimination with partial pivoting. ok
+// is false if a is (nearly) singular.
+func solve6(a [6][6]float64, b [6]float64) (x [6]float64, ok bool) {
+	var scale float64
+	for i := 0; i < 6; i++ {
+		scale = math.Max(scale, math.Abs(a[i][i]))
+	}
+	if scale == 0 {
+		return x, false
+	}
+
+	for col := 0; col < 6; col++ {
+		pivot := col
+		for row := col + 1; row < 6; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12*scale {
+			return x, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < 6; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < 6; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+
+	for row := 5; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < 6; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, true
+}
@@ -0,0 +1,272 @@
+package cloud
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Mesh is a triangle mesh.
+type Mesh struct {
+	Vertices  []Point
+	Triangles [][3]int // vertex indices, counterclockwise seen from the lidar
+}
+
+// Scan is a single 2D lidar scan of a sweep (one servo step), a row of the
+// structured cloud.
+type Scan struct {
+	Angle  float64 // servo angle in degrees, NaN if unknown
+	Points []Point // in the order of measurement
+}
+
+// ReadScans reads scans of a text cloud, see XYZReader.
+func ReadScans(r io.Reader) ([]Scan, error) {
+	xyz := NewXYZReader(r)
+	var scans []Scan
+	for {
+		points, err := xyz.Next()
+		if err == io.EOF {
+			return scans, nil
+		}
+		if err != nil {
+			return scans, err
+		}
+		scans = append(scans, Scan{Angle: xyz.Angle(), Points: points})
+	}
+}
+
+// SplitSweeps splits scans into sweeps where the servo changes direction. The
+// first and the last sweep may be incomplete. Scans without angles form a
+// single sweep.
+func SplitSweeps(scans []Scan) [][]Scan {
+	var sweeps [][]Scan
+	start, direction := 0, 0.0
+	for i := 1; i < len(scans); i++ {
+		d := scans[i].Angle - scans[i-1].Angle
+		if math.IsNaN(d) || d == 0 {
+			continue
+		}
+		if direction != 0 && (d > 0) != (direction > 0) {
+			sweeps = append(sweeps, scans[start:i])
+			start = i
+		}
+		direction = d
+	}
+	if start < len(scans) {
+		sweeps = append(sweeps, scans[start:])
+	}
+	return sweeps
+}
+
+// MeshOptions configure grid triangulation.
+type MeshOptions struct {
+	ColumnStep    float64 // lidar angle covered by a grid column in degrees
+	MaxEdge       float64 // triangles with longer edges are dropped
+	MaxRangeRatio float64 // triangles whose vertex ranges differ more are dropped (depth discontinuities)
+}
+
+// DefaultMeshOptions are suitable for sync's clouds (millimeters).
+var DefaultMeshOptions = MeshOptions{
+	ColumnStep:    1,
+	MaxEdge:       300,
+	MaxRangeRatio: 1.3,
+}
+
+// GridMesh triangulates a sweep using its structure: scans are rows of a grid
+// and lidar angles are its columns. The lidar is at the origin and tilts about
+// an axis lying in every scan plane, the angle of a point is measured within
+// its scan plane from this axis. Neighboring grid points are joined into
+// triangles, unless an edge is longer than MaxEdge or the ranges of vertices
+// differ by more than MaxRangeRatio, which happens where one surface occludes
+// another.
+func GridMesh(sweep []Scan, opts MeshOptions) (*Mesh, error) {
+	rows := make([]Scan, 0, len(sweep))
+	for _, s := range sweep {
+		if len(s.Points) >= 3 {
+			rows = append(rows, s)
+		}
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("mesh: %d scans with enough points, at least 2 are needed", len(rows))
+	}
+	if !math.IsNaN(rows[0].Angle) {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Angle < rows[j].Angle })
+	}
+
+	// scan plane normals and the tilt axis, which is perpendicular to all of them
+	normals := make([]Point, len(rows))
+	var axisCov Mat3
+	for i, row := range rows {
+		var cov Mat3
+		for _, p := range row.Points {
+			addOuter(&cov, p)
+		}
+		normals[i] = smallestEigenvector(cov)
+		if i > 0 && normals[i].Dot(normals[i-1]) < 0 {
+			normals[i] = normals[i].Scale(-1) // consistent orientation
+		}
+		addOuter(&axisCov, normals[i])
+	}
+	axis := smallestEigenvector(axisCov)
+
+	columns := int(math.Round(360 / opts.ColumnStep))
+	if columns < 3 {
+		return nil, fmt.Errorf("mesh: column step %g is too large", opts.ColumnStep)
+	}
+	step := 360 / float64(columns)
+
+	// grid of vertex indices, -1 where there is no point
+	mesh := &Mesh{}
+	grid := make([][]int, len(rows))
+	for i, row := range rows {
+		grid[i] = make([]int, columns)
+		for c := range grid[i] {
+			grid[i][c] = -1
+		}
+		e2 := normals[i].Cross(axis)
+		for _, p := range row.Points {
+			angle := math.Atan2(p.Dot(e2), p.Dot(axis)) * 180 / math.Pi
+			c := int(math.Floor(angle/step)) % columns
+			if c < 0 {
+				c += columns
+			}
+			if grid[i][c] < 0 {
+				grid[i][c] = len(mesh.Vertices)
+				mesh.Vertices = append(mesh.Vertices, p)
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(rows); i++ {
+		for c := 0; c < columns; c++ {
+			c2 := (c + 1) % columns
+			a, b := grid[i][c], grid[i][c2]
+			d, e := grid[i+1][c], grid[i+1][c2]
+			switch {
+			case a >= 0 && b >= 0 && d >= 0 && e >= 0:
+				// split the quad along the shorter diagonal
+				if mesh.Vertices[a].Dist2(mesh.Vertices[e]) <= mesh.Vertices[b].Dist2(mesh.Vertices[d]) {
+					mesh.addTriangle(a, b, e, opts)
+					mesh.addTriangle(a, e, d, opts)
+				} else {
+					mesh.addTriangle(a, b, d, opts)
+					mesh.addTriangle(b, e, d, opts)
+				}
+			case b >= 0 && d >= 0 && e >= 0:
+				mesh.addTriangle(b, e, d, opts)
+			case a >= 0 && d >= 0 && e >= 0:
+				mesh.addTriangle(a, e, d, opts)
+			case a >= 0 && b >= 0 && e >= 0:
+				mesh.addTriangle(a, b, e, opts)
+			case a >= 0 && b >= 0 && d >= 0:
+				mesh.addTriangle(a, b, d, opts)
+			}
+		}
+	}
+
+	mesh.removeUnused()
+	return mesh, nil
+}
+
+// addTriangle adds the triangle if it passes the thresholds, facing the lidar.
+func (mesh *Mesh) addTriangle(a, b, c int, opts MeshOptions) {
+	pa, pb, pc := mesh.Vertices[a], mesh.Vertices[b], mesh.Vertices[c]
+	maxEdge2 := opts.MaxEdge * opts.MaxEdge
+	if pa.Dist2(pb) > maxEdge2 || pb.Dist2(pc) > maxEdge2 || pc.Dist2(pa) > maxEdge2 {
+		return
+	}
+	ra, rb, rc := pa.Norm(), pb.Norm(), pc.Norm()
+	if math.Max(ra, math.Max(rb, rc)) > opts.MaxRangeRatio*math.Min(ra, math.Min(rb, rc)) {
+		return
+	}
+
+	if pb.Sub(pa).Cross(pc.Sub(pa)).Dot(pa) > 0 { // the normal points away from the lidar
+		b, c = c, b
+	}
+	mesh.Triangles = append(mesh.Triangles, [3]int{a, b, c})
+}
+
+// removeUnused removes vertices which are not in any triangle.
+func (mesh *Mesh) removeUnused() {
+	index := make([]int, len(mesh.Vertices))
+	for i := range index {
+		index[i] = -1
+	}
+	var vertices []Point
+	for t := range mesh.Triangles {
+		for j, v := range mesh.Triangles[t] {
+			if index[v] < 0 {
+				index[v] = len(vertices)
+				vertices = append(vertices, mesh.Vertices[v])
+			}
+			mesh.Triangles[t][j] = index[v]
+		}
+	}
+	mesh.Vertices = vertices
+}
+
+// WriteOBJ writes the mesh as a Wavefront OBJ file.
+func WriteOBJ(w io.Writer, mesh *Mesh) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# lidar-tools\n")
+	for _, v := range mesh.Vertices {
+		fmt.Fprintf(bw, "v %g %g %g\n", v.X, v.Y, v.Z)
+	}
+	for _, t := range mesh.Triangles {
+		fmt.Fprintf(bw, "f %d %d %d\n", t[0]+1, t[1]+1, t[2]+1)
+	}
+	return bw.Flush()
+}
+
+// WritePLYMesh writes the mesh as a binary little-endian PLY file with float
+// x, y, z vertex properties and triangle faces.
+func WritePLYMesh(w io.Writer, mesh *Mesh) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat %s 1.0\ncomment lidar-tools\n", plyLittleEndian)
+	fmt.Fprintf(bw, "element vertex %d\nproperty float x\nproperty float y\nproperty float z\n", len(mesh.Vertices))
+	fmt.Fprintf(bw, "element face %d\nproperty list uchar int vertex_indices\nend_header\n", len(mesh.Triangles))
+
+	var buf [13]byte
+	for _, v := range mesh.Vertices {
+		putPoint(buf[:], v)
+		bw.Write(buf[:12])
+	}
+	buf[0] = 3
+	for _, t := range mesh.Triangles {
+		for j, v := range t {
+			binary.LittleEndian.PutUint32(buf[1+4*j:], uint32(v))
+		}
+		bw.Write(buf[:13])
+	}
+	return bw.Flush()
+}
+
+// WriteMeshFile writes the mesh, the format is chosen by the extension: ".obj"
+// for OBJ, ".ply" for binary PLY.
+func WriteMeshFile(path string, mesh *Mesh) error {
+	var write func(io.Writer, *Mesh) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".obj":
+		write = WriteOBJ
+	case ".ply":
+		write = WritePLYMesh
+	default:
+		return fmt.Errorf("%s: unknown mesh format, use .obj or .ply", path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, mesh); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,111 @@
+package cloud
+
+import (
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// EstimateNormals returns unit normals of points estimated from the plane
+// fitted (PCA) to their k nearest neighbors in the index. Normals are oriented
+// towards the viewpoint (the lidar). Points with less than 3 neighbors get a
+// zero normal.
+func EstimateNormals(points []Point, index spatial.Index, k int, viewpoint Point) []Point {
+	normals := make([]Point, len(points))
+	for i, p := range points {
+		neighbors := index.Nearest(p, k)
+		if len(neighbors) < 3 {
+			continue
+		}
+
+		var mean Point
+		for _, n := range neighbors {
+			mean = mean.Add(index.Point(n.Index))
+		}
+		mean = mean.Scale(1 / float64(len(neighbors)))
+
+		var cov Mat3
+		for _, n := range neighbors {
+			addOuter(&cov, index.Point(n.Index).Sub(mean))
+		}
+
+		normal := smallestEigenvector(cov)
+		if normal.Dot(viewpoint.Sub(p)) < 0 {
+			normal = normal.Scale(-1)
+		}
+		normals[i] = normal
+	}
+	return normals
+}
+
+// addOuter adds p * p^T to m.
+func addOuter(m *Mat3, p Point) {
+	v := [3]float64{p.X, p.Y, p.Z}
+	for a := 0; a < 3; a++ {
+		for b := 0; b < 3; b++ {
+			m[a][b] += v[a] * v[b]
+		}
+	}
+}
+
+// smallestEigenvector returns the unit eigenvector of the smallest eigenvalue
+// of a symmetric matrix.
+func smallestEigenvector(m Mat3) Point {
+	values, vectors := SymmetricEigen(m)
+	smallest := 0
+	for j := 1; j < 3; j++ {
+		if values[j] < values[smallest] {
+			smallest = j
+		}
+	}
+	return Point{X: vectors[0][smallest], Y: vectors[1][smallest], Z: vectors[2][smallest]}
+}
+
+// SymmetricEigen returns eigenvalues and eigenvectors (columns of the matrix)
+// of a symmetric matrix using the Jacobi eigenvalue algorithm.
+func SymmetricEigen(m Mat3) (values [3]float64, vectors Mat3) {
+	a := m
+	vectors = Identity3
+
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-30 {
+			break
+		}
+
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if math.Abs(a[p][q]) < 1e-300 {
+					continue
+				}
+
+				// rotation zeroing a[p][q]
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := vectors[k][p], vectors[k][q]
+					vectors[k][p] = c*vkp - s*vkq
+					vectors[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	return [3]float64{a[0][0], a[1][1], a[2][2]}, vectors
+}
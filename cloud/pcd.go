@@ -0,0 +1,274 @@
+package cloud
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type pcdField struct {
+	name  string
+	size  int
+	typ   byte // I - signed, U - unsigned, F - float
+	count int
+}
+
+// ReadPCD reads a PCD (Point Cloud Library) file with ASCII or binary data.
+// Points must have x, y and z fields, a label field is read to Labels,
+// normal_x, normal_y, normal_z fields to Normals and a packed rgb or rgba field
+// to Colors, other fields are skipped.
+// Compressed data is not supported.
+func ReadPCD(r io.Reader) (*Cloud, error) {
+	br := bufio.NewReader(r)
+	fields, points, data, err := readPCDHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	xyz := [3]int{-1, -1, -1}
+	label := -1
+	normal := [3]int{-1, -1, -1}
+	rgb := -1
+	offsets := make([]int, len(fields)) // index of the field's first value in a row
+	n := 0
+	for i, f := range fields {
+		offsets[i] = n
+		n += f.count
+		switch f.name {
+		case "x":
+			xyz[0] = i
+		case "y":
+			xyz[1] = i
+		case "z":
+			xyz[2] = i
+		case "label":
+			label = i
+		case "normal_x":
+			normal[0] = i
+		case "normal_y":
+			normal[1] = i
+		case "normal_z":
+			normal[2] = i
+		case "rgb", "rgba":
+			rgb = i
+		}
+	}
+	if xyz[0] < 0 || xyz[1] < 0 || xyz[2] < 0 {
+		return nil, errors.New("pcd: no x, y, z fields")
+	}
+
+	c := &Cloud{Points: make([]Point, 0, points)}
+	if label >= 0 {
+		c.Labels = make([]int, 0, points)
+	}
+	hasNormals := normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0
+	if hasNormals {
+		c.Normals = make([]Point, 0, points)
+	}
+	if rgb >= 0 {
+		c.Colors = make([]Color, 0, points)
+	}
+	values := make([]float64, n)
+	for i := 0; i < points; i++ {
+		switch data {
+		case "ascii":
+			err = readPCDASCIIRow(br, values)
+		case "binary":
+			err = readPCDBinaryRow(br, fields, values)
+		default:
+			return nil, fmt.Errorf("pcd: unsupported data %q", data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pcd: point %d: %v", i, err)
+		}
+		c.Points = append(c.Points, Point{X: values[offsets[xyz[0]]], Y: values[offsets[xyz[1]]], Z: values[offsets[xyz[2]]]})
+		if label >= 0 {
+			c.Labels = append(c.Labels, int(values[offsets[label]]))
+		}
+		if hasNormals {
+			c.Normals = append(c.Normals, Point{X: values[offsets[normal[0]]], Y: values[offsets[normal[1]]], Z: values[offsets[normal[2]]]})
+		}
+		if rgb >= 0 {
+			c.Colors = append(c.Colors, pcdColor(fields[rgb], values[offsets[rgb]]))
+		}
+	}
+	return c, nil
+}
+
+func readPCDHeader(br *bufio.Reader) (fields []pcdField, points int, data string, err error) {
+	points = -1
+	for data == "" {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("pcd: header: %v", err)
+		}
+		words := strings.Fields(line)
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+
+		key, args := words[0], words[1:]
+		switch key {
+		case "FIELDS":
+			fields = make([]pcdField, len(args))
+			for i, name := range args {
+				fields[i] = pcdField{name: name, size: 4, typ: 'F', count: 1}
+			}
+		case "SIZE", "TYPE", "COUNT":
+			if len(args) != len(fields) {
+				return nil, 0, "", fmt.Errorf("pcd: %s has %d values for %d fields", key, len(args), len(fields))
+			}
+			for i, arg := range args {
+				if key == "TYPE" {
+					fields[i].typ = arg[0]
+					continue
+				}
+				v, err := strconv.Atoi(arg)
+				if err != nil || v < 1 {
+					return nil, 0, "", fmt.Errorf("pcd: invalid %s %q", key, arg)
+				}
+				if key == "SIZE" {
+					fields[i].size = v
+				} else {
+					fields[i].count = v
+				}
+			}
+		case "POINTS":
+			if len(args) != 1 {
+				return nil, 0, "", errors.New("pcd: invalid POINTS")
+			}
+			if points, err = strconv.Atoi(args[0]); err != nil || points < 0 {
+				return nil, 0, "", errors.New("pcd: invalid POINTS")
+			}
+		case "DATA":
+			if len(args) != 1 {
+				return nil, 0, "", errors.New("pcd: invalid DATA")
+			}
+			data = args[0]
+		}
+	}
+
+	if fields == nil || points < 0 {
+		return nil, 0, "", errors.New("pcd: header has no FIELDS or POINTS")
+	}
+	for _, f := range fields {
+		if (f.typ == 'F' && f.size != 4 && f.size != 8) || (f.typ != 'F' && f.size != 1 && f.size != 2 && f.size != 4 && f.size != 8) {
+			return nil, 0, "", fmt.Errorf("pcd: field %s has unsupported type %c%d", f.name, f.typ, f.size)
+		}
+	}
+	return fields, points, data, nil
+}
+
+func readPCDASCIIRow(br *bufio.Reader, values []float64) error {
+	line, err := br.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return err
+	}
+	words := strings.Fields(line)
+	if len(words) != len(values) {
+		return fmt.Errorf("%d values, expected %d", len(words), len(values))
+	}
+	for i, word := range words {
+		if values[i], err = strconv.ParseFloat(word, 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPCDBinaryRow(br *bufio.Reader, fields []pcdField, values []float64) error {
+	var buf [8]byte
+	i := 0
+	for _, f := range fields {
+		for j := 0; j < f.count; j++ {
+			b := buf[:f.size]
+			if _, err := io.ReadFull(br, b); err != nil {
+				return err
+			}
+			values[i] = pcdValue(f, b)
+			i++
+		}
+	}
+	return nil
+}
+
+// pcdValue decodes a little-endian value of the field.
+func pcdValue(f pcdField, b []byte) float64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	switch {
+	case f.typ == 'F' && f.size == 4:
+		return float64(math.Float32frombits(uint32(u)))
+	case f.typ == 'F':
+		return math.Float64frombits(u)
+	case f.typ == 'I':
+		shift := 64 - 8*uint(f.size) // sign extension
+		return float64(int64(u<<shift) >> shift)
+	default:
+		return float64(u)
+	}
+}
+
+// pcdColor unpacks a color stored as 0x00RRGGBB, PCL writes it in a float
+// field bit by bit.
+func pcdColor(f pcdField, v float64) Color {
+	bits := uint32(v)
+	if f.typ == 'F' {
+		bits = math.Float32bits(float32(v))
+	}
+	return Color{uint8(bits >> 16), uint8(bits >> 8), uint8(bits)}
+}
+
+// WritePCD writes points as a binary PCD file with float x, y, z fields, float
+// normal_x, normal_y, normal_z fields if the cloud has normals, an unsigned
+// label field if it has labels and an unsigned rgb field packed as 0x00RRGGBB
+// if it has colors.
+func WritePCD(w io.Writer, c *Cloud) error {
+	if err := c.checkAttributes(); err != nil {
+		return err
+	}
+	fields, sizes, types, counts := "x y z", "4 4 4", "F F F", "1 1 1"
+	size := 12
+	if c.Normals != nil {
+		fields, sizes, types, counts = fields+" normal_x normal_y normal_z", sizes+" 4 4 4", types+" F F F", counts+" 1 1 1"
+		size += 12
+	}
+	if c.Labels != nil {
+		fields, sizes, types, counts = fields+" label", sizes+" 4", types+" U", counts+" 1"
+		size += 4
+	}
+	if c.Colors != nil {
+		fields, sizes, types, counts = fields+" rgb", sizes+" 4", types+" U", counts+" 1"
+		size += 4
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# .PCD v0.7 - Point Cloud Data file format\nVERSION 0.7\n")
+	fmt.Fprintf(bw, "FIELDS %s\nSIZE %s\nTYPE %s\nCOUNT %s\n", fields, sizes, types, counts)
+	fmt.Fprintf(bw, "WIDTH %d\nHEIGHT 1\nVIEWPOINT 0 0 0 1 0 0 0\nPOINTS %d\nDATA binary\n", len(c.Points), len(c.Points))
+
+	buf := make([]byte, size)
+	for i, p := range c.Points {
+		b := putPoint(buf, p)
+		if c.Normals != nil {
+			b = putPoint(b, c.Normals[i])
+		}
+		if c.Labels != nil {
+			binary.LittleEndian.PutUint32(b, uint32(c.Labels[i]))
+			b = b[4:]
+		}
+		if c.Colors != nil {
+			col := c.Colors[i]
+			binary.LittleEndian.PutUint32(b, uint32(col.R)<<16|uint32(col.G)<<8|uint32(col.B))
+		}
+		bw.Write(buf)
+	}
+	return bw.Flush()
+}
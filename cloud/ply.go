@@ -0,0 +1,337 @@
+package cloud
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PLY formats.
+const (
+	plyASCII        = "ascii"
+	plyLittleEndian = "binary_little_endian"
+	plyBigEndian    = "binary_big_endian"
+)
+
+// plySizes are sizes of PLY scalar types in bytes.
+var plySizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4, "float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+type plyProperty struct {
+	name      string
+	typ       string
+	countType string // type of the list length, empty if the property is not a list
+}
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// ReadPLY reads vertices of a PLY file (ASCII or binary). Vertices must have x,
+// y and z properties of any scalar type, a label property is read to Labels,
+// nx, ny, nz properties to Normals and red, green, blue properties to Colors,
+// other properties and elements are skipped.
+func ReadPLY(r io.Reader) (*Cloud, error) {
+	br := bufio.NewReader(r)
+	format, elements, err := readPLYHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch format {
+	case plyASCII:
+	case plyLittleEndian:
+		order = binary.LittleEndian
+	case plyBigEndian:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("ply: unsupported format %q", format)
+	}
+
+	c := &Cloud{}
+	for _, el := range elements {
+		xyz := [3]int{-1, -1, -1}
+		label := -1
+		normal := [3]int{-1, -1, -1}
+		rgb := [3]int{-1, -1, -1}
+		for i, prop := range el.properties {
+			switch prop.name {
+			case "x":
+				xyz[0] = i
+			case "y":
+				xyz[1] = i
+			case "z":
+				xyz[2] = i
+			case "label":
+				label = i
+			case "nx":
+				normal[0] = i
+			case "ny":
+				normal[1] = i
+			case "nz":
+				normal[2] = i
+			case "red":
+				rgb[0] = i
+			case "green":
+				rgb[1] = i
+			case "blue":
+				rgb[2] = i
+			}
+		}
+		isVertex := el.name == "vertex"
+		hasNormals := normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0
+		hasColors := rgb[0] >= 0 && rgb[1] >= 0 && rgb[2] >= 0
+		if isVertex && (xyz[0] < 0 || xyz[1] < 0 || xyz[2] < 0) {
+			return nil, errors.New("ply: vertex element has no x, y, z properties")
+		}
+		if isVertex {
+			c.Points = make([]Point, 0, el.count)
+			if label >= 0 {
+				c.Labels = make([]int, 0, el.count)
+			}
+			if hasNormals {
+				c.Normals = make([]Point, 0, el.count)
+			}
+			if hasColors {
+				c.Colors = make([]Color, 0, el.count)
+			}
+		}
+
+		values := make([]float64, len(el.properties))
+		for i := 0; i < el.count; i++ {
+			if order == nil {
+				err = readPLYASCIIRow(br, el, values)
+			} else {
+				err = readPLYBinaryRow(br, order, el, values)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ply: %s %d: %v", el.name, i, err)
+			}
+			if isVertex {
+				c.Points = append(c.Points, Point{X: values[xyz[0]], Y: values[xyz[1]], Z: values[xyz[2]]})
+				if label >= 0 {
+					c.Labels = append(c.Labels, int(values[label]))
+				}
+				if hasNormals {
+					c.Normals = append(c.Normals, Point{X: values[normal[0]], Y: values[normal[1]], Z: values[normal[2]]})
+				}
+				if hasColors {
+					c.Colors = append(c.Colors, Color{uint8(values[rgb[0]]), uint8(values[rgb[1]]), uint8(values[rgb[2]])})
+				}
+			}
+		}
+	}
+	return c, nil
+}
+
+func readPLYHeader(br *bufio.Reader) (format string, elements []plyElement, err error) {
+	readLine := func() (string, error) {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("ply: header: %v", err)
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	magic, err := readLine()
+	if err != nil {
+		return "", nil, err
+	}
+	if magic != "ply" {
+		return "", nil, errors.New("ply: not a PLY file")
+	}
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			return "", nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 {
+				return "", nil, errors.New("ply: invalid format line")
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("ply: invalid element line %q", line)
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return "", nil, fmt.Errorf("ply: invalid element count %q", fields[2])
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, errors.New("ply: property outside of an element")
+			}
+			var prop plyProperty
+			if len(fields) == 5 && fields[1] == "list" {
+				prop = plyProperty{name: fields[4], typ: fields[3], countType: fields[2]}
+			} else if len(fields) == 3 {
+				prop = plyProperty{name: fields[2], typ: fields[1]}
+			} else {
+				return "", nil, fmt.Errorf("ply: invalid property line %q", line)
+			}
+			if _, ok := plySizes[prop.typ]; !ok {
+				return "", nil, fmt.Errorf("ply: unknown type %q", prop.typ)
+			}
+			if _, ok := plySizes[prop.countType]; prop.countType != "" && !ok {
+				return "", nil, fmt.Errorf("ply: unknown type %q", prop.countType)
+			}
+			el := &elements[len(elements)-1]
+			el.properties = append(el.properties, prop)
+		case "end_header":
+			return format, elements, nil
+		}
+	}
+}
+
+// readPLYASCIIRow reads a row of values, lists are skipped (their value is 0).
+func readPLYASCIIRow(br *bufio.Reader, el plyElement, values []float64) error {
+	line, err := br.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return err
+	}
+	fields := strings.Fields(line)
+
+	for i, prop := range el.properties {
+		if len(fields) == 0 {
+			return errors.New("too few values")
+		}
+		if prop.countType != "" {
+			n, err := strconv.Atoi(fields[0])
+			if err != nil || n < 0 || n >= len(fields) {
+				return fmt.Errorf("invalid list %q", prop.name)
+			}
+			values[i] = 0
+			fields = fields[1+n:]
+			continue
+		}
+		if values[i], err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return err
+		}
+		fields = fields[1:]
+	}
+	return nil
+}
+
+// readPLYBinaryRow reads a row of values, lists are skipped (their value is 0).
+func readPLYBinaryRow(br *bufio.Reader, order binary.ByteOrder, el plyElement, values []float64) error {
+	var buf [8]byte
+	read := func(typ string) (float64, error) {
+		b := buf[:plySizes[typ]]
+		if _, err := io.ReadFull(br, b); err != nil {
+			return 0, err
+		}
+		switch typ {
+		case "char", "int8":
+			return float64(int8(b[0])), nil
+		case "uchar", "uint8":
+			return float64(b[0]), nil
+		case "short", "int16":
+			return float64(int16(order.Uint16(b))), nil
+		case "ushort", "uint16":
+			return float64(order.Uint16(b)), nil
+		case "int", "int32":
+			return float64(int32(order.Uint32(b))), nil
+		case "uint", "uint32":
+			return float64(order.Uint32(b)), nil
+		case "float", "float32":
+			return float64(math.Float32frombits(order.Uint32(b))), nil
+		default: // double
+			return math.Float64frombits(order.Uint64(b)), nil
+		}
+	}
+
+	for i, prop := range el.properties {
+		if prop.countType != "" {
+			n, err := read(prop.countType)
+			if err != nil {
+				return err
+			}
+			if _, err := br.Discard(int(n) * plySizes[prop.typ]); err != nil {
+				return err
+			}
+			values[i] = 0
+			continue
+		}
+
+		v, err := read(prop.typ)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	return nil
+}
+
+// WritePLY writes points as a binary little-endian PLY file with float x, y, z
+// vertex properties, float nx, ny, nz properties if the cloud has normals, an
+// int label property if it has labels and uchar red, green, blue properties if
+// it has colors.
+func WritePLY(w io.Writer, c *Cloud) error {
+	if err := c.checkAttributes(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat %s 1.0\ncomment lidar-tools\n", plyLittleEndian)
+	fmt.Fprintf(bw, "element vertex %d\nproperty float x\nproperty float y\nproperty float z\n", len(c.Points))
+	size := 12
+	if c.Normals != nil {
+		fmt.Fprintf(bw, "property float nx\nproperty float ny\nproperty float nz\n")
+		size += 12
+	}
+	if c.Labels != nil {
+		fmt.Fprintf(bw, "property int label\n")
+		size += 4
+	}
+	if c.Colors != nil {
+		fmt.Fprintf(bw, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+		size += 3
+	}
+	fmt.Fprintf(bw, "end_header\n")
+
+	buf := make([]byte, size)
+	for i, p := range c.Points {
+		b := putPoint(buf, p)
+		if c.Normals != nil {
+			b = putPoint(b, c.Normals[i])
+		}
+		if c.Labels != nil {
+			binary.LittleEndian.PutUint32(b, uint32(int32(c.Labels[i])))
+			b = b[4:]
+		}
+		if c.Colors != nil {
+			b[0], b[1], b[2] = c.Colors[i].R, c.Colors[i].G, c.Colors[i].B
+		}
+		bw.Write(buf)
+	}
+	return bw.Flush()
+}
+
+// putPoint writes the point as little-endian float32 x, y, z and returns the
+// rest of the buffer.
+func putPoint(buf []byte, p Point) []byte {
+	binary.LittleEndian.PutUint32(buf[0:], math.Float32bits(float32(p.X)))
+	binary.LittleEndian.PutUint32(buf[4:], math.Float32bits(float32(p.Y)))
+	binary.LittleEndian.PutUint32(buf[8:], math.Float32bits(float32(p.Z)))
+	return buf[12:]
+}
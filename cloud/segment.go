@@ -0,0 +1,227 @@
+package cloud
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Plane is the set of points p with Normal·p + D = 0, Normal is a unit vector.
+type Plane struct {
+	Normal Point
+	D      float64
+}
+
+// Distance returns the signed distance of p from the plane, positive on the
+// side the normal points to.
+func (pl Plane) Distance(p Point) float64 {
+	return pl.Normal.Dot(p) + pl.D
+}
+
+// planeThrough returns the plane through three points. ok is false if they are
+// (nearly) collinear.
+func planeThrough(a, b, c Point) (pl Plane, ok bool) {
+	n := b.Sub(a).Cross(c.Sub(a))
+	norm := n.Norm()
+	if norm < 1e-9 {
+		return Plane{}, false
+	}
+	n = n.Scale(1 / norm)
+	return Plane{Normal: n, D: -n.Dot(a)}, true
+}
+
+// FitPlane returns the least squares plane of the points (the normal is the
+// direction of the least variance). ok is false for less than 3 points.
+func FitPlane(points []Point) (pl Plane, ok bool) {
+	if len(points) < 3 {
+		return Plane{}, false
+	}
+
+	var mean Point
+	for _, p := range points {
+		mean = mean.Add(p)
+	}
+	mean = mean.Scale(1 / float64(len(points)))
+
+	var cov Mat3
+	for _, p := range points {
+		addOuter(&cov, p.Sub(mean))
+	}
+	n := smallestEigenvector(cov)
+	return Plane{Normal: n, D: -n.Dot(mean)}, true
+}
+
+// PlaneKind is the class of a plane by its orientation and position relative to
+// the lidar, Z is up.
+type PlaneKind int
+
+const (
+	PlaneOther   PlaneKind = iota // tilted plane
+	PlaneGround                   // horizontal plane below the lidar
+	PlaneCeiling                  // horizontal plane above the lidar
+	PlaneWall                     // vertical plane
+)
+
+func (kind PlaneKind) String() string {
+	switch kind {
+	case PlaneGround:
+		return "ground"
+	case PlaneCeiling:
+		return "ceiling"
+	case PlaneWall:
+		return "wall"
+	default:
+		return "other"
+	}
+}
+
+// RANSACOptions configure plane segmentation.
+type RANSACOptions struct {
+	Threshold      float64 // max distance of an inlier from the plane
+	MaxIterations  int     // max RANSAC iterations per plane
+	MinInliers     int     // extraction stops when no plane has that many inliers
+	MaxPlanes      int
+	AngleTolerance float64 // max deviation from horizontal or vertical in degrees
+	Seed           int64
+}
+
+// DefaultRANSACOptions are suitable for sync's clouds (millimeters).
+var DefaultRANSACOptions = RANSACOptions{
+	Threshold:      20,
+	MaxIterations:  1000,
+	MinInliers:     500,
+	MaxPlanes:      8,
+	AngleTolerance: 10,
+	Seed:           1,
+}
+
+// ransacConfidence is the probability that RANSAC samples an all-inlier
+// triple at least once, used to stop sampling early.
+const ransacConfidence = 0.999
+
+// Segment is a plane extracted from a cloud.
+type Segment struct {
+	ID      int // label of the inliers, the first segment is 1
+	Plane   Plane
+	Kind    PlaneKind
+	Inliers []int   // indices of the points
+	RMSE    float64 // root mean square distance of the inliers from the plane
+}
+
+// SegmentPlanes extracts planes from the points one by one, the largest first,
+// with RANSAC. Each plane is refined by a least squares fit to its inliers,
+// which are then removed from further search. labels contain the segment ID of
+// each point, 0 for points not on any plane.
+func SegmentPlanes(points []Point, opts RANSACOptions) (segments []Segment, labels []int) {
+	rng := rand.New(rand.NewSource(opts.Seed))
+	labels = make([]int, len(points))
+	remaining := make([]int, len(points))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	for len(segments) < opts.MaxPlanes && len(remaining) >= opts.MinInliers && len(remaining) >= 3 {
+		plane, ok := ransacPlane(points, remaining, opts, rng)
+		if !ok {
+			break
+		}
+
+		// refine with all inliers, then select them again with the refined plane
+		inliers := planeInliers(points, remaining, plane, opts.Threshold)
+		selected := make([]Point, len(inliers))
+		for i, index := range inliers {
+			selected[i] = points[index]
+		}
+		if refined, ok := FitPlane(selected); ok {
+			plane = refined
+			inliers = planeInliers(points, remaining, plane, opts.Threshold)
+		}
+		if len(inliers) < opts.MinInliers {
+			break
+		}
+
+		// normals point towards the lidar, which makes D the distance of the lidar
+		// from the plane
+		if plane.D < 0 {
+			plane = Plane{Normal: plane.Normal.Scale(-1), D: -plane.D}
+		}
+
+		segment := Segment{ID: len(segments) + 1, Plane: plane, Inliers: inliers}
+		segment.Kind = classifyPlane(plane, opts.AngleTolerance)
+		var sum2 float64
+		for _, index := range inliers {
+			d := plane.Distance(points[index])
+			sum2 += d * d
+			labels[index] = segment.ID
+		}
+		segment.RMSE = math.Sqrt(sum2 / float64(len(inliers)))
+		segments = append(segments, segment)
+
+		rest := remaining[:0]
+		for _, index := range remaining {
+			if labels[index] == 0 {
+				rest = append(rest, index)
+			}
+		}
+		remaining = rest
+	}
+	return segments, labels
+}
+
+// ransacPlane returns the plane through a random triple of the points with
+// the most inliers. The number of iterations adapts to the inlier ratio found.
+func ransacPlane(points []Point, indices []int, opts RANSACOptions, rng *rand.Rand) (best Plane, ok bool) {
+	bestCount := 0
+	iterations := opts.MaxIterations
+	for it := 0; it < iterations; it++ {
+		a := points[indices[rng.Intn(len(indices))]]
+		b := points[indices[rng.Intn(len(indices))]]
+		c := points[indices[rng.Intn(len(indices))]]
+		plane, valid := planeThrough(a, b, c)
+		if !valid {
+			continue
+		}
+
+		count := 0
+		for _, index := range indices {
+			if math.Abs(plane.Distance(points[index])) <= opts.Threshold {
+				count++
+			}
+		}
+		if count <= bestCount {
+			continue
+		}
+		best, bestCount, ok = plane, count, true
+
+		ratio := float64(count) / float64(len(indices))
+		needed := math.Log(1-ransacConfidence) / math.Log(1-ratio*ratio*ratio)
+		if needed < float64(iterations) {
+			iterations = int(math.Ceil(needed))
+		}
+	}
+	return best, ok
+}
+
+// planeInliers returns indices of the points within threshold from the plane.
+func planeInliers(points []Point, indices []int, plane Plane, threshold float64) []int {
+	var inliers []int
+	for _, index := range indices {
+		if math.Abs(plane.Distance(points[index])) <= threshold {
+			inliers = append(inliers, index)
+		}
+	}
+	return inliers
+}
+
+func classifyPlane(plane Plane, toleranceDeg float64) PlaneKind {
+	tilt := math.Acos(math.Min(1, math.Abs(plane.Normal.Z))) * 180 / math.Pi // angle from vertical normal
+	switch {
+	case tilt <= toleranceDeg && plane.Normal.Z > 0:
+		return PlaneGround // the normal points up, towards the lidar
+	case tilt <= toleranceDeg:
+		return PlaneCeiling
+	case tilt >= 90-toleranceDeg:
+		return PlaneWall
+	default:
+		return PlaneOther
+	}
+}
@@ -0,0 +1,186 @@
+package cloud
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Mat3 is a 3x3 matrix, row-major.
+type Mat3 [3][3]float64
+
+// Identity3 is the identity matrix.
+var Identity3 = Mat3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// MulVec returns m * p.
+func (m Mat3) MulVec(p Point) Point {
+	return Point{
+		X: m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z,
+		Y: m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z,
+		Z: m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z,
+	}
+}
+
+// Mul returns m * n.
+func (m Mat3) Mul(n Mat3) (r Mat3) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// Transpose returns the transposed matrix.
+func (m Mat3) Transpose() (r Mat3) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			r[i][j] = m[j][i]
+		}
+	}
+	return r
+}
+
+// RotationFromVector returns the rotation by |w| radians around w (Rodrigues' formula).
+func RotationFromVector(w Point) Mat3 {
+	angle := w.Norm()
+	if angle < 1e-12 {
+		return Identity3
+	}
+	k := w.Scale(1 / angle)
+	s, c := math.Sin(angle), math.Cos(angle)
+	t := 1 - c
+	return Mat3{
+		{c + k.X*k.X*t, k.X*k.Y*t - k.Z*s, k.X*k.Z*t + k.Y*s},
+		{k.Y*k.X*t + k.Z*s, c + k.Y*k.Y*t, k.Y*k.Z*t - k.X*s},
+		{k.Z*k.X*t - k.Y*s, k.Z*k.Y*t + k.X*s, c + k.Z*k.Z*t},
+	}
+}
+
+// RotationFromEuler returns the rotation by yaw around Z, then pitch around Y,
+// then roll around X (all in radians), i.e. R = Rz(yaw) * Ry(pitch) * Rx(roll).
+func RotationFromEuler(roll, pitch, yaw float64) Mat3 {
+	sr, cr := math.Sin(roll), math.Cos(roll)
+	sp, cp := math.Sin(pitch), math.Cos(pitch)
+	sy, cy := math.Sin(yaw), math.Cos(yaw)
+	return Mat3{
+		{cy * cp, cy*sp*sr - sy*cr, cy*sp*cr + sy*sr},
+		{sy * cp, sy*sp*sr + cy*cr, sy*sp*cr - cy*sr},
+		{-sp, cp * sr, cp * cr},
+	}
+}
+
+// Euler returns roll, pitch and yaw (in radians) of the rotation, see RotationFromEuler.
+func (m Mat3) Euler() (roll, pitch, yaw float64) {
+	pitch = math.Asin(math.Max(-1, math.Min(1, -m[2][0])))
+	roll = math.Atan2(m[2][1], m[2][2])
+	yaw = math.Atan2(m[1][0], m[0][0])
+	return
+}
+
+// Transform is a rigid transform, a rotation followed by a translation.
+type Transform struct {
+	R Mat3
+	T Point
+}
+
+// IdentityTransform does not move points.
+var IdentityTransform = Transform{R: Identity3}
+
+// Apply returns the transformed point.
+func (tf Transform) Apply(p Point) Point {
+	return tf.R.MulVec(p).Add(tf.T)
+}
+
+// ApplyAll returns transformed points.
+func (tf Transform) ApplyAll(points []Point) []Point {
+	result := make([]Point, len(points))
+	for i, p := range points {
+		result[i] = tf.Apply(p)
+	}
+	return result
+}
+
+// Mul returns the transform applying other first and then tf.
+func (tf Transform) Mul(other Transform) Transform {
+	return Transform{
+		R: tf.R.Mul(other.R),
+		T: tf.R.MulVec(other.T).Add(tf.T),
+	}
+}
+
+// Inverse returns the inverse transform.
+func (tf Transform) Inverse() Transform {
+	rt := tf.R.Transpose()
+	return Transform{R: rt, T: rt.MulVec(tf.T).Scale(-1)}
+}
+
+// String returns the transform as a 4x4 homogeneous matrix, one row per line.
+func (tf Transform) String() string {
+	r, t := tf.R, tf.T
+	return fmt.Sprintf("%.6f %.6f %.6f %.6f\n%.6f %.6f %.6f %.6f\n%.6f %.6f %.6f %.6f\n0 0 0 1",
+		r[0][0], r[0][1], r[0][2], t.X,
+		r[1][0], r[1][1], r[1][2], t.Y,
+		r[2][0], r[2][1], r[2][2], t.Z)
+}
+
+// ReadTransforms reads transforms of named scans, as written by cloudtool
+// merge: a "# name" line followed by the 4x4 matrix, one row per line.
+func ReadTransforms(r io.Reader) (map[string]Transform, error) {
+	transforms := make(map[string]Transform)
+	scanner := bufio.NewScanner(r)
+	var (
+		name string
+		rows [][]float64
+	)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			if len(rows) != 0 {
+				return nil, fmt.Errorf("line %d: incomplete matrix of %s", line, name)
+			}
+			name = strings.TrimSpace(text[1:])
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected 4 values", line)
+		}
+		row := make([]float64, 4)
+		for i, f := range fields {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			row[i] = v
+		}
+		rows = append(rows, row)
+		if len(rows) < 4 {
+			continue
+		}
+
+		var tf Transform
+		for i := 0; i < 3; i++ {
+			tf.R[i] = [3]float64{rows[i][0], rows[i][1], rows[i][2]}
+		}
+		tf.T = Point{X: rows[0][3], Y: rows[1][3], Z: rows[2][3]}
+		transforms[name] = tf
+		rows = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) != 0 {
+		return nil, fmt.Errorf("incomplete matrix of %s", name)
+	}
+	return transforms, nil
+}
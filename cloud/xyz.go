@@ -0,0 +1,205 @@
+package cloud
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// XYZReader reads text clouds with one "X Y Z" point per line, as printed by
+// sync. Coordinates may be separated by spaces, tabs or commas, further columns
+// are ignored. The "angle = deg" line printed by sync after every scan ends a
+// cloud. Empty lines, comments (#) and other lines which do not start with
+// three numbers are skipped.
+//
+// UTF-8 and UTF-16 (with a BOM, as written by PowerShell redirection) are supported.
+type XYZReader struct {
+	scanner *bufio.Scanner
+	line    int
+	angle   float64
+}
+
+// NewXYZReader creates a reader of r.
+func NewXYZReader(r io.Reader) *XYZReader {
+	scanner := bufio.NewScanner(decodeText(r))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &XYZReader{scanner: scanner, angle: math.NaN()}
+}
+
+// Next returns points of the next scan (up to the next "angle" line). It
+// returns io.EOF when there are no more points.
+func (r *XYZReader) Next() ([]Point, error) {
+	var points []Point
+	r.angle = math.NaN()
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if strings.HasPrefix(line, "angle") {
+			if len(points) == 0 {
+				continue
+			}
+			r.angle = parseAngleLine(line)
+			return points, nil
+		}
+
+		p, ok, err := parseXYZLine(line)
+		if err != nil {
+			return points, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		if ok {
+			points = append(points, p)
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return points, err
+	}
+	if len(points) == 0 {
+		return nil, io.EOF
+	}
+	return points, nil
+}
+
+// Angle returns the servo angle in degrees of the "angle = deg" line which
+// ended the scan returned by Next, NaN if there was none.
+func (r *XYZReader) Angle() float64 {
+	return r.angle
+}
+
+// parseAngleLine returns the angle of an "angle = deg" line, NaN if it is
+// malformed.
+func parseAngleLine(line string) float64 {
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return math.NaN()
+	}
+	angle, err := strconv.ParseFloat(strings.TrimSpace(line[i+1:]), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return angle
+}
+
+// ReadXYZ reads all points of a text cloud.
+func ReadXYZ(r io.Reader) (*Cloud, error) {
+	xyz := NewXYZReader(r)
+	c := &Cloud{}
+	for {
+		points, err := xyz.Next()
+		c.Points = append(c.Points, points...)
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+}
+
+// WriteXYZ writes points as "X Y Z" lines.
+func WriteXYZ(w io.Writer, c *Cloud) error {
+	bw := bufio.NewWriter(w)
+	for _, p := range c.Points {
+		fmt.Fprintf(bw, "%f\t%f\t%f\n", p.X, p.Y, p.Z)
+	}
+	return bw.Flush()
+}
+
+// parseXYZLine parses a point. ok is false for lines which do not contain a
+// point. A line starting with a number but not containing three is an error.
+func parseXYZLine(line string) (p Point, ok bool, err error) {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	if len(fields) == 0 {
+		return p, false, nil
+	}
+	if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+		return p, false, nil // header, comment etc.
+	}
+	if len(fields) < 3 {
+		return p, false, fmt.Errorf("expected 3 coordinates, got %d", len(fields))
+	}
+
+	var xyz [3]float64
+	for i := range xyz {
+		if xyz[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return p, false, err
+		}
+	}
+	return Point{X: xyz[0], Y: xyz[1], Z: xyz[2]}, true, nil
+}
+
+// decodeText returns a reader of r converted to UTF-8 depending on its BOM.
+func decodeText(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(3)
+	switch {
+	case len(bom) >= 3 && bom[0] == 0xef && bom[1] == 0xbb && bom[2] == 0xbf:
+		br.Discard(3)
+		return br
+	case len(bom) >= 2 && bom[0] == 0xff && bom[1] == 0xfe:
+		br.Discard(2)
+		return &utf16Reader{r: br, littleEndian: true}
+	case len(bom) >= 2 && bom[0] == 0xfe && bom[1] == 0xff:
+		br.Discard(2)
+		return &utf16Reader{r: br}
+	}
+	return br
+}
+
+// utf16Reader converts UTF-16 to UTF-8.
+type utf16Reader struct {
+	r            *bufio.Reader
+	littleEndian bool
+	buf          [utf8.UTFMax]byte
+	pending      []byte // converted, but not read yet
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.pending) == 0 {
+		r, err := u.readRune()
+		if err != nil {
+			return 0, err
+		}
+		n := utf8.EncodeRune(u.buf[:], r)
+		u.pending = u.buf[:n]
+	}
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	return n, nil
+}
+
+func (u *utf16Reader) readUnit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF // ignore the odd byte
+		}
+		return 0, err
+	}
+	if u.littleEndian {
+		return uint16(b[0]) | uint16(b[1])<<8, nil
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), nil
+}
+
+func (u *utf16Reader) readRune() (rune, error) {
+	r1, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	if !utf16.IsSurrogate(rune(r1)) {
+		return rune(r1), nil
+	}
+	r2, err := u.readUnit()
+	if err != nil {
+		return utf8.RuneError, nil
+	}
+	return utf16.DecodeRune(rune(r1), rune(r2)), nil
+}
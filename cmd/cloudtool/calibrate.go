@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"text/tabwriter"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+func runCalibrate(args []string) error {
+	var (
+		out           string
+		initPath      string
+		cloudPath     string
+		cloudRotation float64
+		servoUnit     float64
+	)
+	opts := calib.DefaultSolveOptions
+
+	fs := newFlagSet("calibrate")
+	fs.StringVar(&out, "out", "calib.json", "file to write the calibration to, used by sync --calib")
+	fs.StringVar(&initPath, "init", "", "calibration file with the initial guess, replaces --cloudrotation and --servounit")
+	fs.Float64Var(&cloudRotation, "cloudrotation", -math.Pi/4, "initial cloud rotation in radians")
+	fs.Float64Var(&servoUnit, "servounit", calib.Prototype.ServoUnit, "initial degrees per servo position unit")
+	fs.Float64Var(&opts.RANSAC.Threshold, "threshold", opts.RANSAC.Threshold, "max distance of a point from its plane in the last round, earlier rounds are more tolerant")
+	fs.IntVar(&opts.RANSAC.MinInliers, "minpoints", opts.RANSAC.MinInliers, "min points of a plane")
+	fs.IntVar(&opts.RANSAC.MaxPlanes, "planes", opts.RANSAC.MaxPlanes, "max number of planes")
+	fs.IntVar(&opts.Rounds, "rounds", opts.Rounds, "rounds of plane extraction and optimization")
+	fs.IntVar(&opts.MaxPoints, "maxpoints", opts.MaxPoints, "measurements used, evenly sampled from the log")
+	fs.BoolVar(&opts.Level, "level", opts.Level, "solve the servo zero so that the floor or the ceiling is horizontal")
+	fs.StringVar(&cloudPath, "cloud", "", "file to write the log fused with the calibration to, for inspection (disabled if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if opts.Rounds < 1 || opts.RANSAC.Threshold <= 0 {
+		return fmt.Errorf("rounds and threshold must be positive")
+	}
+	initial := calib.SimpleMount(cloudRotation, servoUnit)
+	if initPath != "" {
+		var err error
+		if initial, err = calib.Load(initPath); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	raw, err := calib.ReadRawLog(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	log.Printf("%d scans, servo calibration position %d", len(raw.Scans), raw.ServoCalib)
+
+	result, err := calib.Solve(raw, initial, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d planes, %d points, rmse %.2f -> %.2f", result.Planes, result.Points, result.RMSEBefore, result.RMSE)
+	if opts.Level && !result.Leveled {
+		log.Println("no horizontal plane found, the servo zero is not solved")
+	}
+
+	m := result.Mount
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PARAMETER\tINITIAL\tSOLVED")
+	t0, t := initial.LidarToHead.T, m.LidarToHead.T
+	fmt.Fprintf(w, "lidar to head x\t%.1f\t%.1f\n", t0.X, t.X)
+	fmt.Fprintf(w, "lidar to head y\t%.1f\t%.1f\n", t0.Y, t.Y)
+	fmt.Fprintf(w, "lidar to head z\t%.1f\t%.1f\n", t0.Z, t.Z)
+	roll0, pitch0, yaw0 := initial.LidarToHead.R.Euler()
+	roll, pitch, yaw := m.LidarToHead.R.Euler()
+	fmt.Fprintf(w, "lidar to head roll (deg)\t%.3f\t%.3f\n", roll0*180/math.Pi, roll*180/math.Pi)
+	fmt.Fprintf(w, "lidar to head pitch (deg)\t%.3f\t%.3f\n", pitch0*180/math.Pi, pitch*180/math.Pi)
+	fmt.Fprintf(w, "lidar to head yaw (deg)\t%.3f\t%.3f\n", yaw0*180/math.Pi, yaw*180/math.Pi)
+	fmt.Fprintf(w, "servo unit (deg)\t%.5f\t%.5f\n", initial.ServoUnit, m.ServoUnit)
+	fmt.Fprintf(w, "servo zero (deg)\t%.3f\t%.3f\n", initial.ServoZero, m.ServoZero)
+	w.Flush()
+
+	if err := m.Save(out); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	if cloudPath != "" {
+		var fused []cloud.Point
+		for _, scan := range raw.Scans {
+			tf := m.LidarToBase(m.Tilt(scan.ServoPos, raw.ServoCalib))
+			for _, meas := range scan.Measurements {
+				if meas.Dist > 0 {
+					fused = append(fused, tf.Apply(calib.LidarPoint(meas.Angle, meas.Dist)))
+				}
+			}
+		}
+		if err := cloud.WriteFile(cloudPath, &cloud.Cloud{Points: fused}); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+	}
+	return nil
+}
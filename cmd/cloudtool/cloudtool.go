@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// command is a cloudtool subcommand. run gets the arguments following the
+// command name.
+type command struct {
+	usage string // arguments
+	short string // one line description
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	// set in init, as commands refer to commands in their usage
+	commands = map[string]command{
+		"render":    {"[flags] FILE|-", "render a cloud to PNG images", runRender},
+		"filter":    {"--filter SPEC [flags] FILE|-", "downsample, remove outliers and crop a cloud", runFilter},
+		"register":  {"[flags] REFERENCE SCAN", "align a scan to a reference scan with ICP and print the transform", runRegister},
+		"merge":     {"[flags] SCAN SCAN...", "register scans one by one and merge them into a map", runMerge},
+		"segment":   {"[flags] FILE|-", "extract ground, ceiling and wall planes with RANSAC and label points", runSegment},
+		"cluster":   {"[flags] FILE|-", "extract Euclidean clusters and their bounding boxes as JSON", runCluster},
+		"normals":   {"--out FILE [flags] FILE|-", "estimate point normals and write them to PLY or PCD", runNormals},
+		"mesh":      {"[flags] FILE|-", "triangulate a servo sweep of sync's text output to an OBJ or PLY mesh", runMesh},
+		"diff":      {"[flags] REFERENCE SCAN", "align two scans of a scene and report points which appeared or disappeared", runDiff},
+		"voxmap":    {"[flags] FILE|-...", "build a probabilistic 3D voxel map with free space carving and query or export it", runVoxmap},
+		"calibrate": {"[flags] RAWLOG", "solve the lidar mount on the servo from a raw log of sync --rawlog", runCalibrate},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: cloudtool command [flags] [args]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun \"cloudtool command -h\" for the command flags\n")
+}
+
+// newFlagSet creates the flag set of a command with a usage message.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cloudtool %s %s\n\n%s\n\nflags:\n", name, commands[name].usage, commands[name].short)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("cloudtool: ")
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// clusterJSON is a cluster as exported by the cluster command, in cloud units.
+type clusterJSON struct {
+	ID       int        `json:"id"`
+	Points   int        `json:"points"`
+	Centroid [3]float64 `json:"centroid"`
+	AABB     struct {
+		Min    [3]float64 `json:"min"`
+		Max    [3]float64 `json:"max"`
+		Center [3]float64 `json:"center"`
+		Size   [3]float64 `json:"size"`
+	} `json:"aabb"`
+	OBB struct {
+		Center [3]float64    `json:"center"`
+		Size   [3]float64    `json:"size"`
+		Axes   [3][3]float64 `json:"axes"` // rows are the unit box axes
+		RPY    [3]float64    `json:"rpy"`  // rotation of the box as roll, pitch, yaw in degrees
+	} `json:"obb"`
+}
+
+func vec(p cloud.Point) [3]float64 {
+	return [3]float64{p.X, p.Y, p.Z}
+}
+
+func newClusterJSON(c cloud.Cluster) clusterJSON {
+	var j clusterJSON
+	j.ID = c.ID
+	j.Points = len(c.Indices)
+	j.Centroid = vec(c.Centroid)
+	j.AABB.Min, j.AABB.Max = vec(c.Box.Min), vec(c.Box.Max)
+	j.AABB.Center, j.AABB.Size = vec(c.Box.Center()), vec(c.Box.Size())
+	j.OBB.Center, j.OBB.Size = vec(c.Oriented.Center), vec(c.Oriented.Size)
+	j.OBB.Axes = [3][3]float64(c.Oriented.Axes.Transpose())
+	roll, pitch, yaw := c.Oriented.Axes.Euler()
+	j.OBB.RPY = [3]float64{roll * 180 / math.Pi, pitch * 180 / math.Pi, yaw * 180 / math.Pi}
+	return j
+}
+
+// planeKinds maps names accepted by --removeplanes to plane kinds.
+var planeKinds = map[string]cloud.PlaneKind{
+	"ground":  cloud.PlaneGround,
+	"ceiling": cloud.PlaneCeiling,
+	"wall":    cloud.PlaneWall,
+	"other":   cloud.PlaneOther,
+}
+
+func runCluster(args []string) error {
+	var (
+		out          string
+		jsonPath     string
+		removePlanes string
+	)
+	opts := cloud.DefaultClusterOptions
+	ransac := cloud.DefaultRANSACOptions
+
+	fs := newFlagSet("cluster")
+	fs.Float64Var(&opts.Tolerance, "tolerance", opts.Tolerance, "max gap between points of a cluster")
+	fs.IntVar(&opts.MinSize, "minsize", opts.MinSize, "min points of a cluster")
+	fs.IntVar(&opts.MaxSize, "maxsize", opts.MaxSize, "max points of a cluster (no limit if 0)")
+	fs.StringVar(&removePlanes, "removeplanes", "ground", "comma separated kinds of planes removed before clustering (ground, ceiling, wall, other), none if empty")
+	fs.Float64Var(&ransac.Threshold, "planethreshold", ransac.Threshold, "max distance of a point from a removed plane")
+	fs.StringVar(&out, "out", "", "file to write the cloud labeled with cluster IDs to, .ply or .pcd (disabled if empty)")
+	fs.StringVar(&jsonPath, "json", "", "file to write clusters to as JSON, - for stdout (next to --out with the .json extension, or stdout, if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	remove := make(map[cloud.PlaneKind]bool)
+	for _, name := range strings.Split(removePlanes, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		kind, ok := planeKinds[name]
+		if !ok {
+			return fmt.Errorf("unknown plane kind %q", name)
+		}
+		remove[kind] = true
+	}
+	if jsonPath == "" {
+		jsonPath = "-"
+		if out != "" {
+			jsonPath = strings.TrimSuffix(out, filepath.Ext(out)) + ".json"
+		}
+	}
+
+	c, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// indices of the points left after removing planes
+	kept := make([]int, 0, len(c.Points))
+	if len(remove) != 0 {
+		segments, planeLabels := cloud.SegmentPlanes(c.Points, ransac)
+		removed := make(map[int]bool)
+		for _, s := range segments {
+			if remove[s.Kind] {
+				removed[s.ID] = true
+				log.Printf("removing %s plane %d (%d points)", s.Kind, s.ID, len(s.Inliers))
+			}
+		}
+		for i, label := range planeLabels {
+			if !removed[label] {
+				kept = append(kept, i)
+			}
+		}
+	} else {
+		for i := range c.Points {
+			kept = append(kept, i)
+		}
+	}
+
+	points := make([]cloud.Point, len(kept))
+	for i, index := range kept {
+		points[i] = c.Points[index]
+	}
+	clusters, keptLabels := cloud.EuclideanClusters(points, opts)
+	log.Printf("%d clusters in %d points", len(clusters), len(points))
+
+	// back to indices of the whole cloud
+	labels := make([]int, len(c.Points))
+	for i, index := range kept {
+		labels[index] = keptLabels[i]
+	}
+	result := make([]clusterJSON, len(clusters))
+	for i, cl := range clusters {
+		result[i] = newClusterJSON(cl)
+	}
+
+	if out != "" {
+		if err := cloud.WriteFile(out, &cloud.Cloud{Points: c.Points, Labels: labels}); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+	}
+	return writeJSON(jsonPath, struct {
+		Clusters []clusterJSON `json:"clusters"`
+	}{result})
+}
+
+// writeJSON writes v indented to the file, "-" writes to stdout.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// Labels and colors of points of the difference cloud.
+const (
+	labelUnchanged = iota
+	labelAppeared
+	labelDisappeared
+)
+
+var diffColors = map[int]cloud.Color{
+	labelUnchanged:   {R: 160, G: 160, B: 160},
+	labelAppeared:    {R: 0, G: 200, B: 0},
+	labelDisappeared: {R: 220, G: 0, B: 0},
+}
+
+// changeJSON sums up points which appeared or disappeared.
+type changeJSON struct {
+	Points    int     `json:"points"`    // all changed points
+	Clustered int     `json:"clustered"` // changed points in clusters
+	Volume    float64 `json:"volume"`    // sum of volumes of oriented boxes of the clusters in m³
+}
+
+// diffClusterJSON is a cluster of changed points.
+type diffClusterJSON struct {
+	Change string `json:"change"` // appeared or disappeared
+	clusterJSON
+	Volume float64 `json:"volume"` // volume of the oriented box in m³
+}
+
+// changeClusters clusters changed points and returns their summary and
+// clusters with IDs starting after firstID. unit is meters per cloud unit.
+func changeClusters(change string, points []cloud.Point, indices []int, opts cloud.ClusterOptions, firstID int, unit float64) (changeJSON, []diffClusterJSON) {
+	changed := make([]cloud.Point, len(indices))
+	for i, index := range indices {
+		changed[i] = points[index]
+	}
+	clusters, _ := cloud.EuclideanClusters(changed, opts)
+
+	summary := changeJSON{Points: len(indices)}
+	result := make([]diffClusterJSON, len(clusters))
+	for i, cl := range clusters {
+		cl.ID += firstID
+		result[i] = diffClusterJSON{Change: change, clusterJSON: newClusterJSON(cl), Volume: cl.Oriented.Volume() * unit * unit * unit}
+		summary.Clustered += len(cl.Indices)
+		summary.Volume += result[i].Volume
+	}
+	return summary, result
+}
+
+func runDiff(args []string) error {
+	var (
+		align     bool
+		threshold float64
+		unit      float64
+		out       string
+		jsonPath  string
+	)
+	opts := cloud.DefaultClusterOptions
+
+	fs := newFlagSet("diff")
+	cfg := addICPFlags(fs)
+	fs.BoolVar(&align, "align", true, "align the scan to the reference with ICP first, disable if the rig did not move")
+	fs.Float64Var(&threshold, "threshold", 100, "min distance of a changed point from the other scan")
+	fs.Float64Var(&unit, "unit", 0.001, "meters per cloud unit for volumes (sync outputs millimeters)")
+	fs.Float64Var(&opts.Tolerance, "clustertolerance", opts.Tolerance, "max gap between points of a cluster of changes")
+	fs.IntVar(&opts.MinSize, "minsize", opts.MinSize, "min points of a cluster of changes, smaller changes are treated as noise")
+	fs.StringVar(&out, "out", "diff.ply", "file to write the difference cloud to, .ply or .pcd for colors, --out= to disable")
+	fs.StringVar(&jsonPath, "json", "", "file to write the summary to as JSON, - for stdout (next to --out with the .json extension if empty, disabled if both are empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if threshold <= 0 {
+		return fmt.Errorf("threshold must be positive")
+	}
+	if unit <= 0 {
+		return fmt.Errorf("unit must be positive")
+	}
+	if jsonPath == "" && out != "" {
+		jsonPath = strings.TrimSuffix(out, filepath.Ext(out)) + ".json"
+	}
+	initial, err := cfg.initial()
+	if err != nil {
+		return err
+	}
+
+	reference, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	scan, err := cloud.ReadFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	tf := initial
+	if align {
+		target := cloud.NewTarget(cfg.downsample(reference.Points), cfg.opts.NormalNeighbors)
+		result, err := cloud.ICP(cfg.downsample(scan.Points), target, initial, cfg.opts)
+		if err != nil {
+			return err
+		}
+		log.Printf("aligned with fitness %.3f, rmse %.3f: %s", result.Fitness, result.RMSE, describe(result.Transform))
+		tf = result.Transform
+	}
+	aligned := tf.ApplyAll(scan.Points)
+
+	appeared, disappeared := cloud.Changes(reference.Points, aligned, threshold)
+	appearedSum, clusters := changeClusters("appeared", aligned, appeared, opts, 0, unit)
+	disappearedSum, more := changeClusters("disappeared", reference.Points, disappeared, opts, len(clusters), unit)
+	clusters = append(clusters, more...)
+
+	table := os.Stdout
+	if jsonPath == "-" { // stdout is for the JSON
+		table = os.Stderr
+	}
+	w := tabwriter.NewWriter(table, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tPOINTS\tCLUSTERED\tVOLUME [m³]")
+	fmt.Fprintf(w, "appeared\t%d\t%d\t%.4g\n", appearedSum.Points, appearedSum.Clustered, appearedSum.Volume)
+	fmt.Fprintf(w, "disappeared\t%d\t%d\t%.4g\n", disappearedSum.Points, disappearedSum.Clustered, disappearedSum.Volume)
+	fmt.Fprintln(w, "\nID\tCHANGE\tPOINTS\tCENTER\tSIZE\tYAW\tVOLUME [m³]")
+	for _, cl := range clusters {
+		c, s := cl.OBB.Center, cl.OBB.Size
+		fmt.Fprintf(w, "%d\t%s\t%d\t%.0f,%.0f,%.0f\t%.0fx%.0fx%.0f\t%.1f\t%.4g\n", cl.ID, cl.Change, cl.Points, c[0], c[1], c[2], s[0], s[1], s[2], cl.OBB.RPY[2], cl.Volume)
+	}
+	w.Flush()
+
+	if out != "" {
+		// the aligned scan and the reference points which disappeared
+		diff := &cloud.Cloud{
+			Points: aligned,
+			Labels: make([]int, len(aligned), len(aligned)+len(disappeared)),
+		}
+		for _, i := range appeared {
+			diff.Labels[i] = labelAppeared
+		}
+		for _, i := range disappeared {
+			diff.Points = append(diff.Points, reference.Points[i])
+			diff.Labels = append(diff.Labels, labelDisappeared)
+		}
+		diff.Colors = make([]cloud.Color, len(diff.Labels))
+		for i, label := range diff.Labels {
+			diff.Colors[i] = diffColors[label]
+		}
+		if err := cloud.WriteFile(out, diff); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+	}
+	if jsonPath == "" {
+		return nil
+	}
+	return writeJSON(jsonPath, struct {
+		Threshold   float64           `json:"threshold"`
+		Appeared    changeJSON        `json:"appeared"`
+		Disappeared changeJSON        `json:"disappeared"`
+		Clusters    []diffClusterJSON `json:"clusters"`
+	}{threshold, appearedSum, disappearedSum, clusters})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+func runFilter(args []string) error {
+	var (
+		spec string
+		out  string
+	)
+
+	fs := newFlagSet("filter")
+	fs.StringVar(&spec, "filter", "", cloud.FilterHelp)
+	fs.StringVar(&out, "out", "-", "output file (.ply for PLY, .pcd for PCD, text otherwise), - for stdout")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	pipeline, err := cloud.ParseFilters(spec)
+	if err != nil {
+		return err
+	}
+	if len(pipeline) == 0 {
+		return errors.New("no filters given")
+	}
+
+	c, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	points := c.Points
+	for _, f := range pipeline {
+		n := len(points)
+		points = f.Apply(points)
+		log.Printf("%s: %d -> %d points", f, n, len(points))
+	}
+	if err := cloud.WriteFile(out, &cloud.Cloud{Points: points}); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	return nil
+}
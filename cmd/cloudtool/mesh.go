@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+func runMesh(args []string) error {
+	var (
+		out   string
+		sweep int
+	)
+	opts := cloud.DefaultMeshOptions
+
+	fs := newFlagSet("mesh")
+	fs.StringVar(&out, "out", "mesh.ply", "file to write the mesh to, .obj or .ply")
+	fs.IntVar(&sweep, "sweep", 0, "index of the servo sweep to triangulate, negative counts from the last one")
+	fs.Float64Var(&opts.ColumnStep, "colstep", opts.ColumnStep, "lidar angle covered by a grid column in degrees")
+	fs.Float64Var(&opts.MaxEdge, "maxedge", opts.MaxEdge, "max triangle edge length")
+	fs.Float64Var(&opts.MaxRangeRatio, "maxratio", opts.MaxRangeRatio, "max ratio of ranges of triangle vertices, larger differences are treated as occlusion edges")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if opts.ColumnStep <= 0 || opts.MaxEdge <= 0 || opts.MaxRangeRatio < 1 {
+		return fmt.Errorf("colstep and maxedge must be positive, maxratio at least 1")
+	}
+
+	// only sync's text output keeps the scans apart
+	path := fs.Arg(0)
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		if ext := strings.ToLower(filepath.Ext(path)); ext == ".ply" || ext == ".pcd" {
+			return fmt.Errorf("%s: mesh needs sync's text output, which keeps scans apart", path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	scans, err := cloud.ReadScans(r)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	sweeps := cloud.SplitSweeps(scans)
+	i := sweep
+	if i < 0 {
+		i += len(sweeps)
+	}
+	if i < 0 || i >= len(sweeps) {
+		return fmt.Errorf("sweep %d requested, %d found", sweep, len(sweeps))
+	}
+	first, last := sweeps[i][0].Angle, sweeps[i][len(sweeps[i])-1].Angle
+	log.Printf("%d scans in %d sweeps, meshing sweep %d: %d scans from %.1f to %.1f deg", len(scans), len(sweeps), i, len(sweeps[i]), first, last)
+
+	mesh, err := cloud.GridMesh(sweeps[i], opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d vertices, %d triangles", len(mesh.Vertices), len(mesh.Triangles))
+	return cloud.WriteMeshFile(out, mesh)
+}
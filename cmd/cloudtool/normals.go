@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// parsePoint parses "x,y,z".
+func parsePoint(s string) (cloud.Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return cloud.Point{}, fmt.Errorf("invalid point %q, expected x,y,z", s)
+	}
+	var v [3]float64
+	for i, part := range parts {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return cloud.Point{}, fmt.Errorf("invalid point %q, expected x,y,z", s)
+		}
+	}
+	return cloud.Point{X: v[0], Y: v[1], Z: v[2]}, nil
+}
+
+func runNormals(args []string) error {
+	var (
+		out       string
+		k         int
+		viewpoint string
+	)
+
+	fs := newFlagSet("normals")
+	fs.IntVar(&k, "k", 16, "neighbors of a point used to fit its plane")
+	fs.StringVar(&viewpoint, "viewpoint", "0,0,0", "x,y,z the normals are oriented towards, sync's clouds have the lidar at the origin")
+	fs.StringVar(&out, "out", "", "file to write the cloud with normals to, .ply or .pcd")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if k < 3 {
+		return fmt.Errorf("k must be at least 3")
+	}
+	vp, err := parsePoint(viewpoint)
+	if err != nil {
+		return err
+	}
+
+	c, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	c.Normals = cloud.EstimateNormals(c.Points, spatial.NewKDTree(c.Points), k, vp)
+	missing := 0
+	for _, n := range c.Normals {
+		if n == (cloud.Point{}) {
+			missing++
+		}
+	}
+	log.Printf("estimated normals of %d points (%d without enough neighbors)", len(c.Points)-missing, missing)
+
+	if err := cloud.WriteFile(out, c); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	return nil
+}
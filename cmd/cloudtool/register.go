@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// icpConfig are ICP flags shared by register and merge.
+type icpConfig struct {
+	opts  cloud.ICPOptions
+	voxel float64
+	init  string
+}
+
+func addICPFlags(fs *flag.FlagSet) *icpConfig {
+	cfg := &icpConfig{opts: cloud.DefaultICPOptions}
+	fs.IntVar(&cfg.opts.MaxIterations, "iterations", cfg.opts.MaxIterations, "max ICP iterations")
+	fs.Float64Var(&cfg.opts.MaxDistance, "maxdist", cfg.opts.MaxDistance, "max distance of corresponding points")
+	fs.Float64Var(&cfg.opts.Tolerance, "tolerance", cfg.opts.Tolerance, "stop when the RMSE changes less than that")
+	fs.IntVar(&cfg.opts.NormalNeighbors, "normalk", cfg.opts.NormalNeighbors, "neighbors used to estimate normals")
+	fs.Float64Var(&cfg.opts.Damping, "damping", cfg.opts.Damping, "weight of the point displacements by an ICP step, keeps weakly constrained directions in place (0 disables)")
+	fs.Float64Var(&cfg.voxel, "voxel", 50, "voxel size the clouds are downsampled to for registration (0 disables)")
+	fs.StringVar(&cfg.init, "init", "", "initial guess \"x,y,z,roll,pitch,yaw\" (cloud units and degrees)")
+	return cfg
+}
+
+// downsample returns points downsampled for registration.
+func (cfg *icpConfig) downsample(points []cloud.Point) []cloud.Point {
+	if cfg.voxel <= 0 {
+		return points
+	}
+	return cloud.VoxelGrid{Size: cfg.voxel}.Apply(points)
+}
+
+// initial parses the initial guess.
+func (cfg *icpConfig) initial() (cloud.Transform, error) {
+	if cfg.init == "" {
+		return cloud.IdentityTransform, nil
+	}
+
+	fields := strings.Split(cfg.init, ",")
+	if len(fields) != 6 {
+		return cloud.Transform{}, fmt.Errorf("initial guess must have 6 values, got %d", len(fields))
+	}
+	var v [6]float64
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return cloud.Transform{}, fmt.Errorf("invalid initial guess: %v", err)
+		}
+	}
+	deg := math.Pi / 180
+	return cloud.Transform{
+		R: cloud.RotationFromEuler(v[3]*deg, v[4]*deg, v[5]*deg),
+		T: cloud.Point{X: v[0], Y: v[1], Z: v[2]},
+	}, nil
+}
+
+// describe returns the transform as "x y z roll pitch yaw" (degrees).
+func describe(tf cloud.Transform) string {
+	roll, pitch, yaw := tf.R.Euler()
+	deg := 180 / math.Pi
+	return fmt.Sprintf("translation %.1f %.1f %.1f, roll %.2f pitch %.2f yaw %.2f deg",
+		tf.T.X, tf.T.Y, tf.T.Z, roll*deg, pitch*deg, yaw*deg)
+}
+
+func runRegister(args []string) error {
+	var out string
+
+	fs := newFlagSet("register")
+	cfg := addICPFlags(fs)
+	fs.StringVar(&out, "out", "", "file to write the registered scan to (disabled if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	initial, err := cfg.initial()
+	if err != nil {
+		return err
+	}
+
+	reference, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	scan, err := cloud.ReadFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	target := cloud.NewTarget(cfg.downsample(reference.Points), cfg.opts.NormalNeighbors)
+	result, err := cloud.ICP(cfg.downsample(scan.Points), target, initial, cfg.opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result.Transform)
+	log.Printf("fitness %.3f, rmse %.3f, %d iterations, converged %v", result.Fitness, result.RMSE, result.Iterations, result.Converged)
+	log.Println(describe(result.Transform))
+
+	if out != "" {
+		registered := &cloud.Cloud{Points: result.Transform.ApplyAll(scan.Points)}
+		if err := cloud.WriteFile(out, registered); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+	}
+	return nil
+}
+
+func runMerge(args []string) error {
+	var (
+		out        string
+		mapVoxel   float64
+		minFitness float64
+		transforms string
+	)
+
+	fs := newFlagSet("merge")
+	cfg := addICPFlags(fs)
+	fs.StringVar(&out, "out", "map.ply", "file to write the merged map to")
+	fs.Float64Var(&mapVoxel, "mapvoxel", 0, "voxel size the merged map is downsampled to (0 disables)")
+	fs.Float64Var(&minFitness, "minfitness", 0.3, "scans registered with a lower fitness are skipped")
+	fs.StringVar(&transforms, "transforms", "", "file to write the transform of every scan to (disabled if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	initial, err := cfg.initial()
+	if err != nil {
+		return err
+	}
+
+	var tfLog strings.Builder
+	first, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	merged := append([]cloud.Point(nil), first.Points...)
+	reference := cfg.downsample(first.Points)
+	fmt.Fprintf(&tfLog, "# %s\n%s\n", fs.Arg(0), cloud.IdentityTransform)
+	log.Printf("%s: reference, %d points", fs.Arg(0), first.Len())
+
+	// the rig is moved step by step, so the previous transform is a good guess
+	guess := initial
+	for _, path := range fs.Args()[1:] {
+		scan, err := cloud.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		target := cloud.NewTarget(reference, cfg.opts.NormalNeighbors)
+		result, err := cloud.ICP(cfg.downsample(scan.Points), target, guess, cfg.opts)
+		if err != nil {
+			log.Printf("%s: skipped: %v", path, err)
+			continue
+		}
+		if result.Fitness < minFitness {
+			log.Printf("%s: skipped: fitness %.3f is too low", path, result.Fitness)
+			continue
+		}
+		log.Printf("%s: fitness %.3f, rmse %.3f, %s", path, result.Fitness, result.RMSE, describe(result.Transform))
+
+		registered := result.Transform.ApplyAll(scan.Points)
+		merged = append(merged, registered...)
+		reference = cfg.downsample(append(reference, cfg.downsample(registered)...))
+		guess = result.Transform
+		fmt.Fprintf(&tfLog, "# %s\n%s\n", path, result.Transform)
+	}
+
+	if mapVoxel > 0 {
+		merged = cloud.VoxelGrid{Size: mapVoxel}.Apply(merged)
+	}
+	log.Printf("writing %d points to %s", len(merged), out)
+	if err := cloud.WriteFile(out, &cloud.Cloud{Points: merged}); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	if transforms != "" {
+		if err := os.WriteFile(transforms, []byte(tfLog.String()), 0644); err != nil {
+			return fmt.Errorf("write transforms: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+	"github.com/knei-knurow/lidar-tools/render"
+)
+
+func runRender(args []string) error {
+	opts := render.DefaultOptions
+	var (
+		out       string
+		views     string
+		colorMode string
+		every     int
+	)
+
+	fs := newFlagSet("render")
+	fs.StringVar(&out, "out", "render", "output file prefix, images are saved as PREFIX-VIEW.png")
+	fs.StringVar(&views, "views", "top,side,perspective", "comma separated views to render (top, side, perspective)")
+	fs.StringVar(&colorMode, "color", opts.Color.String(), "colorize points by height or range")
+	fs.IntVar(&opts.Width, "width", opts.Width, "image width in pixels")
+	fs.IntVar(&opts.Height, "height", opts.Height, "image height in pixels")
+	fs.IntVar(&opts.PointSize, "pointsize", opts.PointSize, "point size in pixels")
+	fs.Float64Var(&opts.Azimuth, "azimuth", opts.Azimuth, "perspective camera azimuth in degrees")
+	fs.Float64Var(&opts.Elevation, "elevation", opts.Elevation, "perspective camera elevation in degrees")
+	fs.Float64Var(&opts.Unit, "unit", opts.Unit, "meters per cloud unit (sync outputs millimeters)")
+	fs.IntVar(&every, "every", 0, "when reading sync's output from stdin, render after every N scans (only at the end if 0)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if opts.Width < 64 || opts.Height < 64 {
+		return errors.New("the image must be at least 64x64")
+	}
+
+	var err error
+	if opts.Color, err = render.ParseColorMode(colorMode); err != nil {
+		return err
+	}
+	var viewList []render.View
+	for _, name := range strings.Split(views, ",") {
+		view, err := render.ParseView(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		viewList = append(viewList, view)
+	}
+
+	renderAll := func(c *cloud.Cloud) error {
+		for _, view := range viewList {
+			opts.View = view
+			path := fmt.Sprintf("%s-%s.png", out, view)
+			if err := render.WritePNG(path, render.Render(c, opts)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path := fs.Arg(0)
+	if path != "-" || every <= 0 {
+		c, err := cloud.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		log.Printf("rendering %d points", c.Len())
+		return renderAll(c)
+	}
+
+	// live stream, the images are overwritten as scans arrive
+	reader := cloud.NewXYZReader(os.Stdin)
+	c := &cloud.Cloud{}
+	for scans := 1; ; scans++ {
+		points, err := reader.Next()
+		c.Points = append(c.Points, points...)
+		if err == io.EOF {
+			return renderAll(c)
+		}
+		if err != nil {
+			return err
+		}
+		if scans%every == 0 {
+			if err := renderAll(c); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+func runSegment(args []string) error {
+	var out string
+	opts := cloud.DefaultRANSACOptions
+
+	fs := newFlagSet("segment")
+	fs.Float64Var(&opts.Threshold, "threshold", opts.Threshold, "max distance of a point from its plane")
+	fs.IntVar(&opts.MaxIterations, "iterations", opts.MaxIterations, "max RANSAC iterations per plane")
+	fs.IntVar(&opts.MinInliers, "minpoints", opts.MinInliers, "min points of a plane")
+	fs.IntVar(&opts.MaxPlanes, "planes", opts.MaxPlanes, "max number of planes")
+	fs.Float64Var(&opts.AngleTolerance, "angle", opts.AngleTolerance, "max deviation of ground, ceiling and walls from horizontal or vertical in degrees")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed")
+	fs.StringVar(&out, "out", "", "file to write the labeled cloud to, .ply or .pcd (disabled if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := cloud.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	segments, labels := cloud.SegmentPlanes(c.Points, opts)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tNX\tNY\tNZ\tD\tPOINTS\tRMSE")
+	segmented := 0
+	for _, s := range segments {
+		n := s.Plane.Normal
+		fmt.Fprintf(w, "%d\t%s\t%.4f\t%.4f\t%.4f\t%.1f\t%d\t%.2f\n", s.ID, s.Kind, n.X, n.Y, n.Z, s.Plane.D, len(s.Inliers), s.RMSE)
+		segmented += len(s.Inliers)
+	}
+	fmt.Fprintf(w, "0\tnone\t\t\t\t\t%d\t\n", len(c.Points)-segmented)
+	w.Flush()
+
+	if out != "" {
+		labeled := &cloud.Cloud{Points: c.Points, Labels: labels}
+		if err := cloud.WriteFile(out, labeled); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
+	"github.com/knei-knurow/lidar-tools/occupancy"
+)
+
+// readScans reads scans of a file. PLY and PCD files hold a single scan
+// without the servo angle.
+func readScans(path string) ([]cloud.Scan, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); path != "-" && (ext == ".ply" || ext == ".pcd") {
+		c, err := cloud.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []cloud.Scan{{Angle: math.NaN(), Points: c.Points}}, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	scans, err := cloud.ReadScans(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return scans, nil
+}
+
+// lidarOrigin returns the position of the lidar in the base frame of sync's
+// clouds at the servo angle in degrees, the angle 0 if it is unknown.
+func lidarOrigin(mount *calib.Mount, deg float64) cloud.Point {
+	if math.IsNaN(deg) {
+		deg = 0
+	}
+	return mount.LidarToBase(deg).T
+}
+
+func runVoxmap(args []string) error {
+	var (
+		res        float64
+		maxRange   float64
+		calibPath  string
+		transforms string
+		load       string
+		mapPath    string
+		out        string
+		query      string
+	)
+
+	fs := newFlagSet("voxmap")
+	fs.Float64Var(&res, "res", 50, "voxel edge length")
+	fs.Float64Var(&maxRange, "maxrange", 0, "longer rays only mark free space up to maxrange (0 disables)")
+	fs.StringVar(&calibPath, "calib", "", "calibration file the clouds were fused with by sync, the rays start at the lidar it places (the prototype mount if empty)")
+	fs.StringVar(&transforms, "transforms", "", "transforms of the files written by merge --transforms, the files are placed in the map by them")
+	fs.StringVar(&load, "load", "", "voxel map file to add the scans to")
+	fs.StringVar(&mapPath, "map", "", "file to write the binary voxel map to (disabled if empty)")
+	fs.StringVar(&out, "out", "", "file to write centers of occupied voxels to, .ply, .pcd or text (disabled if empty)")
+	fs.StringVar(&query, "query", "", "points x,y,z separated by ; to print the state and the occupancy probability of")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 && load == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if maxRange < 0 {
+		return fmt.Errorf("maxrange must not be negative")
+	}
+	mount := calib.Prototype
+	var err error
+	if calibPath != "" {
+		if mount, err = calib.Load(calibPath); err != nil {
+			return err
+		}
+	}
+	var queries []cloud.Point
+	if query != "" {
+		for _, s := range strings.Split(query, ";") {
+			p, err := parsePoint(s)
+			if err != nil {
+				return err
+			}
+			queries = append(queries, p)
+		}
+	}
+
+	var tfs map[string]cloud.Transform
+	if transforms != "" {
+		f, err := os.Open(transforms)
+		if err != nil {
+			return err
+		}
+		tfs, err = cloud.ReadTransforms(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", transforms, err)
+		}
+	}
+
+	var m *occupancy.VoxelMap
+	if load != "" {
+		if m, err = occupancy.LoadVoxelMap(load); err != nil {
+			return err
+		}
+		log.Printf("%s: %d voxels of resolution %g", load, m.Len(), m.Resolution)
+	} else {
+		if res <= 0 {
+			return fmt.Errorf("res must be positive")
+		}
+		m = occupancy.NewVoxelMap(res)
+	}
+
+	for _, path := range fs.Args() {
+		tf := cloud.IdentityTransform
+		if tfs != nil {
+			var ok bool
+			if tf, ok = tfs[path]; !ok {
+				return fmt.Errorf("%s: no transform in %s", path, transforms)
+			}
+		}
+		scans, err := readScans(path)
+		if err != nil {
+			return err
+		}
+
+		// the points are in the base frame already, but the lidar might be
+		// off the servo axis, which is the origin
+		points := 0
+		for _, scan := range scans {
+			moved := tf.ApplyAll(scan.Points)
+			m.Integrate(tf.Apply(lidarOrigin(&mount, scan.Angle)), moved, maxRange)
+			points += len(moved)
+		}
+		log.Printf("%s: integrated %d scans, %d points, %d voxels observed", path, len(scans), points, m.Len())
+	}
+
+	for _, q := range queries {
+		fmt.Printf("%g,%g,%g %s %.3f\n", q.X, q.Y, q.Z, m.State(q), m.Probability(q))
+	}
+	if mapPath != "" {
+		if err := m.Save(mapPath); err != nil {
+			return fmt.Errorf("write map: %v", err)
+		}
+	}
+	if out != "" {
+		occupied := m.Occupied()
+		log.Printf("%d occupied voxels", len(occupied))
+		if err := cloud.WriteFile(out, &cloud.Cloud{Points: occupied}); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
+)
+
+// resubscribeInterval is the time between two subscription requests. They are
+// repeated because UDP packets can be lost and the rig might be restarted.
+const resubscribeInterval = 5 * time.Second
+
+// discoverRig listens for rig announcements and returns the rig chosen either by
+// name, automatically (if it is the only one) or interactively.
+func discoverRig(announcePort string, duration time.Duration, name string) (rig netproto.Announcement, err error) {
+	conn, err := net.ListenPacket("udp", announcePort)
+	if err != nil {
+		return rig, fmt.Errorf("listen for announcements on %s: %v", announcePort, err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(os.Stderr, "looking for rigs for %v\n", duration)
+	rigs, err := netproto.Discover(conn, duration)
+	if err != nil {
+		return rig, fmt.Errorf("discover: %v", err)
+	}
+	if len(rigs) == 0 {
+		return rig, errors.New("no rigs found")
+	}
+
+	for i, r := range rigs {
+		fmt.Fprintf(os.Stderr, "%d. %s at %s, format %s, capabilities: %s\n",
+			i+1, r.Name, r.Addr, r.Format, strings.Join(r.Capabilities, ", "))
+	}
+
+	if name != "" {
+		for _, r := range rigs {
+			if r.Name == name {
+				return r, nil
+			}
+		}
+		return rig, fmt.Errorf("rig %q not found", name)
+	}
+
+	if len(rigs) == 1 {
+		return rigs[0], nil
+	}
+
+	fmt.Fprintf(os.Stderr, "choose a rig [1-%d]: ", len(rigs))
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return rig, fmt.Errorf("read choice: %v", err)
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(rigs) {
+		return rig, fmt.Errorf("invalid choice %q", strings.TrimSpace(line))
+	}
+	return rigs[choice-1], nil
+}
+
+// subscribe periodically asks the rig to stream to conn. If key is not nil,
+// the requests are sealed with it.
+func subscribe(conn net.PacketConn, rig netproto.Announcement, key []byte) {
+	hostname, _ := os.Hostname()
+	packet, err := netproto.MarshalSubscription(&netproto.Subscription{Receiver: hostname, Client: netproto.NewClientID()})
+	if err != nil {
+		log.Println("failed to create subscription:", err)
+		return
+	}
+	var sealer *netproto.Sealer
+	if key != nil {
+		if sealer, err = netproto.NewChannelSealer(key, netproto.ChannelSubscription); err != nil {
+			log.Println("failed to create subscription sealer:", err)
+			return
+		}
+	}
+
+	addr := rig.StreamAddr()
+	for {
+		// every request is sealed again, so it is not rejected as a replay
+		out := packet
+		if sealer != nil {
+			out = sealer.Seal(packet)
+		}
+		if _, err := conn.WriteTo(out, addr); err != nil {
+			log.Println("failed to subscribe:", err)
+		}
+		time.Sleep(resubscribeInterval)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
+)
+
+func TestSubscribe(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, netproto.KeySize)
+	for _, sealed := range []bool{false, true} {
+		rig, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rig.Close()
+		receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer receiver.Close()
+
+		// the rig announces from another socket than the one it streams from
+		announcer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		a := netproto.Announcement{Name: "test", Port: rig.LocalAddr().(*net.UDPAddr).Port, Addr: announcer}
+		var opener *netproto.ClientOpener
+		var subKey []byte
+		if sealed {
+			a.Capabilities = []string{netproto.CapabilityEncrypted}
+			if opener, err = netproto.NewClientOpener(key, netproto.ChannelSubscription); err != nil {
+				t.Fatal(err)
+			}
+			subKey = key
+		}
+		go subscribe(receiver, a, subKey)
+
+		rig.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 65536)
+		n, from, err := rig.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("sealed %v: no subscription: %v", sealed, err)
+		}
+		if from.String() != receiver.LocalAddr().String() {
+			t.Errorf("sealed %v: subscription from %v, want the stream socket %v", sealed, from, receiver.LocalAddr())
+		}
+
+		data := buf[:n]
+		var seq uint64
+		if opener != nil {
+			if data, seq, err = opener.Open(data); err != nil {
+				t.Fatalf("sealed %v: %v", sealed, err)
+			}
+		}
+		sub, err := netproto.ParseSubscription(data)
+		if err != nil {
+			t.Fatalf("sealed %v: %v", sealed, err)
+		}
+		if sub.Client == 0 {
+			t.Errorf("sealed %v: subscription without a client ID", sealed)
+		}
+		if opener != nil {
+			if err := opener.Accept(sub.Client, seq); err != nil {
+				t.Errorf("sealed %v: %v", sealed, err)
+			}
+		}
+	}
+}
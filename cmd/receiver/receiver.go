@@ -6,11 +6,32 @@ import (
 	"log"
 	"net"
 	"os"
-	"strings"
+	"os/signal"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
 )
 
+// statsInterval is the minimum time between two reports of rejected packets.
+const statsInterval = 10 * time.Second
+
 var port string
 var verbose bool
+var psk string
+var pskFile string
+var maxSkew time.Duration
+
+// discovery
+var discover bool
+var announcePort string
+var discoverTime time.Duration
+var rigName string
+
+// session output
+var outDir string
+var rotateSize int64
+var rotateTime time.Duration
+var quiet bool
 
 func init() {
 	log.SetFlags(0)
@@ -18,11 +39,37 @@ func init() {
 
 	flag.StringVar(&port, "port", ":8080", "port to listen on")
 	flag.BoolVar(&verbose, "verbose", false, "log stuff")
+	flag.StringVar(&psk, "psk", "", "hex encoded 32 byte pre-shared key, accept only authenticated packets")
+	flag.StringVar(&pskFile, "pskfile", "", "file containing hex encoded pre-shared key, accept only authenticated packets")
+	flag.DurationVar(&maxSkew, "maxskew", netproto.DefaultMaxSkew, "max difference between the clocks of the rig and the receiver with a key (0 disables the check)")
+
+	flag.BoolVar(&discover, "discover", false, "find rigs on the local network and subscribe to the chosen one")
+	flag.StringVar(&announcePort, "announceport", fmt.Sprintf(":%d", netproto.DefaultAnnouncePort), "port to listen on for rig announcements")
+	flag.DurationVar(&discoverTime, "discovertime", 3*netproto.DefaultAnnounceInterval, "time to listen for rig announcements")
+	flag.StringVar(&rigName, "rig", "", "name of the rig to subscribe to (default: ask if more than one is found)")
+
+	flag.StringVar(&outDir, "out", "", "directory in which a session directory with received clouds is created")
+	flag.Int64Var(&rotateSize, "rotatesize", 0, "start a new session file when the current one exceeds this many bytes (0 - disabled)")
+	flag.DurationVar(&rotateTime, "rotatetime", 0, "start a new session file when the current one is older than this (0 - disabled)")
+	flag.BoolVar(&quiet, "quiet", false, "do not print received clouds to stdout")
 }
 
 func main() {
 	flag.Parse()
 
+	key, err := netproto.ReadKey(psk, pskFile)
+	if err != nil {
+		log.Fatalln("invalid pre-shared key:", err)
+	}
+	var opener *netproto.Opener
+	if key != nil {
+		if opener, err = netproto.NewOpener(key); err != nil {
+			log.Fatalln("failed to create opener:", err)
+		}
+		opener.MaxSkew = maxSkew
+		fmt.Fprintln(os.Stderr, "accepting only authenticated packets")
+	}
+
 	pckt, err := net.ListenPacket("udp", port)
 	if err != nil {
 		log.Fatalf("failed to listen on port %s: %v\n", port, err)
@@ -30,18 +77,87 @@ func main() {
 	fmt.Fprintf(os.Stderr, "listening on port %s\n", port)
 	defer pckt.Close()
 
+	if discover {
+		rig, err := discoverRig(announcePort, discoverTime, rigName)
+		if err != nil {
+			log.Fatalln("failed to find a rig:", err)
+		}
+		if rig.Has(netproto.CapabilityEncrypted) && opener == nil {
+			log.Printf("warning: rig %s encrypts its stream but no key was given\n", rig.Name)
+		}
+		if !rig.Has(netproto.CapabilityEncrypted) && opener != nil {
+			log.Printf("warning: rig %s has no key, it will ignore the sealed subscription and its stream will be rejected\n", rig.Name)
+		}
+		fmt.Fprintf(os.Stderr, "subscribing to %s at %s\n", rig.Name, rig.StreamAddr())
+		go subscribe(pckt, rig, key)
+	}
+
+	var session *Session
+	if outDir != "" {
+		if session, err = NewSession(outDir, rotateSize, rotateTime); err != nil {
+			log.Fatalln("failed to create session:", err)
+		}
+		defer session.Close()
+		fmt.Fprintf(os.Stderr, "writing clouds to %s\n", session.Dir)
+	}
+
+	// stop reading on ctrl+c, so the session files are properly closed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		pckt.Close()
+	}()
+
+	var reported uint64 // rejected packets count at the time of the last report
+	lastReport := time.Now()
 	for {
 		buf := make([]byte, 65536)
-		n, _, err := pckt.ReadFrom(buf)
+		n, addr, err := pckt.ReadFrom(buf)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to read from buffer: %v\n", err)
 			break
 		}
 
-		text := string(buf[0:n])
-		text = strings.TrimSpace(text)
-		fmt.Print(text)
+		data := buf[0:n]
+		if opener != nil {
+			data, err = opener.Open(data)
+			if err != nil && verbose {
+				fmt.Fprintf(os.Stderr, "rejected packet from %s: %v\n", addr, err)
+			}
+			if rejected := opener.Stats.Rejected(); rejected != reported && time.Since(lastReport) > statsInterval {
+				reportStats(opener.Stats)
+				reported = rejected
+				lastReport = time.Now()
+			}
+			if err != nil {
+				continue
+			}
+		}
+
+		// every cloud ends with its delimiter line
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+
+		if session != nil {
+			if err := session.Write(data, time.Now()); err != nil {
+				log.Fatalln("failed to write session:", err)
+			}
+		}
+		if !quiet {
+			os.Stdout.Write(data)
+		}
 	}
 
+	if opener != nil {
+		reportStats(opener.Stats)
+	}
 	fmt.Fprintln(os.Stderr, "done")
 }
+
+// reportStats prints the numbers of accepted and rejected packets.
+func reportStats(stats netproto.OpenerStats) {
+	fmt.Fprintf(os.Stderr, "packets: %d accepted, %d unauthenticated, %d replayed\n",
+		stats.Accepted, stats.Unauthenticated, stats.Replayed)
+}
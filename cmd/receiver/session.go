@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// manifestName is the name of the manifest file in a session directory.
+const manifestName = "manifest.csv"
+
+// Session writes received clouds into files in its own directory and keeps
+// a manifest of them.
+//
+// If neither rotateSize nor rotateTime is set, every cloud gets its own file.
+// Otherwise clouds are appended to the current part file until it grows over
+// rotateSize bytes or becomes older than rotateTime.
+type Session struct {
+	Dir        string
+	rotateSize int64
+	rotateTime time.Duration
+
+	file       *os.File
+	fileSize   int64
+	fileOpened time.Time
+	parts      int
+
+	manifestFile *os.File
+	manifest     *csv.Writer
+	lastCloud    int // the highest cloud index received so far, -1 if unknown
+}
+
+// NewSession creates a new session directory in root named after the current time.
+func NewSession(root string, rotateSize int64, rotateTime time.Duration) (*Session, error) {
+	dir := filepath.Join(root, "session-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create session directory: %v", err)
+	}
+
+	manifestFile, err := os.Create(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("create manifest: %v", err)
+	}
+
+	session := &Session{
+		Dir:          dir,
+		rotateSize:   rotateSize,
+		rotateTime:   rotateTime,
+		manifestFile: manifestFile,
+		manifest:     csv.NewWriter(manifestFile),
+		lastCloud:    -1,
+	}
+	session.manifest.Write([]string{"file", "cloud", "elapsed_ms", "received", "gap"})
+	session.manifest.Flush()
+	return session, session.manifest.Error()
+}
+
+// Write stores a single received cloud (the data of one packet) and adds it
+// to the manifest.
+func (session *Session) Write(data []byte, received time.Time) error {
+	cloud, elapsed, ok := parseDelimiter(data)
+
+	if session.file == nil || session.rotate(received) {
+		if err := session.nextFile(cloud, ok, received); err != nil {
+			return err
+		}
+	}
+
+	n, err := session.file.Write(data)
+	session.fileSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("write cloud: %v", err)
+	}
+
+	// the gap is the number of clouds missing since the highest index received so
+	// far, negative if the cloud came out of order or the stream was restarted
+	record := []string{filepath.Base(session.file.Name()), "", "", received.Format(time.RFC3339Nano), ""}
+	if ok {
+		record[1] = strconv.Itoa(cloud)
+		record[2] = strconv.Itoa(elapsed)
+		if session.lastCloud >= 0 {
+			record[4] = strconv.Itoa(cloud - session.lastCloud - 1)
+		}
+		if cloud > session.lastCloud || cloud == 0 {
+			session.lastCloud = cloud // the stream might have been restarted
+		}
+	}
+	session.manifest.Write(record)
+	session.manifest.Flush()
+	return session.manifest.Error()
+}
+
+// rotate reports whether the next cloud should go to a new file.
+func (session *Session) rotate(now time.Time) bool {
+	if session.rotateSize == 0 && session.rotateTime == 0 {
+		return true // a file per cloud
+	}
+	if session.rotateSize != 0 && session.fileSize >= session.rotateSize {
+		return true
+	}
+	return session.rotateTime != 0 && now.Sub(session.fileOpened) >= session.rotateTime
+}
+
+// nextFile closes the current file and opens a new one.
+func (session *Session) nextFile(cloud int, cloudKnown bool, now time.Time) error {
+	if session.file != nil {
+		if err := session.file.Close(); err != nil {
+			return fmt.Errorf("close %s: %v", session.file.Name(), err)
+		}
+	}
+
+	session.parts++
+	var name string
+	if session.rotateSize == 0 && session.rotateTime == 0 && cloudKnown {
+		name = fmt.Sprintf("cloud-%06d", cloud)
+	} else {
+		name = fmt.Sprintf("part-%04d", session.parts)
+	}
+
+	file, err := createUnique(session.Dir, name, ".txt")
+	if err != nil {
+		return err
+	}
+	session.file = file
+	session.fileSize = 0
+	session.fileOpened = now
+	return nil
+}
+
+// createUnique creates the file name+ext in dir. If it exists, e.g. because
+// the stream was restarted and cloud indices started over, a number is
+// appended to the name instead of overwriting it.
+func createUnique(dir, name, ext string) (*os.File, error) {
+	path := filepath.Join(dir, name+ext)
+	for i := 2; ; i++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return file, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("create %s: %v", path, err)
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, i, ext))
+	}
+}
+
+// Close closes the current file and the manifest.
+func (session *Session) Close() error {
+	if session.file != nil {
+		session.file.Close()
+	}
+	session.manifest.Flush()
+	return session.manifestFile.Close()
+}
+
+// parseDelimiter finds the last delimiter line ("! cloud elapsed") in data.
+func parseDelimiter(data []byte) (cloud int, elapsed int, ok bool) {
+	lines := bytes.Split(bytes.TrimRight(data, "\r\n"), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if bytes.HasPrefix(lines[i], []byte("!")) {
+			_, err := fmt.Sscanf(string(lines[i]), "! %d %d", &cloud, &elapsed)
+			return cloud, elapsed, err == nil
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
+)
+
+// calibrateTimeout is the default timeout of the calibrate command which takes
+// much longer than the others (the countdown and 1000 accel measurements).
+const calibrateTimeout = 60 * time.Second
+
+var (
+	rigAddr string
+	psk     string
+	pskFile string
+	timeout time.Duration
+	retry   time.Duration
+)
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("rigctl: ")
+
+	flag.StringVar(&rigAddr, "rig", fmt.Sprintf("127.0.0.1:%d", netproto.DefaultControlPort), "address of the rig control server (sync --control)")
+	flag.StringVar(&psk, "psk", "", "hex encoded 32 byte pre-shared key")
+	flag.StringVar(&pskFile, "pskfile", "", "file containing hex encoded pre-shared key")
+	flag.DurationVar(&timeout, "timeout", netproto.DefaultControlTimeout, "time to wait for the acknowledgement")
+	flag.DurationVar(&retry, "retry", netproto.DefaultControlRetry, "time after which an unacknowledged request is sent again")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `usage: rigctl [flags] command [args]
+
+commands:
+  status                   print the servo state
+  servo POSITION           stop sweeping and hold the servo at POSITION
+  sweep                    resume sweeping
+  limits MIN MAX [STEP]    change the sweep limits (and the step size)
+  calibrate                move the servo to the calibration position and calibrate the accel
+
+flags:
+`)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	req, err := parseRequest(flag.Args())
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	key, err := netproto.ReadKey(psk, pskFile)
+	if err != nil {
+		log.Fatalln("invalid pre-shared key:", err)
+	}
+
+	client, err := netproto.DialControl(rigAddr, key)
+	if err != nil {
+		log.Fatalln("failed to connect:", err)
+	}
+	defer client.Close()
+	client.Retry = retry
+
+	if req.Command == netproto.CommandCalibrate && !isFlagSet("timeout") {
+		timeout = calibrateTimeout
+	}
+
+	resp, err := client.Do(req, timeout)
+	if err != nil {
+		log.Fatalf("request %d (%s) failed: %v\n", req.ID, req.Command, err)
+	}
+	if !resp.OK {
+		log.Fatalf("request %d (%s) rejected: %s\n", req.ID, req.Command, resp.Error)
+	}
+
+	fmt.Printf("request %d (%s) acknowledged\n", resp.ID, req.Command)
+	if s := resp.Status; s != nil {
+		mode := "holding"
+		if s.Sweeping {
+			mode = "sweeping"
+		}
+		fmt.Printf("servo: position %d, %s, limits [%d, %d], step %d, calib %d\n",
+			s.Position, mode, s.Min, s.Max, s.Step, s.Calib)
+	}
+}
+
+// parseRequest creates a request from the command line arguments.
+func parseRequest(args []string) (*netproto.Request, error) {
+	values := make([]uint16, len(args)-1)
+	for i, arg := range args[1:] {
+		v, err := strconv.ParseUint(arg, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q: %v", arg, err)
+		}
+		values[i] = uint16(v)
+	}
+
+	req := &netproto.Request{}
+	switch {
+	case args[0] == "status" && len(values) == 0:
+		req.Command = netproto.CommandStatus
+	case args[0] == "servo" && len(values) == 1:
+		req.Command = netproto.CommandServoSet
+		req.Position = values[0]
+	case args[0] == "sweep" && len(values) == 0:
+		req.Command = netproto.CommandServoSweep
+	case args[0] == "limits" && (len(values) == 2 || len(values) == 3):
+		req.Command = netproto.CommandSweepLimits
+		req.Min, req.Max = values[0], values[1]
+		if len(values) == 3 {
+			req.Step = values[2]
+		}
+	case args[0] == "calibrate" && len(values) == 0:
+		req.Command = netproto.CommandCalibrate
+	default:
+		return nil, fmt.Errorf("invalid command or number of arguments: %v", args)
+	}
+	return req, nil
+}
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
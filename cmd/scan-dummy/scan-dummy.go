@@ -2,10 +2,15 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"os"
 	"time"
+
+	"github.com/knei-knurow/lidar-tools/calib"
 )
 
 const (
@@ -13,27 +18,90 @@ const (
 	pts = 1274
 )
 
+var (
+	scenePath string
+	calibPath string
+	tiltMin   float64
+	tiltMax   float64
+	tiltStep  float64
+	minRange  float64
+	maxRange  float64
+	noise     float64
+	dropout   float64
+	jitter    float64
+	seed      int64
+)
+
+func init() {
+	flag.StringVar(&scenePath, "scene", "", "JSON scene file to raycast (a room with a box and a pillar if empty)")
+	flag.StringVar(&calibPath, "calib", "", "calibration file of the simulated lidar mount (the prototype mount if empty)")
+	flag.Float64Var(&tiltMin, "tiltmin", 0, "min tilt of the simulated servo in degrees")
+	flag.Float64Var(&tiltMax, "tiltmax", 0, "max tilt of the simulated servo in degrees")
+	flag.Float64Var(&tiltStep, "tiltstep", 0.5, "tilt change between rotations in degrees")
+	flag.Float64Var(&minRange, "minrange", 150, "min measured distance in millimeters")
+	flag.Float64Var(&maxRange, "maxrange", 12000, "max measured distance in millimeters")
+	flag.Float64Var(&noise, "noise", 0, "standard deviation of the distance noise in millimeters")
+	flag.Float64Var(&dropout, "dropout", 0, "probability of a measurement being dropped (distance 0)")
+	flag.Float64Var(&jitter, "jitter", 0, "standard deviation of the angle error in degrees, the reported angles stay exact")
+	flag.Int64Var(&seed, "seed", 1, "random seed of the noise")
+}
+
 func main() {
+	flag.Parse()
 	stdout := bufio.NewWriter(os.Stdout)
 
 	log.SetPrefix("scan: ")
 	log.SetFlags(0)
 	log.Println(os.Args)
 
+	scene := &DefaultScene
+	if scenePath != "" {
+		var err error
+		if scene, err = LoadScene(scenePath); err != nil {
+			log.Fatalln("cannot load scene:", err)
+		}
+	}
+	mount := calib.Prototype
+	if calibPath != "" {
+		var err error
+		if mount, err = calib.Load(calibPath); err != nil {
+			log.Fatalln("cannot load calibration:", err)
+		}
+	}
+	if tiltMin > tiltMax {
+		log.Fatalln("tiltmin is greater than tiltmax")
+	}
+	rng := rand.New(rand.NewSource(seed))
+
 	cnt := 0
+	tilt, sweep := tiltMin, 1.0
 	timeStart := time.Now()
 	stdout.WriteString("! 0 0\n")
 	for {
 		cnt++
 		timeDiff := time.Now().Sub(timeStart)
 
-		stdout.WriteString("# Dummy lidar-scan data\n") // a comment line for tests
+		// the servo sweeps between the limits, the tilt is the ground truth
+		// the fused clouds can be compared against
+		stdout.WriteString(fmt.Sprintf("# tilt %f\n", tilt))
+		tf := scene.Pose.Mul(mount.LidarToBase(tilt))
 		for i := 0; i < pts; i++ {
-			stdout.WriteString(fmt.Sprintf("%f %f\n", float32(i)/float32(pts)*360, float32(cnt*10000)+float32(i)))
+			angle := float64(i) / pts * 360
+			dir := tf.R.MulVec(calib.LidarPoint(angle+rng.NormFloat64()*jitter, 1))
+			dist := scene.Raycast(tf.T, dir) + rng.NormFloat64()*noise
+			if dist < minRange || dist > maxRange || rng.Float64() < dropout {
+				dist = 0
+			}
+			stdout.WriteString(fmt.Sprintf("%f %f\n", angle, dist))
 		}
 		stdout.WriteString(fmt.Sprintf("! %d %d\n", cnt, timeDiff.Milliseconds()))
 		stdout.Flush()
 
+		if next := tilt + sweep*tiltStep; next > tiltMax || next < tiltMin {
+			sweep = -sweep
+		}
+		tilt = math.Max(tiltMin, math.Min(tiltMax, tilt+sweep*tiltStep))
+
 		timeStart = time.Now()
 
 		time.Sleep(time.Millisecond * (1000 / rps))
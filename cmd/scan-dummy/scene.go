@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// Scene is the geometry the dummy lidar measures. Distances are in
+// millimeters, the scene frame has Z up.
+type Scene struct {
+	Pose      cloud.Transform // base frame of the rig to the scene frame
+	Boxes     []Box
+	Planes    []Plane
+	Cylinders []Cylinder
+}
+
+// Box is an axis aligned box. A ray from inside hits its walls, so a box is
+// a room as well as an obstacle.
+type Box struct {
+	Min, Max cloud.Point
+}
+
+// Plane is an infinite plane, e.g. a floor larger than the range.
+type Plane struct {
+	Point  cloud.Point // any point on the plane
+	Normal cloud.Point
+}
+
+// Cylinder is a capped cylinder of the height along the axis from the base,
+// e.g. a pillar or a pipe.
+type Cylinder struct {
+	Base   cloud.Point // center of the bottom cap
+	Axis   cloud.Point // unit vector
+	Radius float64
+	Height float64
+}
+
+// DefaultScene is a 6x4x2.8 m room with a box, a pillar and the rig in the
+// middle at 1 m above the floor.
+var DefaultScene = Scene{
+	Pose: cloud.Transform{R: cloud.Identity3, T: cloud.Point{Z: 1000}},
+	Boxes: []Box{
+		{Min: cloud.Point{X: -3000, Y: -2000}, Max: cloud.Point{X: 3000, Y: 2000, Z: 2800}},
+		{Min: cloud.Point{X: 1200, Y: 600}, Max: cloud.Point{X: 2200, Y: 1400, Z: 800}},
+	},
+	Cylinders: []Cylinder{
+		{Base: cloud.Point{X: -1500, Y: -1000}, Axis: cloud.Point{Z: 1}, Radius: 150, Height: 2800},
+	},
+}
+
+// Raycast returns the distance to the first surface hit by the ray from o in
+// the direction d (a unit vector), or +Inf if there is none.
+func (s *Scene) Raycast(o, d cloud.Point) float64 {
+	best := math.Inf(1)
+	for _, b := range s.Boxes {
+		best = math.Min(best, b.hit(o, d))
+	}
+	for _, p := range s.Planes {
+		best = math.Min(best, p.hit(o, d))
+	}
+	for _, c := range s.Cylinders {
+		best = math.Min(best, c.hit(o, d))
+	}
+	return best
+}
+
+// firstHit returns the first positive bound of the interval [t0, t1] the ray
+// is inside a solid for, or +Inf.
+func firstHit(t0, t1 float64) float64 {
+	switch {
+	case t0 > t1 || t1 <= 0:
+		return math.Inf(1)
+	case t0 > 0:
+		return t0
+	default:
+		return t1
+	}
+}
+
+func (b Box) hit(o, d cloud.Point) float64 {
+	lo := [3]float64{b.Min.X, b.Min.Y, b.Min.Z}
+	hi := [3]float64{b.Max.X, b.Max.Y, b.Max.Z}
+	oc := [3]float64{o.X, o.Y, o.Z}
+	dc := [3]float64{d.X, d.Y, d.Z}
+	t0, t1 := math.Inf(-1), math.Inf(1)
+	for i := 0; i < 3; i++ {
+		if dc[i] == 0 {
+			if oc[i] < lo[i] || oc[i] > hi[i] {
+				return math.Inf(1)
+			}
+			continue
+		}
+		a, b := (lo[i]-oc[i])/dc[i], (hi[i]-oc[i])/dc[i]
+		if a > b {
+			a, b = b, a
+		}
+		t0, t1 = math.Max(t0, a), math.Min(t1, b)
+	}
+	return firstHit(t0, t1)
+}
+
+func (p Plane) hit(o, d cloud.Point) float64 {
+	dn := d.Dot(p.Normal)
+	if dn == 0 {
+		return math.Inf(1)
+	}
+	if t := p.Point.Sub(o).Dot(p.Normal) / dn; t > 0 {
+		return t
+	}
+	return math.Inf(1)
+}
+
+func (c Cylinder) hit(o, d cloud.Point) float64 {
+	// the interval between the caps
+	rel := o.Sub(c.Base)
+	h, dh := rel.Dot(c.Axis), d.Dot(c.Axis)
+	t0, t1 := math.Inf(-1), math.Inf(1)
+	if dh == 0 {
+		if h < 0 || h > c.Height {
+			return math.Inf(1)
+		}
+	} else {
+		t0, t1 = -h/dh, (c.Height-h)/dh
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+	}
+
+	// the interval inside the infinite cylinder, solved across the axis
+	rp := rel.Sub(c.Axis.Scale(h))
+	dp := d.Sub(c.Axis.Scale(dh))
+	a, b, k := dp.Dot(dp), rp.Dot(dp), rp.Dot(rp)-c.Radius*c.Radius
+	if a == 0 {
+		if k > 0 {
+			return math.Inf(1)
+		}
+	} else {
+		disc := b*b - a*k
+		if disc < 0 {
+			return math.Inf(1)
+		}
+		sq := math.Sqrt(disc)
+		t0, t1 = math.Max(t0, (-b-sq)/a), math.Min(t1, (-b+sq)/a)
+	}
+	return firstHit(t0, t1)
+}
+
+// sceneJSON is the scene file.
+type sceneJSON struct {
+	Pose  *calib.TransformJSON `json:"pose"` // identity if omitted
+	Boxes []struct {
+		Min [3]float64 `json:"min"`
+		Max [3]float64 `json:"max"`
+	} `json:"boxes"`
+	Planes []struct {
+		Point  [3]float64 `json:"point"`
+		Normal [3]float64 `json:"normal"`
+	} `json:"planes"`
+	Cylinders []struct {
+		Base   [3]float64  `json:"base"`
+		Axis   *[3]float64 `json:"axis"` // Z if omitted
+		Radius float64     `json:"radius"`
+		Height float64     `json:"height"`
+	} `json:"cylinders"`
+}
+
+func vec(v [3]float64) cloud.Point {
+	return cloud.Point{X: v[0], Y: v[1], Z: v[2]}
+}
+
+// unit returns v normalized, or an error naming the vector if it is zero.
+func unit(v [3]float64, name string) (cloud.Point, error) {
+	p := vec(v)
+	n := math.Sqrt(p.Dot(p))
+	if n == 0 {
+		return cloud.Point{}, fmt.Errorf("%s is zero", name)
+	}
+	return p.Scale(1 / n), nil
+}
+
+// LoadScene reads the scene from a JSON file.
+func LoadScene(path string) (*Scene, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var j sceneJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	scene := &Scene{Pose: j.Pose.Transform()}
+	for i, b := range j.Boxes {
+		box := Box{Min: vec(b.Min), Max: vec(b.Max)}
+		if box.Min.X > box.Max.X || box.Min.Y > box.Max.Y || box.Min.Z > box.Max.Z {
+			return nil, fmt.Errorf("%s: box %d: min %v is above max %v", path, i, b.Min, b.Max)
+		}
+		scene.Boxes = append(scene.Boxes, box)
+	}
+	for i, p := range j.Planes {
+		n, err := unit(p.Normal, fmt.Sprintf("normal of plane %d", i))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		scene.Planes = append(scene.Planes, Plane{Point: vec(p.Point), Normal: n})
+	}
+	for i, c := range j.Cylinders {
+		if c.Radius <= 0 || c.Height <= 0 {
+			return nil, fmt.Errorf("%s: cylinder %d: radius %g and height %g must be positive", path, i, c.Radius, c.Height)
+		}
+		axis := cloud.Point{Z: 1}
+		if c.Axis != nil {
+			if axis, err = unit(*c.Axis, fmt.Sprintf("axis of cylinder %d", i)); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		scene.Cylinders = append(scene.Cylinders, Cylinder{Base: vec(c.Base), Axis: axis, Radius: c.Radius, Height: c.Height})
+	}
+	return scene, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// dir returns the unit vector of v.
+func dir(x, y, z float64) cloud.Point {
+	p := cloud.Point{X: x, Y: y, Z: z}
+	return p.Scale(1 / math.Sqrt(p.Dot(p)))
+}
+
+// hitCase is a ray and the distance it should hit at, +Inf for a miss.
+type hitCase struct {
+	name string
+	o, d cloud.Point
+	want float64
+}
+
+func checkHits(t *testing.T, shape string, hit func(o, d cloud.Point) float64, cases []hitCase) {
+	t.Helper()
+	for _, c := range cases {
+		got := hit(c.o, c.d)
+		if math.IsInf(c.want, 1) && !math.IsInf(got, 1) || math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s %s: hit at %g, want %g", shape, c.name, got, c.want)
+		}
+	}
+}
+
+func TestBoxHit(t *testing.T) {
+	box := Box{Min: cloud.Point{X: -1000, Y: -2000, Z: 0}, Max: cloud.Point{X: 3000, Y: 2000, Z: 2500}}
+	inside := cloud.Point{Z: 1000}
+	inf := math.Inf(1)
+	checkHits(t, "box", box.hit, []hitCase{
+		{"from inside", inside, dir(1, 0, 0), 3000},
+		{"from inside down", inside, dir(0, 0, -1), 1000},
+		{"from inside to a corner", inside, dir(3000, 2000, 1500), math.Sqrt(3000*3000 + 2000*2000 + 1500*1500)},
+		{"from outside", cloud.Point{X: -5000, Z: 1000}, dir(1, 0, 0), 4000},
+		{"from outside obliquely", cloud.Point{X: -2000, Y: -3000, Z: 1000}, dir(1, 1, 0), math.Sqrt2 * 1000},
+		{"away from it", cloud.Point{X: -5000, Z: 1000}, dir(-1, 0, 0), inf},
+		{"beside it", cloud.Point{X: -5000, Y: 2500, Z: 1000}, dir(1, 0, 0), inf},
+		{"above it", cloud.Point{X: -5000, Z: 3000}, dir(1, 0, 0.1), inf},
+	})
+}
+
+func TestPlaneHit(t *testing.T) {
+	floor := Plane{Point: cloud.Point{Z: -500}, Normal: cloud.Point{Z: 1}}
+	inf := math.Inf(1)
+	checkHits(t, "plane", floor.hit, []hitCase{
+		{"down", cloud.Point{}, dir(0, 0, -1), 500},
+		{"obliquely", cloud.Point{}, dir(1, 0, -1), 500 * math.Sqrt2},
+		{"from below", cloud.Point{Z: -1500}, dir(0, 0, 1), 1000},
+		{"up", cloud.Point{}, dir(0, 0, 1), inf},
+		{"parallel", cloud.Point{}, dir(1, 1, 0), inf},
+	})
+}
+
+func TestCylinderHit(t *testing.T) {
+	pillar := Cylinder{Base: cloud.Point{X: 1000}, Axis: cloud.Point{Z: 1}, Radius: 200, Height: 2000}
+	inf := math.Inf(1)
+	checkHits(t, "cylinder", pillar.hit, []hitCase{
+		{"side", cloud.Point{Z: 1000}, dir(1, 0, 0), 800},
+		{"side off the center", cloud.Point{Y: 120, Z: 1000}, dir(1, 0, 0), 1000 - 160},
+		{"top cap", cloud.Point{X: 1100, Z: 3000}, dir(0, 0, -1), 1000},
+		{"bottom cap", cloud.Point{X: 1000, Z: -500}, dir(0, 0, 1), 500},
+		{"from inside", cloud.Point{X: 1000, Z: 1000}, dir(0, -1, 0), 200},
+		{"beside it", cloud.Point{Y: 250, Z: 1000}, dir(1, 0, 0), inf},
+		{"above it", cloud.Point{Z: 2500}, dir(1, 0, 0), inf},
+		{"away from it", cloud.Point{Z: 1000}, dir(-1, 0, 0), inf},
+		{"along the axis beside it", cloud.Point{X: 1500, Z: -500}, dir(0, 0, 1), inf},
+	})
+
+	tilted := Cylinder{Axis: dir(1, 0, 0), Radius: 100, Height: 1000}
+	checkHits(t, "tilted cylinder", tilted.hit, []hitCase{
+		{"side", cloud.Point{X: 500, Z: 1000}, dir(0, 0, -1), 900},
+		{"cap", cloud.Point{X: -300}, dir(1, 0, 0), 300},
+	})
+}
+
+func TestRaycast(t *testing.T) {
+	scene := DefaultScene
+	inf := math.Inf(1)
+	checkHits(t, "default scene", scene.Raycast, []hitCase{
+		{"wall", cloud.Point{Z: 1000}, dir(1, 0, 0), 3000},
+		{"ceiling", cloud.Point{Z: 1000}, dir(0, 0, 1), 1800},
+		{"box", cloud.Point{Z: 500}, dir(1700, 1000, 0), math.Hypot(1200, 1200*1000.0/1700)},
+		{"pillar", cloud.Point{Y: -1000, Z: 1000}, dir(-1, 0, 0), 1350},
+		{"pillar from behind the wall", cloud.Point{X: -3500, Y: -1000, Z: 1000}, dir(1, 0, 0), 500},
+		{"out of the room", cloud.Point{X: -3500, Z: 1000}, dir(-1, 0, 0), inf},
+	})
+
+	empty := &Scene{}
+	if d := empty.Raycast(cloud.Point{}, dir(1, 0, 0)); !math.IsInf(d, 1) {
+		t.Errorf("ray in an empty scene hit at %g", d)
+	}
+}
+
+func TestLoadScene(t *testing.T) {
+	write := func(data string) string {
+		path := filepath.Join(t.TempDir(), "scene.json")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	scene, err := LoadScene(write(`{
+		"pose": {"translation": [100, 0, 1000], "rpy": [0, 0, 90]},
+		"boxes": [{"min": [-3000, -2000, 0], "max": [3000, 2000, 2800]}],
+		"planes": [{"point": [0, 0, 0], "normal": [0, 0, 2]}],
+		"cylinders": [{"base": [1000, 0, 0], "radius": 150, "height": 2800}, {"base": [0, 0, 500], "axis": [0, 3, 0], "radius": 50, "height": 10}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := scene.Pose.Apply(cloud.Point{X: 1000}); p.Sub(cloud.Point{X: 100, Y: 1000, Z: 1000}).Norm() > 1e-9 {
+		t.Errorf("pose moves X to %v", p)
+	}
+	if len(scene.Boxes) != 1 || len(scene.Planes) != 1 || len(scene.Cylinders) != 2 {
+		t.Fatalf("loaded %d boxes, %d planes and %d cylinders", len(scene.Boxes), len(scene.Planes), len(scene.Cylinders))
+	}
+	if n := scene.Planes[0].Normal; n != (cloud.Point{Z: 1}) {
+		t.Errorf("plane normal %v, want a unit vector", n)
+	}
+	if a, b := scene.Cylinders[0].Axis, scene.Cylinders[1].Axis; a != (cloud.Point{Z: 1}) || b != (cloud.Point{Y: 1}) {
+		t.Errorf("cylinder axes %v and %v, want Z by default and a unit vector", a, b)
+	}
+
+	if scene, err := LoadScene(write(`{"boxes": [{"min": [0, 0, 0], "max": [1, 1, 1]}]}`)); err != nil || scene.Pose != cloud.IdentityTransform {
+		t.Errorf("scene without a pose: %v, %v", scene, err)
+	}
+
+	for _, data := range []string{
+		`{"boxes": [{"min": [0, 0, 0], "max": [1000, -1000, 1000]}]}`,
+		`{"planes": [{"point": [0, 0, 0], "normal": [0, 0, 0]}]}`,
+		`{"cylinders": [{"base": [0, 0, 0], "radius": 0, "height": 1000}]}`,
+		`{"cylinders": [{"base": [0, 0, 0], "radius": 100, "height": -1000}]}`,
+		`{"cylinders": [{"base": [0, 0, 0], "axis": [0, 0, 0], "radius": 100, "height": 1000}]}`,
+		`{"boxes": [}`,
+	} {
+		if _, err := LoadScene(write(data)); err == nil {
+			t.Errorf("LoadScene(%s) succeeded", data)
+		}
+	}
+}
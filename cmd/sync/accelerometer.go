@@ -92,6 +92,7 @@ type Accel struct {
 	deltaTime   float64
 	port        io.Reader
 	data        AccelDataUnion
+	calibrate   chan chan error // calibration requests, the result is sent back
 }
 
 // MPU-6050 predefined calibrations
@@ -152,6 +153,15 @@ func (accel *Accel) StartLoop(channel chan AccelDataUnion) (err error) {
 			continue
 		}
 
+		select {
+		case result := <-accel.calibrate:
+			accel.calibration = noAccelCalib // Calibrate accumulates offsets from zero
+			result <- accel.Calibrate(1000)
+			est.ResetAll(true)
+			continue
+		default:
+		}
+
 		accel.PreprocessDataForEst()
 
 		est.Update(0.02, // POSSIBLE ERROR SOURCE: 0.02 is hardcoded but it might be calculated using timept
@@ -318,6 +328,20 @@ func (accel *Accel) ReadAccelFrame(data []byte, length int) (err error) {
 	return nil
 }
 
+// RequestCalibration makes the accel loop calibrate the accel again and waits
+// for the result.
+func (accel *Accel) RequestCalibration() error {
+	if !accel.use {
+		return errors.New("accel is unused")
+	}
+
+	result := make(chan error)
+	accel.calibrate <- result
+	return <-result
+}
+
+// Calibrate computes the calibration offsets from n measurements. The device
+// must not move in the meantime.
 func (accel *Accel) Calibrate(n int) (err error) {
 	log.Println("***** ACCEL CALIBRATION STARTING *****")
 	for i := 3; i > 0; i-- {
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/rosbag"
+)
+
+// ROS topics and frames used in recorded bags.
+const (
+	bagTopicScan   = "/lidar/scan"
+	bagTopicPoints = "/lidar/points"
+	bagTopicImu    = "/imu/data"
+	bagTopicServo  = "/servo/joint_states"
+
+	bagFrameLidar = "lidar"
+	bagFrameBase  = "base"
+	bagFrameImu   = "imu"
+
+	bagServoJoint = "servo"
+)
+
+// LaserScan parameters. Measurements of a LidarCloud are not evenly spaced, so
+// they are binned, the shortest distance in a bin wins.
+const (
+	laserScanBins     = 1440 // 0.25 deg
+	laserScanRangeMin = 0.15 // m
+	laserScanRangeMax = 25.0 // m
+	gravity           = 9.80665
+)
+
+// bagQueueSize is the number of messages waiting to be written. When it is
+// exceeded (the disk is slower than the sensors), new messages are dropped.
+const bagQueueSize = 256
+
+// bagMessage is a serialized message waiting to be written.
+type bagMessage struct {
+	conn *rosbag.Connection
+	time time.Time
+	data []byte
+}
+
+// BagRecorder writes raw scans, fused points, accel and servo data to a ROS bag.
+// Distances are converted from millimeters to meters. Messages are serialized
+// by the caller and written in its own goroutine, so the sensor loops are not
+// stalled by the disk.
+type BagRecorder struct {
+	file    *os.File
+	bag     *rosbag.Writer
+	scan    *rosbag.Connection
+	points  *rosbag.Connection
+	imu     *rosbag.Connection
+	servo   *rosbag.Connection
+	seq     map[uint32]uint32 // next header seq of each connection
+	queue   chan bagMessage
+	stopped chan struct{}
+	dropped int // messages dropped because the queue was full
+
+	accelScale float64
+}
+
+// NewBagRecorder creates the bag file. accelScale is used to convert raw accel
+// measurements to m/s^2.
+func NewBagRecorder(path string, accelScale float64) (*BagRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create bag: %v", err)
+	}
+
+	bag, err := rosbag.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	rec := &BagRecorder{
+		file:    file,
+		bag:     bag,
+		scan:    bag.AddConnection(bagTopicScan, rosbag.TypeLaserScan, rosbag.MD5LaserScan, rosbag.DefinitionLaserScan),
+		points:  bag.AddConnection(bagTopicPoints, rosbag.TypePointCloud2, rosbag.MD5PointCloud2, rosbag.DefinitionPointCloud2),
+		imu:     bag.AddConnection(bagTopicImu, rosbag.TypeImu, rosbag.MD5Imu, rosbag.DefinitionImu),
+		servo:   bag.AddConnection(bagTopicServo, rosbag.TypeJointState, rosbag.MD5JointState, rosbag.DefinitionJointState),
+		seq:     make(map[uint32]uint32),
+		queue:   make(chan bagMessage, bagQueueSize),
+		stopped: make(chan struct{}),
+
+		accelScale: accelScale,
+	}
+	go rec.loop()
+	return rec, nil
+}
+
+// loop writes the queued messages until the queue is closed.
+func (rec *BagRecorder) loop() {
+	defer close(rec.stopped)
+	for msg := range rec.queue {
+		if err := rec.bag.WriteMessage(msg.conn, msg.time, msg.data); err != nil {
+			log.Printf("unable to write %s to the bag: %v", msg.conn.Topic, err)
+		}
+	}
+}
+
+// write queues the message, it is dropped if the queue is full.
+func (rec *BagRecorder) write(conn *rosbag.Connection, t time.Time, data []byte) error {
+	select {
+	case rec.queue <- bagMessage{conn, t, data}:
+	default:
+		rec.dropped++
+	}
+	return nil
+}
+
+func (rec *BagRecorder) header(conn *rosbag.Connection, t time.Time, frame string) rosbag.Header {
+	seq := rec.seq[conn.ID]
+	rec.seq[conn.ID]++
+	return rosbag.Header{Seq: seq, Stamp: t, FrameID: frame}
+}
+
+// WriteScan writes the raw 2D cloud as a LaserScan.
+func (rec *BagRecorder) WriteScan(cloud *LidarCloud) error {
+	ranges := make([]float32, laserScanBins)
+	for i := range ranges {
+		ranges[i] = float32(math.Inf(1)) // no return
+	}
+
+	for i := 0; i < int(cloud.Size); i++ {
+		m := cloud.Data[i]
+		if m.Dist == 0 {
+			continue
+		}
+
+		bin := int(math.Round(m.Angle/360*laserScanBins)) % laserScanBins
+		if bin < 0 {
+			bin += laserScanBins
+		}
+		r := float32(m.Dist / 1000)
+		if r < ranges[bin] {
+			ranges[bin] = r
+		}
+	}
+
+	var timeIncrement float32
+	if cloud.Size != 0 {
+		timeIncrement = float32(cloud.TimeDiff) / 1000 / float32(cloud.Size)
+	}
+	increment := 2 * math.Pi / laserScanBins
+	msg := rosbag.LaserScan{
+		Header:         rec.header(rec.scan, cloud.TimeBegin, bagFrameLidar),
+		AngleMin:       0,
+		AngleMax:       float32(2*math.Pi - increment),
+		AngleIncrement: float32(increment),
+		TimeIncrement:  timeIncrement,
+		ScanTime:       float32(cloud.TimeDiff) / 1000,
+		RangeMin:       laserScanRangeMin,
+		RangeMax:       laserScanRangeMax,
+		Ranges:         ranges,
+	}
+	return rec.write(rec.scan, cloud.TimeBegin, msg.Marshal())
+}
+
+// WriteFused writes fused points as a PointCloud2.
+func (rec *BagRecorder) WriteFused(fused *FusedCloud) error {
+	msg := rosbag.NewXYZCloud(rec.header(rec.points, fused.Time, bagFrameBase), PointsToMeters(fused.Points))
+	return rec.write(rec.points, fused.Time, msg.Marshal())
+}
+
+// WriteAccel writes the accel measurement as Imu. The raw measurement must be
+// preprocessed for the attitude estimator (gyro in rad/s, accel not scaled).
+func (rec *BagRecorder) WriteAccel(data AccelDataUnion) error {
+	accelScale := rec.accelScale
+	q := data.quat
+	raw := data.raw
+	msg := rosbag.Imu{
+		Header:             rec.header(rec.imu, q.timept, bagFrameImu),
+		Orientation:        [4]float64{q.qx, q.qy, q.qz, q.qw},
+		AngularVelocity:    [3]float64{raw.xGyro, raw.yGyro, raw.zGyro},
+		LinearAcceleration: [3]float64{raw.xAccel / accelScale * gravity, raw.yAccel / accelScale * gravity, raw.zAccel / accelScale * gravity},
+	}
+	return rec.write(rec.imu, q.timept, msg.Marshal())
+}
+
+// WriteServo writes the servo angle (in degrees) as JointState.
+func (rec *BagRecorder) WriteServo(data ServoData, deg float64) error {
+	msg := rosbag.JointState{
+		Header:   rec.header(rec.servo, data.timept, ""),
+		Name:     []string{bagServoJoint},
+		Position: []float64{DegToRad(deg)},
+	}
+	return rec.write(rec.servo, data.timept, msg.Marshal())
+}
+
+// Close writes the queued messages and the bag index and closes the file.
+func (rec *BagRecorder) Close() error {
+	close(rec.queue)
+	<-rec.stopped
+	if rec.dropped != 0 {
+		log.Printf("%d bag messages were dropped, the disk was too slow", rec.dropped)
+	}
+	if err := rec.bag.Close(); err != nil {
+		rec.file.Close()
+		return err
+	}
+	return rec.file.Close()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
+)
+
+// calibSettleTime is the time given to the servo to reach the calibration
+// position before the accel calibration starts.
+const calibSettleTime = 2 * time.Second
+
+// StartControl starts the remote control server on addr. Requests are executed
+// by the servo and accel loops.
+func StartControl(addr string, key []byte, servo *Servo, accel *Accel) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %v", addr, err)
+	}
+
+	server, err := netproto.NewControlServer(conn, key, controlHandler(servo, accel))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	log.Println("control server is listening on", conn.LocalAddr())
+	if key == nil {
+		log.Println("warning: control requests are not authenticated, anyone on the network can move the servo (give --psk or --pskfile)")
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Println("control server stopped:", err)
+		}
+	}()
+	return nil
+}
+
+// controlHandler returns the handler of the remote control requests.
+func controlHandler(servo *Servo, accel *Accel) netproto.Handler {
+	return func(req *netproto.Request) *netproto.Response {
+		log.Println("control request:", req.Command)
+
+		var err error
+		switch req.Command {
+		case netproto.CommandStatus:
+		case netproto.CommandServoSet:
+			doErr := servo.Do(func(servo *Servo) {
+				if req.Position < servo.positonMin || req.Position > servo.positonMax {
+					err = fmt.Errorf("position %d is out of range [%d, %d]", req.Position, servo.positonMin, servo.positonMax)
+					return
+				}
+				servo.holding = true
+				servo.data.positon = req.Position
+			})
+			if doErr != nil {
+				err = doErr
+			}
+		case netproto.CommandServoSweep:
+			err = servo.Do(func(servo *Servo) {
+				servo.holding = false
+			})
+		case netproto.CommandSweepLimits:
+			switch {
+			case req.Min >= req.Max:
+				err = fmt.Errorf("min %d must be smaller than max %d", req.Min, req.Max)
+			case req.Min < servoMinPos || req.Max > servoMaxPos:
+				err = fmt.Errorf("limits [%d, %d] are out of the servo range [%d, %d]", req.Min, req.Max, servoMinPos, servoMaxPos)
+			default:
+				err = servo.Do(func(servo *Servo) {
+					servo.positonMin = req.Min
+					servo.positonMax = req.Max
+					if req.Step != 0 {
+						servo.SetStep(req.Step)
+					}
+				})
+			}
+		case netproto.CommandCalibrate:
+			err = calibrate(servo, accel)
+		default:
+			err = fmt.Errorf("unknown command %q", req.Command)
+		}
+
+		var status *netproto.ServoStatus
+		if err == nil {
+			status, err = servoStatus(servo)
+		}
+		if err != nil {
+			log.Printf("control request %s failed: %v\n", req.Command, err)
+			return &netproto.Response{Error: err.Error()}
+		}
+		return &netproto.Response{OK: true, Status: status}
+	}
+}
+
+// calibrate moves the servo to the calibration position, calibrates the accel
+// and restores the previous servo state.
+func calibrate(servo *Servo, accel *Accel) error {
+	if !accel.use {
+		return errors.New("the accelerometer is not used (--acceluse)")
+	}
+
+	var holding bool
+	var position uint16
+	err := servo.Do(func(servo *Servo) {
+		holding, position = servo.holding, servo.data.positon
+		servo.holding = true
+		servo.data.positon = servo.positonCalib
+	})
+	if err != nil {
+		return err
+	}
+	defer servo.Do(func(servo *Servo) {
+		servo.holding = holding
+		servo.data.positon = position
+	})
+
+	time.Sleep(calibSettleTime)
+	return accel.RequestCalibration()
+}
+
+// servoStatus returns the current servo state.
+func servoStatus(servo *Servo) (status *netproto.ServoStatus, err error) {
+	err = servo.Do(func(servo *Servo) {
+		status = &netproto.ServoStatus{
+			Position: servo.data.positon,
+			Min:      servo.positonMin,
+			Max:      servo.positonMax,
+			Calib:    servo.positonCalib,
+			Step:     servo.Step(),
+			Sweeping: !servo.holding,
+		}
+	})
+	return status, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// Deskew corrects clouds for the motion of the rig during a lidar rotation.
+// Every measurement is moved from the pose of the IMU frame at its own time to
+// the pose at the cloud begin. The rotation is integrated from the gyro rates,
+// the translation follows a constant velocity.
+type Deskew struct {
+	Accel    *AccelDataBuffer // gyro rates in rad/s, measured in the raw accel mode
+	Velocity cloud.Point      // velocity of the IMU frame in mm/s, in its pose at the cloud begin
+}
+
+// Motion is the pose of the IMU frame during a cloud relative to its pose at
+// the cloud begin.
+type Motion struct {
+	times     []time.Duration // since the cloud begin, when the rates were measured
+	rates     []cloud.Point   // rad/s, constant until the next time
+	rotations []cloud.Mat3    // at the times
+	velocity  cloud.Point
+}
+
+// Motion returns the motion during the cloud from the gyro rates measured
+// since the latest rate before the cloud began. The latest rate holds until
+// the end of the cloud.
+func (d *Deskew) Motion(lidarCloud *LidarCloud) *Motion {
+	var samples []AccelData
+	for j := 0; j < d.Accel.size; j++ { // from the latest to the earliest
+		a, err := d.Accel.Get(j)
+		if err != nil || a.raw.timept.IsZero() {
+			break
+		}
+		samples = append(samples, a.raw)
+		if a.raw.timept.Before(lidarCloud.TimeBegin) {
+			break
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].timept.Before(samples[j].timept) })
+
+	m := &Motion{velocity: d.Velocity}
+	rotation := cloud.Identity3
+	for i, s := range samples {
+		t := s.timept.Sub(lidarCloud.TimeBegin)
+		if t < 0 {
+			t = 0
+		}
+		if i > 0 {
+			rotation = rotation.Mul(rotationByRate(m.rates[i-1], t-m.times[i-1]))
+		}
+		m.times = append(m.times, t)
+		m.rates = append(m.rates, cloud.Point{X: s.xGyro, Y: s.yGyro, Z: s.zGyro})
+		m.rotations = append(m.rotations, rotation)
+	}
+	return m
+}
+
+// rotationByRate returns the rotation by the angular rate in rad/s for the
+// duration.
+func rotationByRate(rate cloud.Point, d time.Duration) cloud.Mat3 {
+	return cloud.RotationFromVector(rate.Scale(d.Seconds()))
+}
+
+// At returns the pose of the IMU frame at the time since the cloud begin.
+func (m *Motion) At(t time.Duration) cloud.Transform {
+	tf := cloud.Transform{R: cloud.Identity3, T: m.velocity.Scale(t.Seconds())}
+	// the latest rate measured before t
+	k := sort.Search(len(m.times), func(i int) bool { return m.times[i] > t }) - 1
+	if k >= 0 {
+		tf.R = m.rotations[k].Mul(rotationByRate(m.rates[k], t-m.times[k]))
+	}
+	return tf
+}
+
+// PointTime returns the time of the i-th measurement of the cloud since the
+// cloud begin, the measurements are evenly spaced over the rotation.
+func PointTime(cloud *LidarCloud, i int) time.Duration {
+	return time.Duration(cloud.TimeDiff) * time.Millisecond * time.Duration(i) / time.Duration(cloud.Size)
+}
+
+// parseVec3 parses "x,y,z".
+func parseVec3(s string) (cloud.Point, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return cloud.Point{}, fmt.Errorf("%q: expected x,y,z", s)
+	}
+	var v [3]float64
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return cloud.Point{}, fmt.Errorf("%q: %v", s, err)
+		}
+	}
+	return cloud.Point{X: v[0], Y: v[1], Z: v[2]}, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+func TestDeskew(t *testing.T) {
+	rate := cloud.Point{X: 0.3, Y: -0.4, Z: 0.9} // rad/s
+	velocity := cloud.Point{X: 800, Y: -300, Z: 100}
+	servo := &Servo{positonCalib: servoCalibPos, unitToDeg: servoUnitToDeg}
+	mount := calib.SimpleMount(PrototypeCloudRotation, servoUnitToDeg)
+	mount.LidarToHead.T = cloud.Point{X: 60, Z: -30}
+	const pos = 2100
+
+	// the rig turns at the constant rate and moves at the constant velocity
+	// since before the cloud began, the pose at the cloud begin is the identity
+	begin := time.Now()
+	accel := NewAccelDataBuffer(4)
+	accel.Append(AccelDataUnion{raw: AccelData{timept: begin.Add(-time.Second)}})
+	for _, dt := range []time.Duration{-10 * time.Millisecond, 30 * time.Millisecond, 70 * time.Millisecond} {
+		accel.Append(AccelDataUnion{raw: AccelData{xGyro: rate.X, yGyro: rate.Y, zGyro: rate.Z, timept: begin.Add(dt)}})
+	}
+	servoData := NewServoDataBuffer(1)
+	servoData.Append(ServoData{positon: pos, timept: begin.Add(-time.Second)})
+
+	lidarCloud := &LidarCloud{TimeBegin: begin, TimeDiff: 100}
+	const measurements = 720
+	lidarCloud.Size = measurements
+	lidarToBase := mount.LidarToBase(servo.PositionToDeg(pos))
+	for i := 0; i < measurements; i++ {
+		angle := float64(i) / 2
+		lidarCloud.Data[i].Angle = angle
+		s := (time.Duration(lidarCloud.TimeDiff) * time.Millisecond * time.Duration(i) / measurements).Seconds()
+		rig := cloud.Transform{R: cloud.RotationFromVector(rate.Scale(s)), T: velocity.Scale(s)}
+		lidar := rig.Mul(lidarToBase)
+		o, u := lidar.T, lidar.R.MulVec(calib.LidarPoint(angle, 1))
+		lidarCloud.Data[i].Dist = raycast(Vec3{o.X, o.Y, o.Z}, Vec3{u.X, u.Y, u.Z})
+	}
+
+	fusion := &Fusion{Mount: mount, Mode: FusionServo}
+	points, _, _ := fusion.Update(lidarCloud, &servoData, servo, &accel)
+	worst := 0.0
+	for _, p := range points {
+		worst = math.Max(worst, wallDist(p))
+	}
+	if worst < 20 {
+		t.Fatalf("the cloud without deskewing is at most %g mm off the walls, the motion is too slow to test", worst)
+	}
+
+	fusion.Deskew = &Deskew{Accel: &accel, Velocity: velocity}
+	points, _, _ = fusion.Update(lidarCloud, &servoData, servo, &accel)
+	if len(points) != measurements {
+		t.Fatalf("%d points deskewed from %d measurements", len(points), measurements)
+	}
+	for i, p := range points {
+		if d := wallDist(p); d > 1e-6 {
+			t.Fatalf("point %d %v is %g off the walls after deskewing", i, p, d)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/knei-knurow/lidar-tools/foxglove"
+)
+
+// Foxglove channels.
+const (
+	foxgloveTopicCloud  = "/lidar/cloud"
+	foxgloveTopicPoints = "/lidar/points"
+	foxgloveTopicImu    = "/imu/orientation"
+	foxgloveTopicServo  = "/servo/angle"
+
+	foxgloveSchemaNameServo = "lidar_tools.ServoAngle"
+	foxgloveSchemaServo     = `{"type":"object","properties":{` +
+		`"timestamp":{"type":"object","properties":{"sec":{"type":"integer"},"nsec":{"type":"integer"}}},` +
+		`"position":{"type":"integer"},` +
+		`"angle":{"type":"number"}}}`
+)
+
+// ServoAngle is the message of the servo channel.
+type ServoAngle struct {
+	Timestamp foxglove.Time `json:"timestamp"`
+	Position  uint16        `json:"position"` // servo position units
+	Angle     float64       `json:"angle"`    // degrees relative to the calibration position
+}
+
+// FoxglovePublisher serves live data to Foxglove Studio. Distances are converted
+// from millimeters to meters.
+type FoxglovePublisher struct {
+	http   *http.Server
+	server *foxglove.Server
+	cloud  *foxglove.Channel
+	points *foxglove.Channel
+	imu    *foxglove.Channel
+	servo  *foxglove.Channel
+}
+
+// StartFoxglove starts the Foxglove WebSocket server on addr.
+func StartFoxglove(addr string) *FoxglovePublisher {
+	server := foxglove.NewServer("lidar-tools sync")
+	pub := &FoxglovePublisher{
+		http:   &http.Server{Addr: addr, Handler: server},
+		server: server,
+		cloud:  server.AddChannel(foxgloveTopicCloud, foxglove.SchemaNamePointCloud, foxglove.SchemaPointCloud),
+		points: server.AddChannel(foxgloveTopicPoints, foxglove.SchemaNamePointCloud, foxglove.SchemaPointCloud),
+		imu:    server.AddChannel(foxgloveTopicImu, foxglove.SchemaNamePoseInFrame, foxglove.SchemaPoseInFrame),
+		servo:  server.AddChannel(foxgloveTopicServo, foxgloveSchemaNameServo, foxgloveSchemaServo),
+	}
+
+	go func() {
+		log.Println("foxglove server is listening on", addr)
+		if err := pub.http.ListenAndServe(); err != http.ErrServerClosed {
+			log.Println("foxglove server stopped:", err)
+		}
+	}()
+	return pub
+}
+
+// WriteScan publishes the raw 2D cloud as points in the lidar plane.
+func (pub *FoxglovePublisher) WriteScan(cloud *LidarCloud) error {
+	if !pub.server.HasSubscribers(pub.cloud) {
+		return nil
+	}
+
+	points := make([]Vec3, 0, cloud.Size)
+	for i := 0; i < int(cloud.Size); i++ {
+		if cloud.Data[i].Dist == 0 {
+			continue
+		}
+		pt2 := AngleDistToPoint2(&cloud.Data[i])
+		points = append(points, Vec3{pt2.X, pt2.Y, 0})
+	}
+
+	msg := foxglove.NewXYZCloud(cloud.TimeBegin, bagFrameLidar, PointsToMeters(points))
+	return pub.server.Publish(pub.cloud, cloud.TimeBegin, msg)
+}
+
+// WriteFused publishes fused points.
+func (pub *FoxglovePublisher) WriteFused(fused *FusedCloud) error {
+	if !pub.server.HasSubscribers(pub.points) {
+		return nil
+	}
+
+	msg := foxglove.NewXYZCloud(fused.Time, bagFrameBase, PointsToMeters(fused.Points))
+	return pub.server.Publish(pub.points, fused.Time, msg)
+}
+
+// WriteAccel publishes the accel attitude quaternion.
+func (pub *FoxglovePublisher) WriteAccel(data AccelDataUnion) error {
+	q := data.quat
+	msg := foxglove.PoseInFrame{
+		Timestamp: foxglove.NewTime(q.timept),
+		FrameID:   bagFrameBase,
+		Pose: foxglove.Pose{
+			Orientation: foxglove.Quaternion{X: q.qx, Y: q.qy, Z: q.qz, W: q.qw},
+		},
+	}
+	return pub.server.Publish(pub.imu, q.timept, msg)
+}
+
+// WriteServo publishes the servo position and angle (in degrees).
+func (pub *FoxglovePublisher) WriteServo(data ServoData, deg float64) error {
+	msg := ServoAngle{
+		Timestamp: foxglove.NewTime(data.timept),
+		Position:  data.positon,
+		Angle:     deg,
+	}
+	return pub.server.Publish(pub.servo, data.timept, msg)
+}
+
+// Close stops the server.
+func (pub *FoxglovePublisher) Close() error {
+	return pub.http.Close()
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"math"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
 )
 
 // Vec2 represents a (X, Y) vector.
@@ -75,6 +78,16 @@ func RotateVec3ByQuat(v *Vec3, q *Quat) (w Vec3) {
 	return QuatVec3Mult(q, v)
 }
 
+// QuatToRotation returns the rotation matrix of a normalised quaternion.
+func QuatToRotation(q *Quat) cloud.Mat3 {
+	w, x, y, z := q.W, q.X, q.Y, q.Z
+	return cloud.Mat3{
+		{1 - 2*(y*y+z*z), 2 * (x*y - w*z), 2 * (x*z + w*y)},
+		{2 * (x*y + w*z), 1 - 2*(x*x+z*z), 2 * (y*z - w*x)},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), 1 - 2*(x*x+y*y)},
+	}
+}
+
 // RotateVec2 rotates (x, y) around origin (0, 0) by a rads.
 func RotateVec2(v *Vec2, a float64) (w Vec2) {
 	w.X = v.X*math.Cos(a) - v.Y*math.Sin(a)
@@ -82,96 +95,200 @@ func RotateVec2(v *Vec2, a float64) (w Vec2) {
 	return
 }
 
-type Fusion struct {
-	CloudRotation float64 // each scanned 2D cloud will be rotated by CloudRotation radians
-	cloudsCnt     uint
+// PointsToMeters converts points in millimeters to float32 points in meters.
+func PointsToMeters(points []Vec3) [][3]float32 {
+	xyz := make([][3]float32, len(points))
+	for i, p := range points {
+		xyz[i] = [3]float32{float32(p.X / 1000), float32(p.Y / 1000), float32(p.Z / 1000)}
+	}
+	return xyz
 }
 
-const (
-	PrototypeCloudRotation = -math.Pi / 4 // cloud rotation for the first lidar head prototype
-)
+// FilterPoints applies the filters to points.
+func FilterPoints(filter cloud.Filter, points []Vec3) []Vec3 {
+	in := make([]cloud.Point, len(points))
+	for i, p := range points {
+		in[i] = cloud.Point{X: p.X, Y: p.Y, Z: p.Z}
+	}
 
-func (fusion *Fusion) UpdateWithAccel(cloud *LidarCloud, accel *AccelDataBuffer) {
-	if cloud.Size == 0 {
-		return
+	out := filter.Apply(in)
+	points = make([]Vec3, len(out))
+	for i, p := range out {
+		points[i] = Vec3{p.X, p.Y, p.Z}
 	}
+	return points
+}
 
-	// estimated time of a single point measurement
-	// timePerPt := float64(cloud.TimeDiff) / float64(cloud.Size) * 1000
+// Fusion turns lidar clouds into 3D points. Every mode moves the measurements
+// from the lidar frame by the mount to the IMU frame (the frame the attitude is
+// measured in) and by the attitude of the IMU frame, composed once per cloud.
+// With deskewing, the motion of the IMU frame during the cloud is applied to
+// every measurement in between.
+type Fusion struct {
+	calib.Mount            // how the lidar is mounted on the servo
+	Mode        FusionMode // sensors giving the attitude
+	Deskew      *Deskew    // motion correction, disabled if nil
+	cloudsCnt   uint
+}
 
-	var q0 AccelDataQuat
-	for j := 0; j < accel.size; j++ { // from the latest to the earliest
-		a0, _ := accel.Get(j)
-		if a0.quat.timept.Before(cloud.TimeBegin) {
-			q0 = a0.quat
-			break
-		}
+// fuse returns the measurements of the cloud moved from the lidar frame by
+// inner to the IMU frame, by its motion since the cloud began and by outer.
+func (fusion *Fusion) fuse(lidarCloud *LidarCloud, inner, outer cloud.Transform) (points []Vec3) {
+	var motion *Motion
+	if fusion.Deskew != nil {
+		motion = fusion.Deskew.Motion(lidarCloud)
 	}
-
-	for i := 0; i < int(cloud.Size); i++ {
-		if cloud.Data[i].Dist == 0 {
+	tf := outer.Mul(inner)
+	for i := 0; i < int(lidarCloud.Size); i++ {
+		m := &lidarCloud.Data[i]
+		if m.Dist == 0 {
 			continue
 		}
+		p := calib.LidarPoint(m.Angle, m.Dist)
+		if motion != nil {
+			p = outer.Mul(motion.At(PointTime(lidarCloud, i))).Mul(inner).Apply(p)
+		} else {
+			p = tf.Apply(p)
+		}
+		points = append(points, Vec3{p.X, p.Y, p.Z})
+	}
+	return points
+}
 
-		// estimated time point of the i-th measurement
-		// t := cloud.TimeBegin.Add(time.Microsecond * time.Duration(timePerPt*float64(i)))
-
-		// POSSIBLE ERROR SOURCE: there was an idea to take an average of two accel measurements
-		// which would be biased towards the later or earlier one (depending on the t value).
-		// This approach requires additional quaternion computation, more info here:
-		// https://math.stackexchange.com/q/162863/527542
+// attitude returns the rotation by the attitude quaternion.
+func attitude(q *Quat) cloud.Transform {
+	return cloud.Transform{R: QuatToRotation(q)}
+}
 
-		// 1. convert (angle, dist) to (X, Y)
-		pt2 := AngleDistToPoint2(&cloud.Data[i])
+const (
+	PrototypeCloudRotation = -math.Pi / 4 // cloud rotation for the first lidar head prototype
+)
 
-		// 2. rotate lidar cloud depending on the head construction
-		pt2 = RotateVec2(&pt2, fusion.CloudRotation)
+// FusionMode selects the sensors giving the attitude of the lidar.
+type FusionMode int
 
-		// 3. modify (X, Y) to (X, Y, Z) where Z=0
-		pt3 := Vec3{pt2.X, pt2.Y, 0}
+const (
+	FusionServo    FusionMode = iota // the servo tilts the head, the base is level
+	FusionIMU                        // the IMU on the head gives its attitude
+	FusionServoIMU                   // the servo tilts the head, the IMU gives the attitude of the base
+)
 
-		// 4. rotate (X, Y, Z) by accel quaternion to get (X', Y', Z')
-		pt3 = RotateVec3ByQuat(&pt3, &Quat{q0.qw, q0.qx, q0.qy, q0.qz})
+var fusionModeNames = [...]string{
+	FusionServo:    "servo",
+	FusionIMU:      "imu",
+	FusionServoIMU: "servo+imu",
+}
 
-		fmt.Printf("%f\t%f\t%f\n", pt3.X, pt3.Y, pt3.Z)
+func (mode FusionMode) String() string {
+	if mode < 0 || int(mode) >= len(fusionModeNames) {
+		return fmt.Sprintf("FusionMode(%d)", int(mode))
 	}
-
-	fusion.cloudsCnt++
+	return fusionModeNames[mode]
 }
 
-func (fusion *Fusion) UpdateWithServo(cloud *LidarCloud, servoData *ServoDataBuffer, servo *Servo) {
-	if cloud.Size == 0 {
-		return
+// ParseFusionMode returns the mode of the name.
+func ParseFusionMode(name string) (FusionMode, error) {
+	for mode, n := range fusionModeNames {
+		if n == name {
+			return FusionMode(mode), nil
+		}
 	}
+	return 0, fmt.Errorf("unknown fusion mode %q, expected servo, imu or servo+imu", name)
+}
+
+// UsesAccel reports whether the mode needs accel attitudes.
+func (mode FusionMode) UsesAccel() bool {
+	return mode == FusionIMU || mode == FusionServoIMU
+}
 
-	var s0 ServoData
+// latestServo returns the latest servo data set before the cloud began.
+func latestServo(cloud *LidarCloud, servoData *ServoDataBuffer) (s0 ServoData) {
 	for j := 0; j < servoData.size; j++ { // from the latest to the earliest
 		s0, _ = servoData.Get(j)
 		if s0.timept.Before(cloud.TimeBegin) {
 			break
 		}
 	}
+	return s0
+}
 
-	deg := (float64(s0.positon) - float64(servo.positonCalib)) * servo.unitToDeg
+// latestAttitude returns the latest accel attitude measured before the cloud
+// began.
+func latestAttitude(cloud *LidarCloud, accel *AccelDataBuffer) Quat {
+	var q0 AccelDataQuat
+	for j := 0; j < accel.size; j++ { // from the latest to the earliest
+		a0, _ := accel.Get(j)
+		if a0.quat.timept.Before(cloud.TimeBegin) {
+			q0 = a0.quat
+			break
+		}
+	}
+	// POSSIBLE ERROR SOURCE: there was an idea to take an average of two accel measurements
+	// which would be biased towards the later or earlier one (depending on the time point
+	// of every measurement). This approach requires additional quaternion computation,
+	// more info here: https://math.stackexchange.com/q/162863/527542
+	return Quat{q0.qw, q0.qx, q0.qy, q0.qz}
+}
 
-	for i := 0; i < int(cloud.Size); i++ {
-		if cloud.Data[i].Dist == 0 {
-			continue
+// Update returns the 3D points of the cloud fused in the mode of the fusion.
+// The servo position set before the cloud began and its angle in degrees are
+// returned in every mode.
+func (fusion *Fusion) Update(cloud *LidarCloud, servoData *ServoDataBuffer, servo *Servo, accel *AccelDataBuffer) (points []Vec3, pos uint16, deg float64) {
+	switch fusion.Mode {
+	case FusionIMU:
+		if cloud.Size == 0 {
+			return nil, 0, 0
 		}
+		pos = latestServo(cloud, servoData).positon
+		return fusion.UpdateWithAccel(cloud, accel), pos, servo.PositionToDeg(pos)
+	case FusionServoIMU:
+		return fusion.UpdateWithServoAndAccel(cloud, servoData, servo, accel)
+	default:
+		return fusion.UpdateWithServo(cloud, servoData, servo)
+	}
+}
+
+// UpdateWithAccel returns the 3D points of the cloud rotated by the latest accel
+// attitude (of the head) measured before the cloud began.
+func (fusion *Fusion) UpdateWithAccel(cloud *LidarCloud, accel *AccelDataBuffer) (points []Vec3) {
+	if cloud.Size == 0 {
+		return nil
+	}
 
-		// 1. convert (angle, dist) to (X, Y)
-		pt2 := AngleDistToPoint2(&cloud.Data[i])
+	q := latestAttitude(cloud, accel)
+	points = fusion.fuse(cloud, fusion.LidarToHead, attitude(&q))
+	fusion.cloudsCnt++
+	return points
+}
 
-		// 2. rotate lidar cloud depending on the head construction
-		pt2 = RotateVec2(&pt2, fusion.CloudRotation)
+// UpdateWithServo returns the 3D points of the cloud tilted by the servo angle
+// set before the cloud began. The servo position and the angle in degrees are
+// also returned.
+func (fusion *Fusion) UpdateWithServo(cloud *LidarCloud, servoData *ServoDataBuffer, servo *Servo) (points []Vec3, pos uint16, deg float64) {
+	if cloud.Size == 0 {
+		return nil, 0, 0
+	}
 
-		// 3.
-		pt2t := RotateVec2(&Vec2{pt2.X, 0}, DegToRad(float64(deg)))
+	pos = latestServo(cloud, servoData).positon
+	deg = servo.PositionToDeg(pos)
+	points = fusion.fuse(cloud, fusion.LidarToBase(deg), attitude(&Quat{W: 1})) // level base
+	fusion.cloudsCnt++
+	return points, pos, deg
+}
 
-		// 4.
-		pt3 := Vec3{pt2t.X, pt2.Y, pt2t.Y}
-		fmt.Printf("%f\t%f\t%f\n", pt3.X, -pt3.Y, pt3.Z)
+// UpdateWithServoAndAccel returns the 3D points of the cloud tilted by the
+// servo angle set before the cloud began and rotated by the latest accel
+// attitude of the base measured before the cloud began. The servo position and
+// the angle in degrees are also returned.
+func (fusion *Fusion) UpdateWithServoAndAccel(cloud *LidarCloud, servoData *ServoDataBuffer, servo *Servo, accel *AccelDataBuffer) (points []Vec3, pos uint16, deg float64) {
+	if cloud.Size == 0 {
+		return nil, 0, 0
 	}
-	fmt.Printf("angle = %f\n", deg)
+
+	pos = latestServo(cloud, servoData).positon
+	deg = servo.PositionToDeg(pos)
+	q := latestAttitude(cloud, accel)
+	points = fusion.fuse(cloud, fusion.LidarToBase(deg), attitude(&q))
 	fusion.cloudsCnt++
+	return points, pos, deg
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// room is the box the synthetic clouds are measured in, in millimeters.
+var roomMin, roomMax = Vec3{-3000, -2500, -1000}, Vec3{4000, 3000, 2000}
+
+// raycast returns the distance from o along the unit vector u to the room walls.
+func raycast(o, u Vec3) float64 {
+	t := math.Inf(1)
+	for _, face := range [][3]float64{
+		{u.X, o.X, roomMin.X}, {u.X, o.X, roomMax.X},
+		{u.Y, o.Y, roomMin.Y}, {u.Y, o.Y, roomMax.Y},
+		{u.Z, o.Z, roomMin.Z}, {u.Z, o.Z, roomMax.Z},
+	} {
+		if face[0] != 0 {
+			if s := (face[2] - face[1]) / face[0]; s > 0 && s < t {
+				t = s
+			}
+		}
+	}
+	return t
+}
+
+// wallDist returns the distance of p from the nearest room wall.
+func wallDist(p Vec3) float64 {
+	d := math.Inf(1)
+	for _, v := range []float64{
+		p.X - roomMin.X, roomMax.X - p.X,
+		p.Y - roomMin.Y, roomMax.Y - p.Y,
+		p.Z - roomMin.Z, roomMax.Z - p.Z,
+	} {
+		d = math.Min(d, math.Abs(v))
+	}
+	return d
+}
+
+// headPoint returns the measurement in the head frame of a head built like the
+// first prototype: the scan plane is rotated by cloudRotation radians and its Y
+// axis is flipped.
+func headPoint(angle, dist, cloudRotation float64) Vec3 {
+	p := AngleDistToPoint2(&AngleDist{angle, dist})
+	p = RotateVec2(&p, cloudRotation)
+	return Vec3{p.X, -p.Y, 0}
+}
+
+// tiltPoint rotates p by deg degrees about the -Y axis, sync's servo axis.
+func tiltPoint(p Vec3, deg float64) Vec3 {
+	sin, cos := math.Sincos(DegToRad(deg))
+	return Vec3{p.X*cos - p.Z*sin, p.Y, p.X*sin + p.Z*cos}
+}
+
+// axisAngle returns the quaternion of the rotation by deg degrees about the
+// unit vector.
+func axisAngle(axis Vec3, deg float64) Quat {
+	sin, cos := math.Sincos(DegToRad(deg) / 2)
+	return Quat{cos, axis.X * sin, axis.Y * sin, axis.Z * sin}
+}
+
+// fusionCase is a head pose the room is measured from.
+type fusionCase struct {
+	name          string
+	mode          FusionMode
+	cloudRotation float64
+	offset        Vec3   // lidar center in the head frame
+	pos           uint16 // servo position
+	attitude      Quat   // of the head in FusionIMU, of the base in FusionServoIMU
+}
+
+// measure returns the cloud of the room measured from the pose and buffers of
+// the servo and the accel with the pose set before the cloud began and
+// a different one after.
+func measure(c *fusionCase, servo *Servo) (*LidarCloud, *ServoDataBuffer, *AccelDataBuffer) {
+	begin := time.Now()
+	servoData := NewServoDataBuffer(3)
+	servoData.Append(ServoData{positon: 1200, timept: begin.Add(-2 * time.Second)})
+	servoData.Append(ServoData{positon: c.pos, timept: begin.Add(-time.Second)})
+	servoData.Append(ServoData{positon: 2900, timept: begin.Add(time.Second)})
+
+	q := c.attitude
+	accel := NewAccelDataBuffer(3)
+	accel.Append(AccelDataUnion{quat: AccelDataQuat{qw: 1, timept: begin.Add(-2 * time.Second)}})
+	accel.Append(AccelDataUnion{quat: AccelDataQuat{q.W, q.X, q.Y, q.Z, begin.Add(-time.Second)}})
+	accel.Append(AccelDataUnion{quat: AccelDataQuat{qw: 1, timept: begin.Add(time.Second)}})
+
+	deg := servo.PositionToDeg(c.pos)
+	pose := func(p Vec3) Vec3 {
+		switch c.mode {
+		case FusionIMU:
+			return RotateVec3ByQuat(&p, &q)
+		case FusionServoIMU:
+			p = tiltPoint(p, deg)
+			return RotateVec3ByQuat(&p, &q)
+		default:
+			return tiltPoint(p, deg)
+		}
+	}
+
+	lidarCloud := &LidarCloud{TimeBegin: begin, TimeDiff: 100}
+	origin := pose(c.offset)
+	for angle := 0.0; angle < 360; angle += 0.5 {
+		d := pose(headPoint(angle, 1, c.cloudRotation))
+		lidarCloud.Data[lidarCloud.Size] = AngleDist{angle, raycast(origin, d)}
+		lidarCloud.Size++
+	}
+	lidarCloud.Data[lidarCloud.Size] = AngleDist{360, 0} // no return
+	lidarCloud.Size++
+	return lidarCloud, &servoData, &accel
+}
+
+func TestFusionModes(t *testing.T) {
+	level := Quat{W: 1}
+	cases := []fusionCase{
+		{"servo level", FusionServo, 0, Vec3{}, 2500, level},
+		{"servo tilted", FusionServo, PrototypeCloudRotation, Vec3{}, 1700, level},
+		{"servo off the axis", FusionServo, PrototypeCloudRotation, Vec3{60, 0, -30}, 2100, level},
+		{"imu level", FusionIMU, 0, Vec3{}, 2500, level},
+		{"imu tilted", FusionIMU, PrototypeCloudRotation, Vec3{}, 1500, axisAngle(Vec3{0.6, 0, 0.8}, 35)},
+		{"servo+imu level base", FusionServoIMU, PrototypeCloudRotation, Vec3{}, 1800, level},
+		{"servo+imu tilted base", FusionServoIMU, PrototypeCloudRotation, Vec3{}, 1800, axisAngle(Vec3{1, 0, 0}, -10)},
+	}
+
+	for _, c := range cases {
+		servo := &Servo{positonCalib: servoCalibPos, unitToDeg: servoUnitToDeg}
+		mount := calib.SimpleMount(c.cloudRotation, servoUnitToDeg)
+		mount.LidarToHead.T = cloud.Point{X: c.offset.X, Y: c.offset.Y, Z: c.offset.Z}
+		fusion := &Fusion{Mount: mount, Mode: c.mode}
+
+		lidarCloud, servoData, accel := measure(&c, servo)
+		points, pos, deg := fusion.Update(lidarCloud, servoData, servo, accel)
+		if pos != c.pos || deg != servo.PositionToDeg(c.pos) {
+			t.Errorf("%s: fused at position %d and %g deg, want %d and %g deg", c.name, pos, deg, c.pos, servo.PositionToDeg(c.pos))
+		}
+		if len(points) != int(lidarCloud.Size)-1 {
+			t.Fatalf("%s: %d points fused from %d returns", c.name, len(points), lidarCloud.Size-1)
+		}
+		for i, p := range points {
+			if d := wallDist(p); d > 1e-6 {
+				t.Fatalf("%s: point %d %v of angle %g is %g off the walls", c.name, i, p, lidarCloud.Data[i].Angle, d)
+			}
+		}
+	}
+}
+
+// oldServoPoint is the servo fusion of the first versions of sync, which
+// SimpleMount describes.
+func oldServoPoint(m *AngleDist, cloudRotation, deg float64) Vec3 {
+	pt2 := AngleDistToPoint2(m)
+	pt2 = RotateVec2(&pt2, cloudRotation)
+	pt2t := RotateVec2(&Vec2{pt2.X, 0}, DegToRad(deg))
+	return Vec3{pt2t.X, -pt2.Y, pt2t.Y}
+}
+
+func TestSimpleMountMatchesOldServoFusion(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	servo := &Servo{positonCalib: servoCalibPos, unitToDeg: servoUnitToDeg}
+	for _, cloudRotation := range []float64{0, PrototypeCloudRotation, 1} {
+		fusion := &Fusion{Mount: calib.SimpleMount(cloudRotation, servoUnitToDeg), Mode: FusionServo}
+		for pos := uint16(servoMinPos); pos <= servoMaxPos; pos += 250 {
+			begin := time.Now()
+			servoData := NewServoDataBuffer(1)
+			servoData.Append(ServoData{positon: pos, timept: begin.Add(-time.Millisecond)})
+
+			lidarCloud := &LidarCloud{TimeBegin: begin, TimeDiff: 100}
+			for i := 0; i < 100; i++ {
+				lidarCloud.Data[i] = AngleDist{rng.Float64() * 360, 150 + rng.Float64()*12000}
+			}
+			lidarCloud.Size = 100
+
+			points, _, deg := fusion.UpdateWithServo(lidarCloud, &servoData, servo)
+			for i, p := range points {
+				want := oldServoPoint(&lidarCloud.Data[i], cloudRotation, deg)
+				if d := math.Sqrt((p.X-want.X)*(p.X-want.X) + (p.Y-want.Y)*(p.Y-want.Y) + (p.Z-want.Z)*(p.Z-want.Z)); d > 1e-6 {
+					t.Fatalf("rotation %g, position %d: %v fused at %v, the old formula gives %v", cloudRotation, pos, lidarCloud.Data[i], p, want)
+				}
+			}
+		}
+	}
+}
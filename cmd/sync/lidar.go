@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 )
 
@@ -46,6 +47,7 @@ type Lidar struct {
 	nextCloudCount     int
 	nextCloudTimeDiff  int
 	nextCloudTimeBegin time.Time
+	parseErrors        uint64 // number of lines which could not be parsed, accessed atomically
 }
 
 // StartLoop starts the lidar-scan process and runs a loop responsible for reading and
@@ -74,6 +76,7 @@ func (lidar *Lidar) StartLoop(channel chan *LidarCloud) (err error) {
 			line := scanner.Text()
 
 			if err := lidar.ProcessLine(line, &cloud); err != nil {
+				atomic.AddUint64(&lidar.parseErrors, 1)
 				log.Printf("unable to parse line: %s\n", err)
 				// TODO: buffer overflow error handling (but tbh it never happens)
 			}
@@ -89,6 +92,11 @@ func (lidar *Lidar) StartLoop(channel chan *LidarCloud) (err error) {
 	}
 }
 
+// ParseErrors returns the number of lines of lidar-scan output which could not be parsed.
+func (lidar *Lidar) ParseErrors() uint64 {
+	return atomic.LoadUint64(&lidar.parseErrors)
+}
+
 // ProcessLine takes a single line from lidar-scan stdout, processes it, and modifies cloud.
 func (lidar *Lidar) ProcessLine(line string, cloud *LidarCloud) (err error) {
 	if len(line) == 0 {
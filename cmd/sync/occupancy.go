@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/occupancy"
+)
+
+// OccupancyMapper builds a 2D occupancy grid from scans taken while the servo
+// is at the calibration (the most horizontal) position. The map is saved
+// periodically, so tools watching the files see it grow.
+type OccupancyMapper struct {
+	grid      *occupancy.Grid
+	base      string  // path of the map files without the extension
+	tolerance float64 // max servo angle in degrees of a horizontal scan
+	mount     calib.Mount
+	interval  time.Duration
+
+	scanID   int               // ID of the latest raw scan
+	scan     []occupancy.Point // its points
+	pose     occupancy.Pose    // rig pose in the map, the rig is assumed static
+	scans    int               // scans integrated
+	changed  bool              // the map changed since it was saved
+	lastSave time.Time
+}
+
+// NewOccupancyMapper creates a mapper saving to base.pgm and base.yaml.
+func NewOccupancyMapper(base string, resolution, size, tolerance float64, mount calib.Mount, interval time.Duration) *OccupancyMapper {
+	return &OccupancyMapper{
+		grid:      occupancy.NewGrid(resolution, size),
+		base:      base,
+		tolerance: tolerance,
+		mount:     mount,
+		interval:  interval,
+		scanID:    -1,
+	}
+}
+
+// WriteScan converts the raw scan to meters in the frame of fused points at
+// the servo angle 0, it is integrated by WriteFused if it is horizontal.
+func (m *OccupancyMapper) WriteScan(cloud *LidarCloud) error {
+	m.scanID = cloud.ID
+	m.scan = m.scan[:0]
+	r := m.mount.LidarToBase(0).R // as UpdateWithServo, relative to the lidar center
+	for i := 0; i < int(cloud.Size); i++ {
+		if cloud.Data[i].Dist == 0 {
+			continue // no return, nothing is known about the ray
+		}
+		p := r.MulVec(calib.LidarPoint(cloud.Data[i].Angle, cloud.Data[i].Dist))
+		m.scan = append(m.scan, occupancy.Point{X: p.X / 1000, Y: p.Y / 1000})
+	}
+	return nil
+}
+
+// WriteFused integrates the raw scan of the fused cloud if the servo was
+// horizontal.
+func (m *OccupancyMapper) WriteFused(fused *FusedCloud) error {
+	if fused.ID != m.scanID || math.Abs(fused.ServoDeg) > m.tolerance {
+		return nil
+	}
+	m.grid.Integrate(m.pose, m.scan)
+	m.scans++
+	m.changed = true
+
+	if time.Since(m.lastSave) < m.interval {
+		return nil
+	}
+	return m.save()
+}
+
+func (m *OccupancyMapper) WriteAccel(data AccelDataUnion) error { return nil }
+
+func (m *OccupancyMapper) WriteServo(data ServoData, deg float64) error { return nil }
+
+func (m *OccupancyMapper) save() error {
+	m.lastSave = time.Now()
+	m.changed = false
+	return m.grid.Save(m.base)
+}
+
+// Close saves the final map.
+func (m *OccupancyMapper) Close() error {
+	if m.scans == 0 {
+		log.Println("no horizontal scans, the occupancy grid is not saved")
+		return nil
+	}
+	if !m.changed {
+		return nil
+	}
+	log.Printf("saving occupancy grid of %d scans to %s.pgm", m.scans, m.base)
+	return m.save()
+}
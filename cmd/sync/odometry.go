@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+	"github.com/knei-knurow/lidar-tools/odometry"
+)
+
+// odometryQueueSize is the number of clouds waiting for registration. When it
+// is exceeded (registration is slower than the lidar), new clouds are dropped.
+const odometryQueueSize = 4
+
+// odometryScan is a fused cloud waiting for registration.
+type odometryScan struct {
+	time   time.Time
+	points []cloud.Point
+}
+
+// OdometryRecorder estimates the trajectory of the rig from fused clouds. The
+// clouds are registered in its own goroutine, so the sensor loops are not
+// stalled. The poses are written to a TUM trajectory as they are estimated,
+// the map is written on Close.
+type OdometryRecorder struct {
+	odometry *odometry.Odometry
+	file     *os.File
+	writer   *bufio.Writer
+	mapPath  string // disabled if empty
+	queue    chan odometryScan
+	stopped  chan struct{}
+	clouds   int // clouds registered
+	dropped  int // clouds dropped because the queue was full
+}
+
+// NewOdometryRecorder creates the trajectory file.
+func NewOdometryRecorder(path, mapPath string, opts odometry.Options) (*OdometryRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create trajectory: %v", err)
+	}
+	rec := &OdometryRecorder{
+		odometry: odometry.New(opts),
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		mapPath:  mapPath,
+		queue:    make(chan odometryScan, odometryQueueSize),
+		stopped:  make(chan struct{}),
+	}
+	fmt.Fprintln(rec.writer, "# timestamp tx ty tz qx qy qz qw")
+	go rec.loop()
+	return rec, nil
+}
+
+// loop registers the queued clouds until the queue is closed.
+func (rec *OdometryRecorder) loop() {
+	defer close(rec.stopped)
+	for scan := range rec.queue {
+		pose, ok := rec.odometry.Update(scan.time, scan.points)
+		if ok {
+			rec.clouds++
+		}
+		if err := odometry.WriteTUM(rec.writer, pose); err != nil {
+			log.Println("unable to write odometry pose:", err)
+			continue
+		}
+		if err := rec.writer.Flush(); err != nil { // the trajectory can be followed while sync runs
+			log.Println("unable to write odometry pose:", err)
+		}
+	}
+}
+
+func (rec *OdometryRecorder) WriteScan(cloud *LidarCloud) error { return nil }
+
+// WriteFused queues the cloud for registration, it is dropped if the queue is
+// full.
+func (rec *OdometryRecorder) WriteFused(fused *FusedCloud) error {
+	points := make([]cloud.Point, len(fused.Points))
+	for i, p := range fused.Points {
+		points[i] = cloud.Point{X: p.X, Y: p.Y, Z: p.Z}
+	}
+	select {
+	case rec.queue <- odometryScan{fused.Time, points}:
+	default:
+		rec.dropped++
+	}
+	return nil
+}
+
+func (rec *OdometryRecorder) WriteAccel(data AccelDataUnion) error { return nil }
+
+func (rec *OdometryRecorder) WriteServo(data ServoData, deg float64) error { return nil }
+
+// Close registers the queued clouds, closes the trajectory and writes the map.
+func (rec *OdometryRecorder) Close() error {
+	close(rec.queue)
+	<-rec.stopped
+	log.Printf("odometry registered %d clouds, %d were rejected, %d were dropped", rec.clouds, rec.odometry.Rejected, rec.dropped)
+	if err := rec.writer.Flush(); err != nil {
+		rec.file.Close()
+		return err
+	}
+	if err := rec.file.Close(); err != nil {
+		return err
+	}
+	if rec.mapPath == "" {
+		return nil
+	}
+	points := rec.odometry.Map()
+	log.Printf("saving odometry map of %d points to %s", len(points), rec.mapPath)
+	return cloud.WriteFile(rec.mapPath, &cloud.Cloud{Points: points})
+}
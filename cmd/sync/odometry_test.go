@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+	"github.com/knei-knurow/lidar-tools/odometry"
+)
+
+// roomScan returns the room scanned from the position, in the rig frame.
+func roomScan(position Vec3) []Vec3 {
+	var points []Vec3
+	for elevation := -80.0; elevation <= 80; elevation += 4 {
+		for azimuth := 0.0; azimuth < 360; azimuth += 2 {
+			sinE, cosE := math.Sincos(DegToRad(elevation))
+			sinA, cosA := math.Sincos(DegToRad(azimuth))
+			u := Vec3{cosE * cosA, cosE * sinA, sinE}
+			d := raycast(position, u)
+			points = append(points, Vec3{u.X * d, u.Y * d, u.Z * d})
+		}
+	}
+	return points
+}
+
+func TestOdometryRecorder(t *testing.T) {
+	dir := t.TempDir()
+	path, mapPath := filepath.Join(dir, "trajectory.txt"), filepath.Join(dir, "map.ply")
+	opts := odometry.DefaultOptions
+	opts.InitTime = 500 * time.Millisecond
+	rec, err := NewOdometryRecorder(path, mapPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// at rest, then walking along X at 0.5 m/s
+	start := time.Unix(1600000000, 0)
+	const clouds = 30
+	for i := 0; i < clouds; i++ {
+		dt := time.Duration(i) * 100 * time.Millisecond
+		x := 500 * math.Max(0, (dt-opts.InitTime).Seconds())
+		if err := rec.WriteFused(&FusedCloud{ID: i, Time: start.Add(dt), Points: roomScan(Vec3{x, 0, 0})}); err != nil {
+			t.Fatal(err)
+		}
+		for len(rec.queue) > 0 { // don't let the queue overflow
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rec.dropped != 0 || rec.clouds != clouds {
+		t.Errorf("registered %d clouds and dropped %d, want %d registered", rec.clouds, rec.dropped, clouds)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != clouds+1 || !strings.HasPrefix(lines[0], "#") {
+		t.Fatalf("trajectory of %d lines, want a comment and %d poses", len(lines), clouds)
+	}
+	for i, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 8 {
+			t.Fatalf("pose %d: %q", i, line)
+		}
+		var v [8]float64
+		for j, f := range fields {
+			if v[j], err = strconv.ParseFloat(f, 64); err != nil {
+				t.Fatalf("pose %d: %v", i, err)
+			}
+		}
+		dt := time.Duration(i) * 100 * time.Millisecond
+		x := 0.5 * math.Max(0, (dt-opts.InitTime).Seconds())
+		if math.Abs(v[0]-float64(start.Unix())-dt.Seconds()) > 1e-6 {
+			t.Errorf("pose %d at %f, want %v", i, v[0], start.Add(dt))
+		}
+		// meters, the rotation stays the identity
+		if math.Abs(v[1]-x) > 0.05 || math.Abs(v[2]) > 0.05 || math.Abs(v[3]) > 0.05 || v[7] < 0.9999 {
+			t.Errorf("pose %d: %q, want the position %g 0 0 and no rotation", i, line, x)
+		}
+	}
+
+	m, err := cloud.ReadFile(mapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Points) == 0 {
+		t.Error("empty map")
+	}
+}
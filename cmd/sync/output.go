@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"time"
+)
+
+// FusedCloud is a 3D cloud computed from a single LidarCloud.
+type FusedCloud struct {
+	ID       int       // ID of the source LidarCloud
+	Time     time.Time // begin time of the source LidarCloud
+	ServoPos uint16    // servo position used for the fusion
+	ServoDeg float64   // servo angle used for the fusion
+	Points   []Vec3    // points in millimeters
+}
+
+// Output consumes the data flowing through sync, e.g. records it or shows it.
+type Output interface {
+	WriteScan(cloud *LidarCloud) error
+	WriteFused(fused *FusedCloud) error
+	WriteAccel(data AccelDataUnion) error
+	WriteServo(data ServoData, deg float64) error
+	Close() error
+}
+
+// Outputs passes the data to all outputs, errors are logged.
+type Outputs []Output
+
+func (outputs Outputs) WriteScan(cloud *LidarCloud) {
+	for _, out := range outputs {
+		if err := out.WriteScan(cloud); err != nil {
+			log.Println("unable to write scan:", err)
+		}
+	}
+}
+
+func (outputs Outputs) WriteFused(fused *FusedCloud) {
+	for _, out := range outputs {
+		if err := out.WriteFused(fused); err != nil {
+			log.Println("unable to write fused cloud:", err)
+		}
+	}
+}
+
+func (outputs Outputs) WriteAccel(data AccelDataUnion) {
+	for _, out := range outputs {
+		if err := out.WriteAccel(data); err != nil {
+			log.Println("unable to write accel data:", err)
+		}
+	}
+}
+
+func (outputs Outputs) WriteServo(data ServoData, deg float64) {
+	for _, out := range outputs {
+		if err := out.WriteServo(data, deg); err != nil {
+			log.Println("unable to write servo data:", err)
+		}
+	}
+}
+
+func (outputs Outputs) Close() {
+	for _, out := range outputs {
+		if err := out.Close(); err != nil {
+			log.Println("unable to close output:", err)
+		}
+	}
+}
+
+// TextOutput writes fused points, one "X Y Z" line per point, followed by
+// the "angle = deg" line after every cloud.
+type TextOutput struct {
+	writer *bufio.Writer
+}
+
+func (out *TextOutput) WriteScan(cloud *LidarCloud) error { return nil }
+
+func (out *TextOutput) WriteFused(fused *FusedCloud) error {
+	for _, pt := range fused.Points {
+		fmt.Fprintf(out.writer, "%f\t%f\t%f\n", pt.X, pt.Y, pt.Z)
+	}
+	fmt.Fprintf(out.writer, "angle = %f\n", fused.ServoDeg)
+	return out.writer.Flush()
+}
+
+func (out *TextOutput) WriteAccel(data AccelDataUnion) error { return nil }
+
+func (out *TextOutput) WriteServo(data ServoData, deg float64) error { return nil }
+
+func (out *TextOutput) Close() error {
+	return out.writer.Flush()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/knei-knurow/lidar-tools/calib"
+)
+
+// RawRecorder writes raw scans with the servo positions they were fused with
+// to a raw log, the input of cloudtool calibrate.
+type RawRecorder struct {
+	file   *os.File
+	writer *bufio.Writer
+	scanID int           // ID of the latest raw scan
+	scan   calib.RawScan // its measurements
+}
+
+// NewRawRecorder creates the raw log. servoCalib is the servo position the tilt
+// is measured from.
+func NewRawRecorder(path string, servoCalib int) (*RawRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create raw log: %v", err)
+	}
+	rec := &RawRecorder{file: file, writer: bufio.NewWriter(file), scanID: -1}
+	if err := calib.WriteRawHeader(rec.writer, servoCalib); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return rec, nil
+}
+
+// WriteScan keeps the measurements, they are written with the servo position
+// by WriteFused.
+func (rec *RawRecorder) WriteScan(cloud *LidarCloud) error {
+	rec.scanID = cloud.ID
+	rec.scan.Measurements = rec.scan.Measurements[:0]
+	for i := 0; i < int(cloud.Size); i++ {
+		rec.scan.Measurements = append(rec.scan.Measurements, calib.Measurement{Angle: cloud.Data[i].Angle, Dist: cloud.Data[i].Dist})
+	}
+	return nil
+}
+
+func (rec *RawRecorder) WriteFused(fused *FusedCloud) error {
+	if fused.ID != rec.scanID {
+		return nil
+	}
+	rec.scan.ServoPos = int(fused.ServoPos)
+	return calib.WriteRawScan(rec.writer, &rec.scan)
+}
+
+func (rec *RawRecorder) WriteAccel(data AccelDataUnion) error { return nil }
+
+func (rec *RawRecorder) WriteServo(data ServoData, deg float64) error { return nil }
+
+// Close flushes and closes the raw log.
+func (rec *RawRecorder) Close() error {
+	if err := rec.writer.Flush(); err != nil {
+		rec.file.Close()
+		return err
+	}
+	return rec.file.Close()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,8 +16,15 @@ const (
 	servoCalibPos  = 2500
 	servoMaxPos    = 3000
 	servoUnitToDeg = -0.05 // 1 servo position unit = servoUnitToDeg * deg
+
+	// servoCommandTimeout is how long Do waits for the servo loop to take a
+	// command, the loop starts only after the first accel data.
+	servoCommandTimeout = time.Second
 )
 
+// errServoNotRunning is returned by Do if the servo loop doesn't take commands.
+var errServoNotRunning = errors.New("servo loop is not running")
+
 // ServoData is a struct containing information about servo state
 type ServoData struct {
 	positon uint16    // given, not real
@@ -31,25 +39,35 @@ type Servo struct {
 	positonCalib uint16    // calibration position
 	positonStart uint16    // scan start position
 	unitToDeg    float64   // 1 servo position unit = servoUnitToDeg * deg
+	zeroDeg      float64   // tilt angle at the calibration position
 	vector       uint16    //
 	port         io.Writer // port to write controlling frames
 	delayMs      uint      // ms delay between orders
+	holding      bool      // whether the servo is held at a position instead of sweeping
+	commands     chan func(*Servo)
+}
+
+// PositionToDeg converts the servo position to the tilt angle in degrees
+// relative to the calibration (the most horizontal) position, corrected by the
+// calibrated zero.
+func (servo *Servo) PositionToDeg(pos uint16) float64 {
+	return (float64(pos)-float64(servo.positonCalib))*servo.unitToDeg + servo.zeroDeg
 }
 
 // Move sends the move order to the servo and updates its movement vector.
 func (servo *Servo) Move() {
-	servo.data.positon += servo.vector
+	// the vector is negative as int16, the position could wrap around as uint16
+	pos := int(servo.data.positon) + int(int16(servo.vector))
 
 	switch {
-	case servo.data.positon < servo.positonMin:
-		servo.data.positon = servo.positonMin
+	case pos < int(servo.positonMin):
+		pos = int(servo.positonMin)
 		servo.vector = -servo.vector
-		fmt.Printf("angle = min\n")
-	case servo.data.positon > servo.positonMax:
-		servo.data.positon = servo.positonMax
+	case pos > int(servo.positonMax):
+		pos = int(servo.positonMax)
 		servo.vector = -servo.vector
-		fmt.Printf("angle = max\n")
 	}
+	servo.data.positon = uint16(pos)
 }
 
 // SendData is a low-level function to create a data frame and send it via serial port
@@ -81,7 +99,15 @@ func (servo *Servo) StartLoop(channel chan ServoData) {
 	time.Sleep(time.Second * 2) // just wait a while for the lidar
 
 	for {
-		servo.Move()
+		select {
+		case command := <-servo.commands:
+			command(servo)
+		default:
+		}
+
+		if !servo.holding {
+			servo.Move()
+		}
 		if err := servo.SendData(); err != nil {
 			log.Println("unable to send servo data:", err)
 		}
@@ -92,3 +118,38 @@ func (servo *Servo) StartLoop(channel chan ServoData) {
 		}
 	}
 }
+
+// Do runs f in the servo loop goroutine, so f can safely read and modify the servo
+// while it is sweeping. It blocks until f returns. It returns errServoNotRunning
+// if the loop doesn't take f within servoCommandTimeout.
+func (servo *Servo) Do(f func(servo *Servo)) error {
+	done := make(chan struct{})
+	command := func(servo *Servo) {
+		f(servo)
+		close(done)
+	}
+	select {
+	case servo.commands <- command:
+	case <-time.After(servoCommandTimeout):
+		return errServoNotRunning
+	}
+	<-done
+	return nil
+}
+
+// Step returns the absolute value of the movement vector.
+func (servo *Servo) Step() uint16 {
+	if servo.vector > 1<<15 { // the vector is negative
+		return -servo.vector
+	}
+	return servo.vector
+}
+
+// SetStep changes the absolute value of the movement vector, preserving its direction.
+func (servo *Servo) SetStep(step uint16) {
+	if servo.vector > 1<<15 {
+		servo.vector = -step
+	} else {
+		servo.vector = step
+	}
+}
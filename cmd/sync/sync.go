@@ -6,8 +6,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"time"
 
+	"github.com/knei-knurow/lidar-tools/calib"
+	"github.com/knei-knurow/lidar-tools/cloud"
+	"github.com/knei-knurow/lidar-tools/netproto"
+	"github.com/knei-knurow/lidar-tools/odometry"
 	"github.com/tarm/serial"
 )
 
@@ -36,6 +42,43 @@ var (
 
 	// Misc args
 	cloudRotation float64
+	calibPath     string
+	fusionName    string
+	deskewUse     bool
+	deskewVel     string
+
+	// Remote control args
+	controlAddr string
+	psk         string
+	pskFile     string
+
+	// Recording and visualization args
+	bagPath      string
+	rawPath      string
+	foxgloveAddr string
+	webAddr      string
+
+	// Processing args
+	filterSpec string
+
+	// Terminal view args
+	tuiUse    bool
+	tuiWidth  int
+	tuiHeight int
+	tuiRange  float64
+
+	// Occupancy grid args
+	occMap       string
+	occRes       float64
+	occSize      float64
+	occTolerance float64
+	occInterval  time.Duration
+
+	// Odometry args
+	odomPath  string
+	odomMap   string
+	odomVoxel float64
+	odomInit  time.Duration
 )
 
 func init() {
@@ -66,13 +109,104 @@ func init() {
 
 	// Misc args
 	flag.Float64Var(&cloudRotation, "cloudrotation", PrototypeCloudRotation, "each scanned 2D cloud will be rotated by CloudRotation radians, this value depends on the physical lidar location")
+	flag.StringVar(&calibPath, "calib", "", "calibration file written by cloudtool calibrate, replaces cloudrotation and servounit (disabled if empty)")
+	flag.StringVar(&fusionName, "fusion", "servo", "fusion mode: servo (the servo tilts the head), imu (the accel attitude of the head) or servo+imu (the servo tilts the head, the accel gives the attitude of the base), imu modes need acceluse")
+	flag.BoolVar(&deskewUse, "deskew", false, "correct every cloud for the rotation of the rig during the lidar rotation by the gyro rates, needs acceluse")
+	flag.StringVar(&deskewVel, "deskewvel", "0,0,0", "constant velocity x,y,z of the rig in mm/s used by deskew, in the frame of the IMU")
 
-	flag.Parse()
-	log.Println("starting...")
+	// Remote control args
+	flag.StringVar(&controlAddr, "control", "", fmt.Sprintf("address to listen on for remote control requests, e.g. :%d (disabled if empty)", netproto.DefaultControlPort))
+	flag.StringVar(&psk, "psk", "", "hex encoded 32 byte pre-shared key, accept only authenticated control requests")
+	flag.StringVar(&pskFile, "pskfile", "", "file containing hex encoded pre-shared key, accept only authenticated control requests")
+
+	// Recording and visualization args
+	flag.StringVar(&bagPath, "bag", "", "ROS bag file to record scans, fused points, accel and servo data to (disabled if empty)")
+	flag.StringVar(&rawPath, "rawlog", "", "file to record raw scans with servo positions to, the input of cloudtool calibrate (disabled if empty)")
+	flag.StringVar(&foxgloveAddr, "foxglove", "", "address to serve the Foxglove WebSocket protocol on, e.g. :8765 (disabled if empty)")
+	flag.StringVar(&webAddr, "web", "", "address to serve the browser point cloud viewer on, e.g. :8080 (disabled if empty)")
+
+	// Processing args
+	flag.StringVar(&filterSpec, "filter", "", "filters applied to fused points before they are output, "+cloud.FilterHelp)
+
+	// Terminal view args
+	flag.BoolVar(&tuiUse, "tui", false, "draw the latest scan and status on the terminal instead of printing points")
+	flag.IntVar(&tuiWidth, "tuiwidth", envInt("COLUMNS", 80), "terminal width in characters")
+	flag.IntVar(&tuiHeight, "tuiheight", envInt("LINES", 24), "terminal height in lines")
+	flag.Float64Var(&tuiRange, "tuirange", 6, "distance from the lidar to the plot edge in meters")
+
+	// Occupancy grid args
+	flag.StringVar(&occMap, "occmap", "", "path without the extension to save the 2D occupancy grid of horizontal scans to as .pgm and .yaml (disabled if empty)")
+	flag.Float64Var(&occRes, "occres", 0.05, "occupancy grid cell size in meters")
+	flag.Float64Var(&occSize, "occsize", 40, "occupancy grid edge length in meters, centered at the lidar")
+	flag.Float64Var(&occTolerance, "occtolerance", 0.5, "max servo angle from the calibration position in degrees of a scan used for the occupancy grid")
+	flag.DurationVar(&occInterval, "occinterval", 2*time.Second, "interval of saving the occupancy grid")
+
+	// Odometry args
+	flag.StringVar(&odomPath, "odom", "", "file to write the rig trajectory estimated by scan-to-map odometry to, in the TUM format (disabled if empty)")
+	flag.StringVar(&odomMap, "odommap", "", "file to write the odometry map to on exit, .ply, .pcd or .xyz (disabled if empty)")
+	flag.Float64Var(&odomVoxel, "odomvoxel", odometry.DefaultOptions.MapVoxel, "odometry map voxel size in millimeters")
+	flag.DurationVar(&odomInit, "odominit", 0, "time at rest at the start whose clouds form the initial odometry map (0 means one servo sweep)")
+}
+
+// stdoutBufferSize is big enough to hold the whole fused cloud, so it is written
+// at once and not interleaved with other output.
+const stdoutBufferSize = 1 << 20
+
+// envInt returns the integer value of the environment variable or def if it is
+// not set or invalid.
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
 }
 
 func main() {
-	writer := bufio.NewWriter(os.Stdout)
+	flag.Parse() // not in init, so tests can parse their own flags
+	log.Println("starting...")
+
+	writer := bufio.NewWriterSize(os.Stdout, stdoutBufferSize)
+
+	filters, err := cloud.ParseFilters(filterSpec)
+	if err != nil {
+		log.Println("invalid filters:", err)
+		return
+	}
+	if len(filters) != 0 {
+		log.Println("filtering fused points:", filters)
+	}
+
+	mount := calib.SimpleMount(cloudRotation, servoUnit)
+	if calibPath != "" {
+		if mount, err = calib.Load(calibPath); err != nil {
+			log.Println("cannot load calibration:", err)
+			return
+		}
+		log.Println("using calibration", calibPath)
+	}
+	fusionMode, err := ParseFusionMode(fusionName)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if fusionMode.UsesAccel() && !accelUse {
+		log.Printf("fusion mode %s needs --acceluse", fusionMode)
+		return
+	}
+	var deskew *Deskew
+	if deskewUse {
+		if !accelUse {
+			log.Println("deskew needs --acceluse")
+			return
+		}
+		velocity, err := parseVec3(deskewVel)
+		if err != nil {
+			log.Println("invalid deskew velocity:", err)
+			return
+		}
+		deskew = &Deskew{Velocity: velocity}
+	}
 
 	log.Println("opening AVR port")
 	config := &serial.Config{
@@ -95,6 +229,7 @@ func main() {
 		deltaTime:   DeltaTimeDefault,
 		port:        port,
 		mode:        AccelModeRaw,
+		calibrate:   make(chan chan error),
 	}
 	servo := Servo{
 		data:         ServoData{positon: uint16(servoCalib)},
@@ -103,9 +238,11 @@ func main() {
 		positonCalib: uint16(servoCalib),
 		positonStart: uint16(servoStart),
 		vector:       uint16(servoStep),
-		unitToDeg:    servoUnit,
+		unitToDeg:    mount.ServoUnit,
+		zeroDeg:      mount.ServoZero,
 		port:         port,
 		delayMs:      servoDelay,
+		commands:     make(chan func(*Servo)),
 	}
 	log.Println("servo is setting to the calibration position")
 	servo.SetPosition(servoCalibPos)
@@ -123,6 +260,77 @@ func main() {
 		},
 	}
 
+	if controlAddr != "" {
+		key, err := netproto.ReadKey(psk, pskFile)
+		if err != nil {
+			log.Println("invalid pre-shared key:", err)
+			return
+		}
+		if err := StartControl(controlAddr, key, &servo, &accel); err != nil {
+			log.Println("cannot start control server:", err)
+			return
+		}
+	}
+
+	var outputs Outputs
+	if tuiUse {
+		tui := StartTUI(os.Stdout, tuiWidth, tuiHeight, tuiRange, &lidar)
+		log.SetOutput(tui)
+		outputs = append(outputs, tui)
+	} else {
+		outputs = append(outputs, &TextOutput{writer})
+	}
+	if bagPath != "" {
+		bag, err := NewBagRecorder(bagPath, accel.accelScale)
+		if err != nil {
+			log.Println("cannot create bag:", err)
+			return
+		}
+		log.Println("recording to", bagPath)
+		outputs = append(outputs, bag)
+	}
+	if rawPath != "" {
+		raw, err := NewRawRecorder(rawPath, int(servoCalib))
+		if err != nil {
+			log.Println("cannot create raw log:", err)
+			return
+		}
+		log.Println("recording raw scans to", rawPath)
+		outputs = append(outputs, raw)
+	}
+	if foxgloveAddr != "" {
+		outputs = append(outputs, StartFoxglove(foxgloveAddr))
+	}
+	if webAddr != "" {
+		outputs = append(outputs, StartWebViewer(webAddr))
+	}
+	if occMap != "" {
+		log.Printf("mapping horizontal scans to %s.pgm", occMap)
+		outputs = append(outputs, NewOccupancyMapper(occMap, occRes, occSize, occTolerance, mount, occInterval))
+	}
+	if odomPath != "" {
+		opts := odometry.DefaultOptions
+		opts.MapVoxel = odomVoxel
+		opts.InitTime = odomInit
+		if opts.InitTime == 0 && servoStep > 0 {
+			opts.InitTime = time.Duration((servoMax-servoMin)/servoStep*servoDelay) * time.Millisecond
+		}
+		odom, err := NewOdometryRecorder(odomPath, odomMap, opts)
+		if err != nil {
+			log.Println("cannot start odometry:", err)
+			return
+		}
+		if fusionMode != FusionServoIMU {
+			log.Println("odometry without --fusion servo+imu tracks rotations by registration only")
+		}
+		log.Printf("writing odometry trajectory to %s, keep the rig still for %v", odomPath, opts.InitTime)
+		outputs = append(outputs, odom)
+	}
+
+	// stop on ctrl+c, so the recordings are properly closed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
 	// Create communication channels
 	lidarChan := make(chan *LidarCloud) // LidarCloud is >64kB so it cannot be directly passed by a channel
 	servoChan := make(chan ServoData)
@@ -140,7 +348,12 @@ func main() {
 
 	// Fusion
 	fusion := Fusion{
-		CloudRotation: cloudRotation,
+		Mount: mount,
+		Mode:  fusionMode,
+	}
+	if deskew != nil {
+		deskew.Accel = &accelBuffer
+		fusion.Deskew = deskew
 	}
 
 	// Main loop
@@ -148,10 +361,29 @@ func main() {
 		select {
 		case lidarData := <-lidarChan:
 			lidarBuffer = lidarData
-			// fusion.Update(lidarBuffer, &accelBuffer)
-			fusion.UpdateWithServo(lidarBuffer, &servoBuffer, &servo)
+			points, pos, deg := fusion.Update(lidarBuffer, &servoBuffer, &servo, &accelBuffer)
+			if len(filters) != 0 {
+				points = FilterPoints(filters, points)
+			}
+
+			outputs.WriteScan(lidarBuffer)
+			if lidarBuffer.Size != 0 {
+				outputs.WriteFused(&FusedCloud{
+					ID:       lidarBuffer.ID,
+					Time:     lidarBuffer.TimeBegin,
+					ServoPos: pos,
+					ServoDeg: deg,
+					Points:   points,
+				})
+			}
 		case servoData := <-servoChan:
 			servoBuffer.Append(servoData)
+			outputs.WriteServo(servoData, servo.PositionToDeg(servoData.positon))
+		case <-interrupt:
+			log.Println("interrupted, stopping")
+			log.SetOutput(os.Stderr) // the terminal view is closed
+			outputs.Close()
+			return
 		case accelData := <-accelChan:
 			// when the accel is ready and its first measurement is read, start the servo and lidar
 			if !servoStarted {
@@ -171,7 +403,9 @@ func main() {
 				lidarStarted = true
 			}
 			accelBuffer.Append(accelData)
+			if accel.use {
+				outputs.WriteAccel(accelData)
+			}
 		}
-		writer.Flush()
 	}
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TUI parameters.
+const (
+	tuiRefresh   = 100 * time.Millisecond
+	tuiRateScans = 10 // number of latest scans the cloud rate is computed from
+	tuiLogLines  = 3  // number of latest log messages shown under the status bar
+	tuiStatus    = 2 + tuiLogLines
+)
+
+// Braille dot bits by their (x, y) position within a character (2x4 dots).
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// TUI draws the latest 2D scan from above as a braille plot with a status bar
+// on a terminal. It replaces the text output on stdout. Log messages are
+// captured and shown under the status bar, so they do not break the plot.
+type TUI struct {
+	out    *bufio.Writer
+	width  int     // characters
+	height int     // lines, including the status bar
+	scale  float64 // millimeters at the plot edge
+	lidar  *Lidar
+
+	mu          sync.Mutex
+	points      []Vec2 // the latest scan in millimeters
+	cloudID     int
+	scanTimes   []time.Time
+	servoPos    uint16
+	servoDeg    float64
+	servoKnown  bool
+	attitude    Vec3 // roll, pitch, yaw in degrees
+	accelKnown  bool
+	logLines    []string
+	logLinePart string
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// StartTUI starts redrawing the terminal of the given size (in characters).
+// rangeMeters is the distance from the lidar to the plot edge.
+func StartTUI(out io.Writer, width, height int, rangeMeters float64, lidar *Lidar) *TUI {
+	tui := &TUI{
+		out:     bufio.NewWriter(out),
+		width:   width,
+		height:  height,
+		scale:   rangeMeters * 1000,
+		lidar:   lidar,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	fmt.Fprint(tui.out, "\x1b[?25l\x1b[2J") // hide the cursor, clear the screen
+	go tui.loop()
+	return tui
+}
+
+func (tui *TUI) loop() {
+	defer close(tui.stopped)
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tui.draw()
+		case <-tui.done:
+			return
+		}
+	}
+}
+
+// WriteScan keeps the scan for the next redraw.
+func (tui *TUI) WriteScan(cloud *LidarCloud) error {
+	points := make([]Vec2, 0, cloud.Size)
+	for i := 0; i < int(cloud.Size); i++ {
+		if cloud.Data[i].Dist != 0 {
+			points = append(points, AngleDistToPoint2(&cloud.Data[i]))
+		}
+	}
+
+	tui.mu.Lock()
+	defer tui.mu.Unlock()
+	tui.points = points
+	tui.cloudID = cloud.ID
+	tui.scanTimes = append(tui.scanTimes, time.Now())
+	if len(tui.scanTimes) > tuiRateScans {
+		tui.scanTimes = tui.scanTimes[1:]
+	}
+	return nil
+}
+
+func (tui *TUI) WriteFused(fused *FusedCloud) error { return nil }
+
+// WriteAccel keeps the attitude (Euler angles) for the next redraw.
+func (tui *TUI) WriteAccel(data AccelDataUnion) error {
+	q := data.quat
+	roll := math.Atan2(2*(q.qw*q.qx+q.qy*q.qz), 1-2*(q.qx*q.qx+q.qy*q.qy))
+	pitch := math.Asin(math.Max(-1, math.Min(1, 2*(q.qw*q.qy-q.qz*q.qx))))
+	yaw := math.Atan2(2*(q.qw*q.qz+q.qx*q.qy), 1-2*(q.qy*q.qy+q.qz*q.qz))
+
+	tui.mu.Lock()
+	defer tui.mu.Unlock()
+	tui.attitude = Vec3{RadToDeg(roll), RadToDeg(pitch), RadToDeg(yaw)}
+	tui.accelKnown = true
+	return nil
+}
+
+// WriteServo keeps the servo position for the next redraw.
+func (tui *TUI) WriteServo(data ServoData, deg float64) error {
+	tui.mu.Lock()
+	defer tui.mu.Unlock()
+	tui.servoPos = data.positon
+	tui.servoDeg = deg
+	tui.servoKnown = true
+	return nil
+}
+
+// Write captures log messages, it is used as the log output.
+func (tui *TUI) Write(p []byte) (int, error) {
+	tui.mu.Lock()
+	defer tui.mu.Unlock()
+
+	lines := strings.Split(tui.logLinePart+string(p), "\n")
+	tui.logLinePart = lines[len(lines)-1]
+	tui.logLines = append(tui.logLines, lines[:len(lines)-1]...)
+	if len(tui.logLines) > tuiLogLines {
+		tui.logLines = tui.logLines[len(tui.logLines)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+// Close stops redrawing and restores the cursor.
+func (tui *TUI) Close() error {
+	close(tui.done)
+	<-tui.stopped
+	fmt.Fprint(tui.out, "\x1b[?25h\n")
+	return tui.out.Flush()
+}
+
+// draw redraws the whole screen.
+func (tui *TUI) draw() {
+	tui.mu.Lock()
+	defer tui.mu.Unlock()
+
+	rows := tui.height - tuiStatus
+	if rows < 1 {
+		rows = 1
+	}
+	cells := make([][]rune, rows)
+	for i := range cells {
+		cells[i] = make([]rune, tui.width)
+		for j := range cells[i] {
+			cells[i][j] = 0x2800 // empty braille character
+		}
+	}
+
+	// dots are roughly square, as a character is about twice as high as wide
+	dotsW, dotsH := 2*tui.width, 4*rows
+	mmPerDot := 2 * tui.scale / math.Min(float64(dotsW), float64(dotsH))
+	plot := func(x, y float64) {
+		dx := int(math.Floor(float64(dotsW)/2 + x/mmPerDot))
+		dy := int(math.Floor(float64(dotsH)/2 - y/mmPerDot))
+		if dx < 0 || dy < 0 || dx >= dotsW || dy >= dotsH {
+			return
+		}
+		cells[dy/4][dx/2] |= brailleDots[dy%4][dx%2]
+	}
+
+	for _, p := range tui.points {
+		plot(p.X, p.Y)
+	}
+	cells[rows/2][tui.width/2] = '+' // the lidar
+
+	fmt.Fprint(tui.out, "\x1b[H")
+	for _, line := range cells {
+		fmt.Fprintf(tui.out, "%s\x1b[K\n", string(line))
+	}
+
+	rate := 0.0
+	if n := len(tui.scanTimes); n > 1 {
+		rate = float64(n-1) / tui.scanTimes[n-1].Sub(tui.scanTimes[0]).Seconds()
+	}
+	fmt.Fprintf(tui.out, "\x1b[7m cloud %d | %.1f Hz | %d points | %d parse errors | edge %.1f m \x1b[0m\x1b[K\n",
+		tui.cloudID, rate, len(tui.points), tui.lidar.ParseErrors(), tui.scale/1000)
+
+	servo := "servo -"
+	if tui.servoKnown {
+		servo = fmt.Sprintf("servo %d (%.1f deg)", tui.servoPos, tui.servoDeg)
+	}
+	imu := "imu -"
+	if tui.accelKnown {
+		imu = fmt.Sprintf("imu roll %.1f pitch %.1f yaw %.1f deg", tui.attitude.X, tui.attitude.Y, tui.attitude.Z)
+	}
+	fmt.Fprintf(tui.out, "\x1b[7m %s | %s \x1b[0m\x1b[K\n", servo, imu)
+
+	for i := 0; i < tuiLogLines; i++ {
+		line := ""
+		if i < len(tui.logLines) {
+			line = tui.logLines[i]
+		}
+		if len(line) > tui.width {
+			line = line[:tui.width]
+		}
+		fmt.Fprintf(tui.out, "%s\x1b[K", line)
+		if i != tuiLogLines-1 {
+			fmt.Fprint(tui.out, "\n")
+		}
+	}
+	fmt.Fprint(tui.out, "\x1b[J")
+	tui.out.Flush()
+}
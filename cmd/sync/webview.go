@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/knei-knurow/lidar-tools/webview"
+)
+
+// WebViewer serves the browser point cloud viewer and streams fused points to it.
+type WebViewer struct {
+	http   *http.Server
+	server *webview.Server
+}
+
+// StartWebViewer starts the viewer HTTP server on addr.
+func StartWebViewer(addr string) *WebViewer {
+	server := webview.NewServer()
+	viewer := &WebViewer{
+		http:   &http.Server{Addr: addr, Handler: server},
+		server: server,
+	}
+
+	go func() {
+		log.Println("web viewer is listening on", addr)
+		if err := viewer.http.ListenAndServe(); err != http.ErrServerClosed {
+			log.Println("web viewer stopped:", err)
+		}
+	}()
+	return viewer
+}
+
+func (viewer *WebViewer) WriteScan(cloud *LidarCloud) error { return nil }
+
+// WriteFused streams fused points to connected viewers.
+func (viewer *WebViewer) WriteFused(fused *FusedCloud) error {
+	if !viewer.server.HasClients() {
+		return nil
+	}
+
+	return viewer.server.Publish(webview.Cloud{
+		ID:     fused.ID,
+		Time:   fused.Time,
+		Points: PointsToMeters(fused.Points),
+	})
+}
+
+func (viewer *WebViewer) WriteAccel(data AccelDataUnion) error { return nil }
+
+func (viewer *WebViewer) WriteServo(data ServoData, deg float64) error { return nil }
+
+// Close stops the server.
+func (viewer *WebViewer) Close() error {
+	return viewer.http.Close()
+}
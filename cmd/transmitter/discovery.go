@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
+)
+
+// destination is the address the stream is sent to. It may be changed by
+// subscription requests at any time.
+type destination struct {
+	mu   sync.Mutex
+	addr net.Addr
+}
+
+// Get returns the current destination or nil if there is none yet.
+func (d *destination) Get() net.Addr {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addr
+}
+
+// Set changes the destination and reports whether it differs from the previous one.
+func (d *destination) Set(addr net.Addr) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	changed := d.addr == nil || d.addr.String() != addr.String()
+	d.addr = addr
+	return changed
+}
+
+// serveSubscriptions reads subscription requests from conn and redirects the
+// stream to the latest subscriber. If key is not nil, requests must be sealed
+// with it, otherwise anyone could take over the stream.
+func serveSubscriptions(conn net.PacketConn, dest *destination, key []byte) {
+	var opener *netproto.ClientOpener
+	if key != nil {
+		var err error
+		if opener, err = netproto.NewClientOpener(key, netproto.ChannelSubscription); err != nil {
+			log.Println("failed to create subscription opener:", err)
+			return
+		}
+	}
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Println("failed to read subscription:", err)
+			return
+		}
+
+		data := buf[:n]
+		var seq uint64
+		if opener == nil && netproto.IsSealed(data) {
+			log.Printf("rejected sealed subscription from %s: the transmitter has no key (--psk)\n", addr)
+			continue
+		}
+		if opener != nil {
+			if data, seq, err = opener.Open(data); err != nil {
+				log.Printf("rejected subscription from %s: %v\n", addr, err)
+				continue
+			}
+		}
+
+		sub, err := netproto.ParseSubscription(data)
+		if err != nil {
+			log.Printf("invalid subscription from %s: %v\n", addr, err)
+			continue
+		}
+		if opener != nil {
+			if err := opener.Accept(sub.Client, seq); err != nil {
+				log.Printf("rejected subscription from %s: %v\n", addr, err)
+				continue
+			}
+		}
+
+		if dest.Set(addr) {
+			log.Printf("streaming to %s (%s)\n", addr, sub.Receiver)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, netproto.KeySize)
+
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startRig starts announcing the rig to the returned connection and serving
+// subscriptions.
+func startRig(t *testing.T, key []byte) (announcements net.PacketConn, dest *destination) {
+	rig := listen(t)
+	announcements = listen(t)
+	a := netproto.Announcement{
+		Name:         "test",
+		Capabilities: []string{netproto.CapabilityStream},
+		Format:       "lidar-scan",
+		Port:         rig.LocalAddr().(*net.UDPAddr).Port,
+	}
+	if key != nil {
+		a.Capabilities = append(a.Capabilities, netproto.CapabilityEncrypted)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go netproto.Announce(rig, announcements.LocalAddr(), &a, 20*time.Millisecond, done, nil)
+
+	dest = &destination{}
+	go serveSubscriptions(rig, dest, key)
+	return announcements, dest
+}
+
+// discover returns the only rig announced to conn.
+func discover(t *testing.T, conn net.PacketConn) netproto.Announcement {
+	t.Helper()
+	rigs, err := netproto.Discover(conn, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rigs) != 1 {
+		t.Fatalf("discovered %d rigs, want 1", len(rigs))
+	}
+	return rigs[0]
+}
+
+// subscription returns the subscription request of a receiver, sealed if key
+// is not nil.
+func subscription(t *testing.T, key []byte) []byte {
+	t.Helper()
+	packet, err := netproto.MarshalSubscription(&netproto.Subscription{Receiver: "test", Client: netproto.NewClientID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil {
+		return packet
+	}
+	sealer, err := netproto.NewChannelSealer(key, netproto.ChannelSubscription)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer.Seal(packet)
+}
+
+// waitDest returns the destination once it is set, or nil after the timeout.
+func waitDest(dest *destination, timeout time.Duration) net.Addr {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if addr := dest.Get(); addr != nil {
+			return addr
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return dest.Get()
+}
+
+func TestDiscoverAndSubscribe(t *testing.T) {
+	cases := []struct {
+		name             string
+		rigKey, rcvKey   []byte
+		wantSubscription bool
+	}{
+		{"unsealed", nil, nil, true},
+		{"sealed", testKey, testKey, true},
+		{"unsealed request to a rig with a key", testKey, nil, false},
+		{"sealed request to a rig without a key", nil, testKey, false},
+		{"request sealed with another key", testKey, bytes.Repeat([]byte{0x24}, netproto.KeySize), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			announcements, dest := startRig(t, c.rigKey)
+			rig := discover(t, announcements)
+			if rig.Name != "test" || rig.Format != "lidar-scan" || rig.Has(netproto.CapabilityEncrypted) != (c.rigKey != nil) {
+				t.Errorf("discovered %+v", rig)
+			}
+
+			receiver := listen(t)
+			if _, err := receiver.WriteTo(subscription(t, c.rcvKey), rig.StreamAddr()); err != nil {
+				t.Fatal(err)
+			}
+			timeout := time.Second
+			if !c.wantSubscription {
+				timeout = 100 * time.Millisecond
+			}
+			addr := waitDest(dest, timeout)
+			switch {
+			case c.wantSubscription && (addr == nil || addr.String() != receiver.LocalAddr().String()):
+				t.Errorf("streaming to %v, want %v", addr, receiver.LocalAddr())
+			case !c.wantSubscription && addr != nil:
+				t.Errorf("streaming to %v, want no subscription", addr)
+			}
+		})
+	}
+}
+
+func TestSealedSubscriptionReplay(t *testing.T) {
+	announcements, dest := startRig(t, testKey)
+	rig := discover(t, announcements)
+
+	receiver, attacker := listen(t), listen(t)
+	packet := subscription(t, testKey)
+	if _, err := receiver.WriteTo(packet, rig.StreamAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if addr := waitDest(dest, time.Second); addr == nil || addr.String() != receiver.LocalAddr().String() {
+		t.Fatalf("streaming to %v, want %v", addr, receiver.LocalAddr())
+	}
+
+	// the captured request replayed from another socket doesn't take the stream over
+	if _, err := attacker.WriteTo(packet, rig.StreamAddr()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if addr := dest.Get(); addr.String() != receiver.LocalAddr().String() {
+		t.Errorf("replayed subscription redirected the stream to %v", addr)
+	}
+}
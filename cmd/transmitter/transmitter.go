@@ -11,30 +11,96 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/knei-knurow/lidar-tools/netproto"
 )
 
 var (
-	dest string
-	port string
+	dest    string
+	port    string
+	psk     string
+	pskFile string
+
+	// discovery
+	announce         bool
+	rigName          string
+	announceAddr     string
+	announceInterval time.Duration
+	format           string
 )
 
 func init() {
 	log.SetFlags(0)
 	log.SetPrefix("transmitter: ")
 
+	hostname, _ := os.Hostname()
+
 	flag.StringVar(&dest, "dest", "192.168.1.1", "address to send packets to")
 	flag.StringVar(&port, "port", "8080", "port on dest to route packets to")
+	flag.StringVar(&psk, "psk", "", "hex encoded 32 byte pre-shared key, enables encryption")
+	flag.StringVar(&pskFile, "pskfile", "", "file containing hex encoded pre-shared key, enables encryption")
+
+	flag.BoolVar(&announce, "announce", false, "broadcast announcements and stream to receivers which subscribe")
+	flag.StringVar(&rigName, "name", hostname, "rig name sent in announcements")
+	flag.StringVar(&announceAddr, "announceaddr", fmt.Sprintf("255.255.255.255:%d", netproto.DefaultAnnouncePort), "address to send announcements to")
+	flag.DurationVar(&announceInterval, "announceinterval", netproto.DefaultAnnounceInterval, "time between two announcements")
+	flag.StringVar(&format, "format", "lidar-scan", "format of the data read from stdin, sent in announcements")
 }
 
 func main() {
 	flag.Parse()
 
-	conn, err := net.Dial("udp", dest+":"+port)
+	key, err := netproto.ReadKey(psk, pskFile)
 	if err != nil {
-		log.Fatalln("failed to dial:", err)
+		log.Fatalln("invalid pre-shared key:", err)
+	}
+	var sealer *netproto.Sealer
+	if key != nil {
+		if sealer, err = netproto.NewSealer(key); err != nil {
+			log.Fatalln("failed to create sealer:", err)
+		}
+		log.Println("packets will be encrypted")
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		log.Fatalln("failed to open socket:", err)
 	}
 	defer conn.Close()
 
+	// in the announce mode, the stream goes to --dest only if it is given explicitly
+	var target destination
+	if !announce || isFlagSet("dest") {
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(dest, port))
+		if err != nil {
+			log.Fatalln("failed to resolve dest:", err)
+		}
+		target.Set(addr)
+	}
+
+	if announce {
+		addr, err := net.ResolveUDPAddr("udp", announceAddr)
+		if err != nil {
+			log.Fatalln("failed to resolve announce address:", err)
+		}
+
+		a := netproto.Announcement{
+			Name:         rigName,
+			Capabilities: []string{netproto.CapabilityStream},
+			Format:       format,
+			Port:         conn.LocalAddr().(*net.UDPAddr).Port,
+		}
+		if sealer != nil {
+			a.Capabilities = append(a.Capabilities, netproto.CapabilityEncrypted)
+		}
+
+		log.Printf("announcing %q to %s\n", rigName, addr)
+		go netproto.Announce(conn, addr, &a, announceInterval, nil, func(err error) {
+			log.Println("failed to announce:", err)
+		})
+		go serveSubscriptions(conn, &target, key)
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
 	// chunk represents a single cloud scanned from lidar
@@ -63,7 +129,12 @@ func main() {
 			}
 			time.Sleep(time.Duration(elapsed) * time.Millisecond)
 
-			go send(conn, chunk, cloudIndex, elapsed)
+			if addr := target.Get(); addr != nil {
+				if sealer != nil {
+					chunk = sealer.Seal(chunk)
+				}
+				go send(conn, addr, chunk, cloudIndex, elapsed)
+			}
 			chunk = make([]byte, 0, 65536)
 			continue
 		}
@@ -73,11 +144,21 @@ func main() {
 }
 
 // Send sends single data to host.
-func send(conn net.Conn, data []byte, cloudIndex int, elapsed int) {
-	n, err := conn.Write(data)
+func send(conn net.PacketConn, addr net.Addr, data []byte, cloudIndex int, elapsed int) {
+	n, err := conn.WriteTo(data, addr)
 	if err != nil {
 		log.Fatalln("failed to write to connection:", err)
 	}
 
 	fmt.Printf("sent chunk of size %d KB (cloud %d, t %d)\n", n/1024, cloudIndex, elapsed)
 }
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
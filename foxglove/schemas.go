@@ -0,0 +1,117 @@
+package foxglove
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Message types understood by Foxglove Studio panels and their JSON schemas.
+const (
+	SchemaNamePointCloud  = "foxglove.PointCloud"
+	SchemaNamePoseInFrame = "foxglove.PoseInFrame"
+
+	timeSchema       = `{"type":"object","properties":{"sec":{"type":"integer"},"nsec":{"type":"integer"}}}`
+	vector3Schema    = `{"type":"object","properties":{"x":{"type":"number"},"y":{"type":"number"},"z":{"type":"number"}}}`
+	quaternionSchema = `{"type":"object","properties":{"x":{"type":"number"},"y":{"type":"number"},"z":{"type":"number"},"w":{"type":"number"}}}`
+	poseSchema       = `{"type":"object","properties":{"position":` + vector3Schema + `,"orientation":` + quaternionSchema + `}}`
+
+	SchemaPointCloud = `{"type":"object","properties":{` +
+		`"timestamp":` + timeSchema + `,` +
+		`"frame_id":{"type":"string"},` +
+		`"pose":` + poseSchema + `,` +
+		`"point_stride":{"type":"integer"},` +
+		`"fields":{"type":"array","items":{"type":"object","properties":{"name":{"type":"string"},"offset":{"type":"integer"},"type":{"type":"integer"}}}},` +
+		`"data":{"type":"string","contentEncoding":"base64"}}}`
+
+	SchemaPoseInFrame = `{"type":"object","properties":{` +
+		`"timestamp":` + timeSchema + `,` +
+		`"frame_id":{"type":"string"},` +
+		`"pose":` + poseSchema + `}}`
+)
+
+// numericTypeFloat32 is the foxglove.NumericType of float32 point fields.
+const numericTypeFloat32 = 7
+
+// Time is the timestamp used in Foxglove messages.
+type Time struct {
+	Sec  uint32 `json:"sec"`
+	Nsec uint32 `json:"nsec"`
+}
+
+// NewTime converts t to Time.
+func NewTime(t time.Time) Time {
+	return Time{uint32(t.Unix()), uint32(t.Nanosecond())}
+}
+
+// Vector3 is foxglove.Vector3.
+type Vector3 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Quaternion is foxglove.Quaternion.
+type Quaternion struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	W float64 `json:"w"`
+}
+
+// Pose is foxglove.Pose.
+type Pose struct {
+	Position    Vector3    `json:"position"`
+	Orientation Quaternion `json:"orientation"`
+}
+
+// IdentityPose is the pose without translation and rotation.
+var IdentityPose = Pose{Orientation: Quaternion{W: 1}}
+
+// PackedElementField is foxglove.PackedElementField.
+type PackedElementField struct {
+	Name   string `json:"name"`
+	Offset uint32 `json:"offset"`
+	Type   int    `json:"type"`
+}
+
+// PointCloud is foxglove.PointCloud.
+type PointCloud struct {
+	Timestamp   Time                 `json:"timestamp"`
+	FrameID     string               `json:"frame_id"`
+	Pose        Pose                 `json:"pose"`
+	PointStride uint32               `json:"point_stride"`
+	Fields      []PackedElementField `json:"fields"`
+	Data        string               `json:"data"` // base64
+}
+
+// NewXYZCloud creates a point cloud with float32 x, y, z fields.
+func NewXYZCloud(t time.Time, frame string, points [][3]float32) *PointCloud {
+	data := make([]byte, 12*len(points))
+	for i, p := range points {
+		for j, v := range p {
+			binary.LittleEndian.PutUint32(data[12*i+4*j:], math.Float32bits(v))
+		}
+	}
+
+	return &PointCloud{
+		Timestamp:   NewTime(t),
+		FrameID:     frame,
+		Pose:        IdentityPose,
+		PointStride: 12,
+		Fields: []PackedElementField{
+			{Name: "x", Offset: 0, Type: numericTypeFloat32},
+			{Name: "y", Offset: 4, Type: numericTypeFloat32},
+			{Name: "z", Offset: 8, Type: numericTypeFloat32},
+		},
+		Data: base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// PoseInFrame is foxglove.PoseInFrame.
+type PoseInFrame struct {
+	Timestamp Time   `json:"timestamp"`
+	FrameID   string `json:"frame_id"`
+	Pose      Pose   `json:"pose"`
+}
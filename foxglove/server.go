@@ -0,0 +1,236 @@
+// Package foxglove implements a server of the Foxglove WebSocket protocol (v1),
+// so live data can be watched in Foxglove Studio.
+//
+// Protocol specification: https://github.com/foxglove/ws-protocol
+package foxglove
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Subprotocol is the WebSocket subprotocol of the Foxglove protocol.
+const Subprotocol = "foxglove.websocket.v1"
+
+// opMessageData is the opcode of the binary message carrying channel data.
+const opMessageData = 0x01
+
+// clientQueueSize is the number of messages waiting to be sent to a client.
+// When it is exceeded (slow network), new messages for the client are dropped.
+const clientQueueSize = 64
+
+// Channel is a topic advertised to clients. Messages are JSON encoded.
+type Channel struct {
+	ID         uint32 `json:"id"`
+	Topic      string `json:"topic"`
+	Encoding   string `json:"encoding"`
+	SchemaName string `json:"schemaName"`
+	Schema     string `json:"schema"`
+}
+
+// Server sends messages published on its channels to subscribed clients.
+// It is safe for concurrent use.
+type Server struct {
+	name string
+
+	mu       sync.Mutex
+	channels []*Channel
+	clients  map[*client]bool
+}
+
+type client struct {
+	ws   *wsConn
+	subs map[uint32]uint32 // subscription ID by channel ID, guarded by Server.mu
+	send chan []byte
+}
+
+// NewServer creates a server presented to clients under the name.
+func NewServer(name string) *Server {
+	return &Server{
+		name:    name,
+		clients: make(map[*client]bool),
+	}
+}
+
+// AddChannel adds a JSON encoded channel. The schema is a JSON Schema of the
+// messages. Channels should be added before clients connect.
+func (server *Server) AddChannel(topic string, schemaName string, schema string) *Channel {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	ch := &Channel{
+		ID:         uint32(len(server.channels) + 1),
+		Topic:      topic,
+		Encoding:   "json",
+		SchemaName: schemaName,
+		Schema:     schema,
+	}
+	server.channels = append(server.channels, ch)
+	return ch
+}
+
+// HasSubscribers reports whether any client subscribed to the channel, so costly
+// messages do not have to be created in vain.
+func (server *Server) HasSubscribers(ch *Channel) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for c := range server.clients {
+		if _, ok := c.subs[ch.ID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends the message (encoded to JSON) to all clients subscribed to the channel.
+func (server *Server) Publish(ch *Channel, t time.Time, msg interface{}) error {
+	if !server.HasSubscribers(ch) {
+		return nil
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for c := range server.clients {
+		subID, ok := c.subs[ch.ID]
+		if !ok {
+			continue
+		}
+
+		data := make([]byte, 1+4+8, 1+4+8+len(payload))
+		data[0] = opMessageData
+		binary.LittleEndian.PutUint32(data[1:], subID)
+		binary.LittleEndian.PutUint64(data[5:], uint64(t.UnixNano()))
+		data = append(data, payload...)
+
+		select {
+		case c.send <- data:
+		default: // the client is too slow, drop the message
+		}
+	}
+	return nil
+}
+
+// ServeHTTP upgrades the connection to WebSocket and serves the client.
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrade(w, r, Subprotocol)
+	if err != nil {
+		log.Println("foxglove: failed to upgrade connection:", err)
+		return
+	}
+	defer ws.Close()
+
+	c := &client{
+		ws:   ws,
+		subs: make(map[uint32]uint32),
+		send: make(chan []byte, clientQueueSize),
+	}
+	if err := server.hello(c); err != nil {
+		log.Println("foxglove: failed to greet client:", err)
+		return
+	}
+
+	server.mu.Lock()
+	server.clients[c] = true
+	server.mu.Unlock()
+	log.Println("foxglove: client connected from", r.RemoteAddr)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case data := <-c.send:
+				if err := ws.WriteBinary(data); err != nil {
+					ws.Close() // makes the read loop fail
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	server.readLoop(c)
+
+	close(done)
+	server.mu.Lock()
+	delete(server.clients, c)
+	server.mu.Unlock()
+	log.Println("foxglove: client disconnected from", r.RemoteAddr)
+}
+
+// hello sends the server info and advertises all channels.
+func (server *Server) hello(c *client) error {
+	info, err := json.Marshal(map[string]interface{}{
+		"op":           "serverInfo",
+		"name":         server.name,
+		"capabilities": []string{},
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.ws.WriteText(info); err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	advertise, err := json.Marshal(map[string]interface{}{
+		"op":       "advertise",
+		"channels": server.channels,
+	})
+	server.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteText(advertise)
+}
+
+// readLoop handles subscribe and unsubscribe requests until the client disconnects.
+func (server *Server) readLoop(c *client) {
+	for {
+		opcode, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if opcode != opText {
+			continue
+		}
+
+		var msg struct {
+			Op            string `json:"op"`
+			Subscriptions []struct {
+				ID        uint32 `json:"id"`
+				ChannelID uint32 `json:"channelId"`
+			} `json:"subscriptions"`
+			SubscriptionIDs []uint32 `json:"subscriptionIds"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		server.mu.Lock()
+		switch msg.Op {
+		case "subscribe":
+			for _, sub := range msg.Subscriptions {
+				c.subs[sub.ChannelID] = sub.ID
+			}
+		case "unsubscribe":
+			for _, id := range msg.SubscriptionIDs {
+				for channelID, subID := range c.subs {
+					if subID == id {
+						delete(c.subs, channelID)
+					}
+				}
+			}
+		}
+		server.mu.Unlock()
+	}
+}
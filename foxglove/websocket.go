@@ -0,0 +1,220 @@
+package foxglove
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A minimal server side WebSocket (RFC 6455) implementation, just enough for the
+// Foxglove protocol: no extensions, messages up to maxMessageSize.
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxMessageSize = 1 << 20
+)
+
+// WebSocket opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// wsConn is a server side WebSocket connection. Writes are safe for concurrent use,
+// reads are not.
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// upgrade performs the opening handshake. If the client offers the subprotocol,
+// it is selected.
+func upgrade(w http.ResponseWriter, r *http.Request, subprotocol string) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket connection expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack: %v", err)
+	}
+
+	hash := sha1.Sum([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n"
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", subprotocol) {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	response += "\r\n"
+
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write handshake: %v", err)
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// headerContains reports whether the comma separated header contains the token.
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message. Control frames are
+// handled internally. io.EOF is returned when the client closes the connection.
+func (ws *wsConn) ReadMessage() (opcode byte, data []byte, err error) {
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			ws.writeFrame(opClose, payload)
+			return 0, nil, io.EOF
+		case opContinuation:
+			if opcode == 0 {
+				return 0, nil, errors.New("unexpected continuation frame")
+			}
+		default:
+			opcode = op
+			data = data[:0]
+		}
+
+		data = append(data, payload...)
+		if len(data) > maxMessageSize {
+			return 0, nil, errors.New("message too big")
+		}
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.reader, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxMessageSize {
+		err = errors.New("frame too big")
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// WriteText sends a text message.
+func (ws *wsConn) WriteText(data []byte) error {
+	return ws.writeFrame(opText, data)
+}
+
+// WriteBinary sends a binary message.
+func (ws *wsConn) WriteBinary(data []byte) error {
+	return ws.writeFrame(opBinary, data)
+}
+
+// writeFrame sends a single unmasked frame with the FIN bit set.
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	head := make([]byte, 2, 10)
+	head[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		head[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(len(payload)))
+	default:
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(len(payload)))
+	}
+
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if _, err := ws.conn.Write(append(head, payload...)); err != nil {
+		return fmt.Errorf("write frame: %v", err)
+	}
+	return nil
+}
+
+// Close closes the underlying connection.
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}
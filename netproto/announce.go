@@ -0,0 +1,171 @@
+package netproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Discovery defaults.
+const (
+	DefaultAnnouncePort     = 8081
+	DefaultAnnounceInterval = 2 * time.Second
+)
+
+// Capabilities advertised in announcements.
+const (
+	CapabilityStream    = "stream"    // point clouds are sent to subscribers
+	CapabilityEncrypted = "encrypted" // packets are sealed with a pre-shared key
+)
+
+// Packet prefixes of the discovery protocol.
+const (
+	announceMagic  = "LTA1"
+	subscribeMagic = "LTB1"
+)
+
+// Announcement is periodically broadcast by a rig to let receivers find it.
+type Announcement struct {
+	Name         string   `json:"name"`         // human readable rig name
+	Capabilities []string `json:"capabilities"` // see Capability* constants
+	Format       string   `json:"format"`       // format of the streamed data, e.g. "lidar-scan"
+	Port         int      `json:"port"`         // UDP port the stream is sent from and subscriptions are sent to
+
+	// Addr is the address the announcement was received from. It is not a part
+	// of the packet.
+	Addr net.Addr `json:"-"`
+}
+
+// StreamAddr returns the address subscription requests are sent to: the host
+// of Addr and Port, or Addr if the rig didn't announce the port.
+func (a *Announcement) StreamAddr() net.Addr {
+	udpAddr, ok := a.Addr.(*net.UDPAddr)
+	if !ok || a.Port == 0 {
+		return a.Addr
+	}
+	return &net.UDPAddr{IP: udpAddr.IP, Port: a.Port, Zone: udpAddr.Zone}
+}
+
+// Has reports whether the rig announced the capability.
+func (a *Announcement) Has(capability string) bool {
+	for _, c := range a.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription asks a rig to send its stream to the sender of the request, so it
+// must be sent from the socket on which the receiver listens for the stream.
+// If the rig uses a pre-shared key, the request must be sealed on
+// ChannelSubscription.
+type Subscription struct {
+	Receiver string `json:"receiver"` // human readable receiver name, for logs only
+	Client   uint64 `json:"client"`   // random ID of the receiver, its sealed requests are checked for replays by it
+}
+
+// MarshalAnnouncement encodes the announcement to a packet.
+func MarshalAnnouncement(a *Announcement) ([]byte, error) {
+	return marshal(announceMagic, a)
+}
+
+// ParseAnnouncement decodes the announcement packet.
+func ParseAnnouncement(packet []byte) (a Announcement, err error) {
+	err = unmarshal(announceMagic, packet, &a)
+	return
+}
+
+// MarshalSubscription encodes the subscription request to a packet.
+func MarshalSubscription(s *Subscription) ([]byte, error) {
+	return marshal(subscribeMagic, s)
+}
+
+// ParseSubscription decodes the subscription request packet.
+func ParseSubscription(packet []byte) (s Subscription, err error) {
+	err = unmarshal(subscribeMagic, packet, &s)
+	return
+}
+
+func marshal(magic string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(magic), data...), nil
+}
+
+func unmarshal(magic string, packet []byte, v interface{}) error {
+	if !bytes.HasPrefix(packet, []byte(magic)) {
+		return errors.New("unexpected packet type")
+	}
+	if err := json.Unmarshal(packet[len(magic):], v); err != nil {
+		return fmt.Errorf("decode packet: %v", err)
+	}
+	return nil
+}
+
+// Announce sends the announcement to addr every interval until done is closed.
+// Errors are passed to onError (which may be nil) and do not stop the loop.
+func Announce(conn net.PacketConn, addr net.Addr, a *Announcement, interval time.Duration, done <-chan struct{}, onError func(error)) {
+	packet, err := MarshalAnnouncement(a)
+	if err != nil {
+		if onError != nil {
+			onError(err)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := conn.WriteTo(packet, addr); err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discover listens on conn for announcements for the given duration and returns
+// the rigs found, one per address, in the order they were first heard.
+func Discover(conn net.PacketConn, duration time.Duration) ([]Announcement, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(duration)); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	var rigs []Announcement
+	index := make(map[string]int)
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return rigs, nil
+			}
+			return rigs, err
+		}
+
+		a, err := ParseAnnouncement(buf[:n])
+		if err != nil {
+			continue // not an announcement, just ignore it
+		}
+		a.Addr = addr
+
+		if i, ok := index[addr.String()]; ok {
+			rigs[i] = a // the latest announcement wins
+			continue
+		}
+		index[addr.String()] = len(rigs)
+		rigs = append(rigs, a)
+	}
+}
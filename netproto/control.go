@@ -0,0 +1,326 @@
+package netproto
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Control channel defaults.
+const (
+	DefaultControlPort    = 8082
+	DefaultControlTimeout = 5 * time.Second
+	DefaultControlRetry   = 500 * time.Millisecond
+
+	// controlCacheTime is how long the server remembers responses to answer
+	// retransmitted requests without executing them again.
+	controlCacheTime = time.Minute
+)
+
+// Packet prefixes of the control protocol.
+const (
+	requestMagic  = "LTQ1"
+	responseMagic = "LTR1"
+)
+
+// Control commands.
+const (
+	CommandStatus      = "status"       // report the servo state
+	CommandServoSet    = "servo.set"    // stop sweeping and hold the servo at Position
+	CommandServoSweep  = "servo.sweep"  // resume sweeping
+	CommandSweepLimits = "sweep.limits" // change sweep limits to Min, Max and step to Step
+	CommandCalibrate   = "calibrate"    // move the servo to the calibration position and calibrate the accel
+)
+
+// ErrTimeout is returned by ControlClient.Do if no response came in time.
+var ErrTimeout = errors.New("request timed out")
+
+// Request is a command sent to the rig.
+type Request struct {
+	ID       uint64 `json:"id"`
+	Client   uint64 `json:"client"` // random ID of the client, sealed requests are checked for replays by it
+	Command  string `json:"command"`
+	Position uint16 `json:"position,omitempty"`
+	Min      uint16 `json:"min,omitempty"`
+	Max      uint16 `json:"max,omitempty"`
+	Step     uint16 `json:"step,omitempty"`
+}
+
+// Response acknowledges a request with the same ID.
+type Response struct {
+	ID     uint64       `json:"id"`
+	OK     bool         `json:"ok"`
+	Error  string       `json:"error,omitempty"`
+	Status *ServoStatus `json:"status,omitempty"`
+}
+
+// ServoStatus describes the current servo state.
+type ServoStatus struct {
+	Position uint16 `json:"position"`
+	Min      uint16 `json:"min"`
+	Max      uint16 `json:"max"`
+	Calib    uint16 `json:"calib"`
+	Step     uint16 `json:"step"`
+	Sweeping bool   `json:"sweeping"`
+}
+
+// Handler executes a request and returns the response. The response ID is set
+// by the server.
+type Handler func(req *Request) *Response
+
+// ControlServer receives requests, executes them and sends acknowledgements.
+// Retransmitted requests are executed only once. With a key, a request is
+// executed only if it is sealed and not replayed, from any address.
+type ControlServer struct {
+	conn    net.PacketConn
+	key     []byte
+	handler Handler
+
+	mu     sync.Mutex
+	sealer *Sealer
+	opener *ClientOpener
+	calls  map[string]*call // by client and request ID
+}
+
+type call struct {
+	response []byte    // nil while the request is being executed
+	done     time.Time // time of the execution end
+}
+
+// NewControlServer creates a server reading requests from conn. If key is not
+// nil, all packets have to be sealed with it.
+func NewControlServer(conn net.PacketConn, key []byte, handler Handler) (*ControlServer, error) {
+	server := &ControlServer{
+		conn:    conn,
+		key:     key,
+		handler: handler,
+		calls:   make(map[string]*call),
+	}
+
+	if key != nil {
+		var err error
+		if server.sealer, err = NewChannelSealer(key, ChannelControlResponse); err != nil {
+			return nil, err
+		}
+		if server.opener, err = NewClientOpener(key, ChannelControlRequest); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// Serve reads requests until conn is closed. Each request is executed in its own
+// goroutine, so a long one (e.g. calibration) does not block the others.
+func (server *ControlServer) Serve() error {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := server.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		req, err := server.parse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		// retransmissions are sealed again, so they pass the replay check and
+		// are recognized by the request ID
+		key := fmt.Sprintf("%d/%d", req.Client, req.ID)
+		if server.key == nil {
+			key = fmt.Sprintf("%s/%s", addr, key)
+		}
+		server.mu.Lock()
+		server.forget()
+		c, ok := server.calls[key]
+		var response []byte
+		if ok {
+			response = c.response
+		} else {
+			server.calls[key] = &call{}
+		}
+		server.mu.Unlock()
+
+		if ok {
+			if response != nil { // already done, the acknowledgement was probably lost
+				server.conn.WriteTo(response, addr)
+			}
+			continue
+		}
+
+		go server.execute(addr, key, req)
+	}
+}
+
+func (server *ControlServer) execute(addr net.Addr, key string, req *Request) {
+	resp := server.handler(req)
+	resp.ID = req.ID
+
+	packet, err := marshal(responseMagic, resp)
+	if err != nil {
+		return
+	}
+
+	server.mu.Lock()
+	if server.sealer != nil {
+		packet = server.sealer.Seal(packet)
+	}
+	server.calls[key].response = packet
+	server.calls[key].done = time.Now()
+	server.mu.Unlock()
+
+	server.conn.WriteTo(packet, addr)
+}
+
+// parse verifies the packet if the server uses a key and decodes the request.
+func (server *ControlServer) parse(packet []byte) (*Request, error) {
+	var seq uint64
+	if server.opener != nil {
+		var err error
+		if packet, seq, err = server.opener.Open(packet); err != nil {
+			return nil, err
+		}
+	}
+
+	var req Request
+	if err := unmarshal(requestMagic, packet, &req); err != nil {
+		return nil, err
+	}
+
+	if server.opener != nil {
+		if err := server.opener.Accept(req.Client, seq); err != nil {
+			return nil, err
+		}
+	}
+	return &req, nil
+}
+
+// forget removes old finished calls. It must be called with mu locked.
+func (server *ControlServer) forget() {
+	for key, c := range server.calls {
+		if c.response != nil && time.Since(c.done) > controlCacheTime {
+			delete(server.calls, key)
+		}
+	}
+}
+
+// ControlClient sends requests to a rig and waits for acknowledgements.
+// It is not safe for concurrent use.
+type ControlClient struct {
+	conn   net.PacketConn
+	addr   net.Addr
+	sealer *Sealer
+	opener *Opener
+	id     uint64 // client ID sent in requests
+	nextID uint64
+
+	// Retry is the time after which an unacknowledged request is sent again.
+	Retry time.Duration
+}
+
+// DialControl creates a client of the rig listening on addr. If key is not nil,
+// all packets are sealed with it.
+func DialControl(addr string, key []byte) (*ControlClient, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %v", addr, err)
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("open socket: %v", err)
+	}
+
+	client := &ControlClient{
+		conn:   conn,
+		addr:   udpAddr,
+		id:     NewClientID(),
+		nextID: 1,
+		Retry:  DefaultControlRetry,
+	}
+	if key != nil {
+		if client.sealer, err = NewChannelSealer(key, ChannelControlRequest); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if client.opener, err = NewChannelOpener(key, ChannelControlResponse); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Close closes the client socket.
+func (client *ControlClient) Close() error {
+	return client.conn.Close()
+}
+
+// Do sends the request with a new ID and waits for its acknowledgement,
+// retransmitting it every client.Retry. It returns ErrTimeout if no
+// acknowledgement came within timeout.
+func (client *ControlClient) Do(req *Request, timeout time.Duration) (*Response, error) {
+	req.ID = client.nextID
+	req.Client = client.id
+	client.nextID++
+
+	packet, err := marshal(requestMagic, req)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 65536)
+	for time.Now().Before(deadline) {
+		// every retransmission is sealed again, so it is not rejected as a replay
+		out := packet
+		if client.sealer != nil {
+			out = client.sealer.Seal(packet)
+		}
+		if _, err := client.conn.WriteTo(out, client.addr); err != nil {
+			return nil, fmt.Errorf("send request: %v", err)
+		}
+
+		retry := time.Now().Add(client.Retry)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+		client.conn.SetReadDeadline(retry)
+
+		for {
+			n, _, err := client.conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // time to retransmit
+				}
+				return nil, fmt.Errorf("read response: %v", err)
+			}
+
+			resp, err := client.parse(buf[:n])
+			if err != nil || resp.ID != req.ID {
+				continue // forged or a late response to an older request
+			}
+			return resp, nil
+		}
+	}
+
+	return nil, ErrTimeout
+}
+
+func (client *ControlClient) parse(packet []byte) (*Response, error) {
+	if client.opener != nil {
+		var err error
+		if packet, err = client.opener.Open(packet); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp Response
+	if err := unmarshal(responseMagic, packet, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
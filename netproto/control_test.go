@@ -0,0 +1,177 @@
+package netproto
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lossyProxy forwards packets between a control client and server, dropping the
+// packets the drop functions choose.
+type lossyProxy struct {
+	conn       net.PacketConn
+	server     net.Addr
+	dropQuery  func(n int) bool // called with the number of the request, from 1
+	dropAnswer func(n int) bool // called with the number of the response, from 1
+}
+
+func (proxy *lossyProxy) serve() {
+	var client net.Addr
+	var queries, answers int
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := proxy.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if addr.String() == proxy.server.String() {
+			answers++
+			if client != nil && !proxy.dropAnswer(answers) {
+				proxy.conn.WriteTo(buf[:n], client)
+			}
+			continue
+		}
+		client = addr
+		queries++
+		if !proxy.dropQuery(queries) {
+			proxy.conn.WriteTo(buf[:n], proxy.server)
+		}
+	}
+}
+
+// startControl starts a server with the handler and a client connected to it
+// through a proxy dropping the chosen packets.
+func startControl(t *testing.T, key []byte, handler Handler, dropQuery, dropAnswer func(int) bool) *ControlClient {
+	t.Helper()
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { serverConn.Close() })
+	server, err := NewControlServer(serverConn, key, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	proxyConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxyConn.Close() })
+	proxy := &lossyProxy{proxyConn, serverConn.LocalAddr(), dropQuery, dropAnswer}
+	go proxy.serve()
+
+	client, err := DialControl(proxyConn.LocalAddr().String(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.Retry = 20 * time.Millisecond
+	return client
+}
+
+// countingHandler returns the number of executions in the status position.
+func countingHandler(executions *int32, delay time.Duration) Handler {
+	return func(req *Request) *Response {
+		n := atomic.AddInt32(executions, 1)
+		time.Sleep(delay)
+		return &Response{OK: true, Status: &ServoStatus{Position: uint16(n)}}
+	}
+}
+
+func never(int) bool   { return false }
+func first(n int) bool { return n == 1 }
+func always(int) bool  { return true }
+
+func TestControlRetransmission(t *testing.T) {
+	cases := []struct {
+		name                  string
+		dropQuery, dropAnswer func(int) bool
+		delay                 time.Duration
+	}{
+		{"no loss", never, never, 0},
+		{"lost request", first, never, 0},
+		{"lost response", never, first, 0},
+		{"slow execution", never, never, 100 * time.Millisecond},
+	}
+	for _, key := range [][]byte{nil, testKey} {
+		mode := "unsealed"
+		if key != nil {
+			mode = "sealed"
+		}
+		for _, c := range cases {
+			t.Run(mode+"/"+c.name, func(t *testing.T) {
+				var executions int32
+				client := startControl(t, key, countingHandler(&executions, c.delay), c.dropQuery, c.dropAnswer)
+
+				for i := 1; i <= 2; i++ {
+					resp, err := client.Do(&Request{Command: CommandStatus}, time.Second)
+					if err != nil {
+						t.Fatalf("request %d: %v", i, err)
+					}
+					if !resp.OK || resp.ID != uint64(i) || resp.Status == nil || resp.Status.Position != uint16(i) {
+						t.Errorf("request %d: response %+v, status %+v, want the response of execution %d", i, resp, resp.Status, i)
+					}
+				}
+				time.Sleep(50 * time.Millisecond) // let late retransmissions arrive
+				if n := atomic.LoadInt32(&executions); n != 2 {
+					t.Errorf("2 requests executed %d times", n)
+				}
+			})
+		}
+	}
+}
+
+func TestControlTimeout(t *testing.T) {
+	var executions int32
+	client := startControl(t, nil, countingHandler(&executions, 0), never, always)
+
+	start := time.Now()
+	if _, err := client.Do(&Request{Command: CommandStatus}, 200*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Do() error %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("timed out after %v, want 200ms", elapsed)
+	}
+	if n := atomic.LoadInt32(&executions); n != 1 {
+		t.Errorf("unacknowledged request executed %d times, want once", n)
+	}
+}
+
+func TestControlRejectsReplays(t *testing.T) {
+	var executions int32
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+	server, err := NewControlServer(serverConn, testKey, countingHandler(&executions, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	// a client seals its requests and an attacker captures them
+	client, err := DialControl(serverConn.LocalAddr().String(), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	packet, _ := marshal(requestMagic, &Request{ID: 1, Client: client.id, Command: CommandStatus})
+	sealed := client.sealer.Seal(packet)
+
+	for i := 0; i < 3; i++ {
+		attacker, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer attacker.Close()
+		attacker.WriteTo(sealed, serverConn.LocalAddr())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&executions); n != 1 {
+		t.Errorf("a request replayed from 3 addresses executed %d times, want once", n)
+	}
+}
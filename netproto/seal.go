@@ -0,0 +1,378 @@
+// Package netproto contains the wire formats shared by the network tools
+// (transmitter, receiver and sync).
+package netproto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// KeySize is the size in bytes of a pre-shared key (AES-256).
+const KeySize = 32
+
+// Sealed packet layout:
+//
+//	magic (4 bytes) | sender (4 bytes) | sequence number (8 bytes, big endian) | ciphertext | tag (16 bytes)
+//
+// The header is authenticated as additional data and the sender and the sequence
+// number are used as the nonce, so every packet must carry a different pair.
+const (
+	sealedMagic      = "LTS2"
+	senderSize       = 4
+	sealedHeaderSize = len(sealedMagic) + senderSize + 8
+
+	// replayWindow is how much older than the newest one a sequence number can
+	// be to be still accepted (UDP does not preserve packet order).
+	replayWindow = time.Second
+
+	// DefaultMaxSkew is the default Opener.MaxSkew.
+	DefaultMaxSkew = 30 * time.Second
+)
+
+// Channels which share a pre-shared key. Each of them is sealed with its own
+// key derived from the pre-shared one, so packets of one channel are not
+// accepted on another. Senders of a channel (e.g. the receivers subscribing to
+// a rig) pick a random sender ID, so their nonces differ even if their clocks
+// give the same sequence number.
+const (
+	ChannelStream byte = iota
+	ChannelControlRequest
+	ChannelControlResponse
+	ChannelSubscription
+)
+
+// Errors returned by Opener.Open.
+var (
+	ErrNotSealed = errors.New("packet is not sealed")
+	ErrAuth      = errors.New("packet authentication failed")
+	ErrReplay    = errors.New("replayed or too old packet")
+	ErrSkew      = errors.New("sequence number too far from the local clock")
+)
+
+// ReadKey returns the pre-shared key given either as a hex string or as a path to
+// a file containing the hex string. It returns nil if both are empty.
+func ReadKey(hexKey string, path string) (key []byte, err error) {
+	if hexKey != "" && path != "" {
+		return nil, errors.New("key given both directly and as a file")
+	}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %v", err)
+		}
+		hexKey = string(data)
+	}
+
+	if hexKey == "" {
+		return nil, nil
+	}
+
+	key, err = hex.DecodeString(strings.TrimSpace(hexKey))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %v", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes long, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// newAEAD returns the AEAD of the channel, keyed by HMAC-SHA256 of the channel
+// with the pre-shared key.
+func newAEAD(key []byte, channel byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes long, got %d", KeySize, len(key))
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{'l', 't', 's', channel})
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the AEAD nonce of the header: the sender and the sequence
+// number.
+func nonce(header []byte) []byte {
+	return append([]byte(nil), header[len(sealedMagic):sealedHeaderSize]...)
+}
+
+// IsSealed reports whether the packet looks like a sealed one. It is not
+// authenticated, use it only to explain why a packet was rejected.
+func IsSealed(packet []byte) bool {
+	return len(packet) >= sealedHeaderSize && string(packet[:len(sealedMagic)]) == sealedMagic
+}
+
+// Sealer encrypts and authenticates outgoing packets. It is not safe for
+// concurrent use.
+type Sealer struct {
+	aead   cipher.AEAD
+	sender [senderSize]byte // random, distinguishes nonces of senders sharing the key
+	seq    uint64           // sequence number of the next packet
+}
+
+// NewSealer creates a Sealer of the stream channel using the pre-shared key.
+func NewSealer(key []byte) (*Sealer, error) {
+	return NewChannelSealer(key, ChannelStream)
+}
+
+// NewChannelSealer creates a Sealer of the given channel using the pre-shared key.
+//
+// Sequence numbers follow the current Unix time in microseconds, so they keep
+// increasing (and nonces are not reused) across restarts and openers can reject
+// packets of old sessions.
+func NewChannelSealer(key []byte, channel byte) (*Sealer, error) {
+	aead, err := newAEAD(key, channel)
+	if err != nil {
+		return nil, err
+	}
+	sealer := &Sealer{aead: aead, seq: clockSeq(time.Now())}
+	if _, err := rand.Read(sealer.sender[:]); err != nil {
+		return nil, fmt.Errorf("create sender ID: %v", err)
+	}
+	return sealer, nil
+}
+
+// clockSeq returns the sequence number of the time.
+func clockSeq(t time.Time) uint64 {
+	return uint64(t.UnixNano() / 1000)
+}
+
+// Seal returns the sealed packet containing data.
+func (sealer *Sealer) Seal(data []byte) []byte {
+	packet := make([]byte, sealedHeaderSize, sealedHeaderSize+len(data)+sealer.aead.Overhead())
+	copy(packet, sealedMagic)
+	copy(packet[len(sealedMagic):], sealer.sender[:])
+	if now := clockSeq(time.Now()); now > sealer.seq {
+		sealer.seq = now
+	}
+	binary.BigEndian.PutUint64(packet[len(sealedMagic)+senderSize:], sealer.seq)
+
+	header := packet[:sealedHeaderSize]
+	packet = sealer.aead.Seal(packet, nonce(header), data, header)
+	sealer.seq++
+	return packet
+}
+
+// OpenerStats counts packets processed by an Opener.
+type OpenerStats struct {
+	Accepted        uint64 // packets which passed all checks
+	Unauthenticated uint64 // malformed packets and packets with an invalid tag
+	Replayed        uint64 // packets with an already seen or too old sequence number, or one far from the clock
+}
+
+// Rejected returns the number of all rejected packets.
+func (stats OpenerStats) Rejected() uint64 {
+	return stats.Unauthenticated + stats.Replayed
+}
+
+// Opener verifies and decrypts incoming packets. It keeps track of the received
+// sequence numbers to reject replayed packets. The window of the received
+// sequence numbers starts empty, so packets whose sequence number is far from
+// the local clock are rejected too, otherwise an old session could be replayed
+// after a restart. It is not safe for concurrent use.
+type Opener struct {
+	aead   cipher.AEAD
+	window window
+	Stats  OpenerStats
+
+	// MaxSkew is the max difference between the time of the sequence number and
+	// the local clock, the clocks of the sender and the receiver must be
+	// synchronized that well (0 disables the check).
+	MaxSkew time.Duration
+}
+
+// NewOpener creates an Opener of the stream channel using the pre-shared key.
+func NewOpener(key []byte) (*Opener, error) {
+	return NewChannelOpener(key, ChannelStream)
+}
+
+// NewChannelOpener creates an Opener of the given channel using the pre-shared key.
+func NewChannelOpener(key []byte, channel byte) (*Opener, error) {
+	aead, err := newAEAD(key, channel)
+	if err != nil {
+		return nil, err
+	}
+	return &Opener{aead: aead, MaxSkew: DefaultMaxSkew}, nil
+}
+
+// Open verifies the packet and returns its decrypted content.
+func (opener *Opener) Open(packet []byte) (data []byte, err error) {
+	seq, err := opener.seq(packet)
+	if err == nil && !opener.window.fresh(seq) {
+		// checked before decryption to avoid wasting time on replay floods,
+		// the window is updated only after successful authentication
+		err = ErrReplay
+	}
+	if err == nil {
+		data, err = opener.decrypt(packet)
+	}
+	switch err {
+	case nil:
+		opener.window.mark(seq)
+		opener.Stats.Accepted++
+	case ErrNotSealed, ErrAuth:
+		opener.Stats.Unauthenticated++
+	default:
+		opener.Stats.Replayed++
+	}
+	return data, err
+}
+
+// seq returns the sequence number of the packet if it is close enough to the
+// local clock.
+func (opener *Opener) seq(packet []byte) (uint64, error) {
+	if len(packet) < sealedHeaderSize+opener.aead.Overhead() ||
+		string(packet[:len(sealedMagic)]) != sealedMagic {
+		return 0, ErrNotSealed
+	}
+
+	seq := binary.BigEndian.Uint64(packet[len(sealedMagic)+senderSize : sealedHeaderSize])
+	if opener.MaxSkew > 0 {
+		now, skew := clockSeq(time.Now()), uint64(opener.MaxSkew/time.Microsecond)
+		if seq+skew < now || seq > now+skew {
+			return 0, ErrSkew
+		}
+	}
+	return seq, nil
+}
+
+// decrypt verifies the packet and returns its content.
+func (opener *Opener) decrypt(packet []byte) ([]byte, error) {
+	header := packet[:sealedHeaderSize]
+	data, err := opener.aead.Open(nil, nonce(header), packet[sealedHeaderSize:], header)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return data, nil
+}
+
+// window keeps track of the accepted sequence numbers within replayWindow of
+// the newest one.
+type window struct {
+	newest uint64              // the highest accepted sequence number
+	seen   map[uint64]struct{} // accepted sequence numbers, some may be too old already
+	limit  int                 // size of seen at which too old ones are removed
+}
+
+// fresh reports whether seq has not been accepted yet and fits in the window.
+func (w *window) fresh(seq uint64) bool {
+	if w.seen == nil || seq > w.newest {
+		return true
+	}
+	if w.old(seq) {
+		return false
+	}
+	_, ok := w.seen[seq]
+	return !ok
+}
+
+// old reports whether seq is out of the window.
+func (w *window) old(seq uint64) bool {
+	return w.newest-seq >= uint64(replayWindow/time.Microsecond)
+}
+
+// mark records seq as accepted.
+func (w *window) mark(seq uint64) {
+	if w.seen == nil {
+		w.seen = make(map[uint64]struct{})
+	}
+	if seq > w.newest {
+		w.newest = seq
+	}
+	w.seen[seq] = struct{}{}
+
+	if len(w.seen) >= w.limit {
+		for s := range w.seen {
+			if w.old(s) {
+				delete(w.seen, s)
+			}
+		}
+		w.limit = 2 * len(w.seen)
+		if w.limit < 1024 {
+			w.limit = 1024
+		}
+	}
+}
+
+// ClientOpener verifies packets which many clients seal with the same key on
+// one channel. Every client keeps its own sequence numbers, so they are checked
+// for replays per client ID, which the caller reads from the authenticated
+// content. Clients are created only for authentic packets and forgotten once
+// their packets would be rejected as too far from the clock anyway. It is not
+// safe for concurrent use.
+type ClientOpener struct {
+	opener  *Opener
+	clients map[uint64]*client
+}
+
+type client struct {
+	window window
+	last   time.Time // of the latest accepted packet
+}
+
+// NewClientID returns a random client ID.
+func NewClientID() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// NewClientOpener creates a ClientOpener of the given channel using the
+// pre-shared key.
+func NewClientOpener(key []byte, channel byte) (*ClientOpener, error) {
+	opener, err := NewChannelOpener(key, channel)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientOpener{opener: opener, clients: make(map[uint64]*client)}, nil
+}
+
+// Open verifies the packet and returns its decrypted content and sequence
+// number. The content must not be acted upon before Accept succeeds.
+func (co *ClientOpener) Open(packet []byte) (data []byte, seq uint64, err error) {
+	if seq, err = co.opener.seq(packet); err != nil {
+		return nil, 0, err
+	}
+	if data, err = co.opener.decrypt(packet); err != nil {
+		return nil, 0, err
+	}
+	return data, seq, nil
+}
+
+// Accept records seq as received from the client. It returns ErrReplay if it
+// was received already or is too old.
+func (co *ClientOpener) Accept(id uint64, seq uint64) error {
+	now := time.Now()
+	// the clock of the client may be ahead by MaxSkew
+	timeout := 2*co.opener.MaxSkew + replayWindow
+	for cid, c := range co.clients {
+		if co.opener.MaxSkew > 0 && now.Sub(c.last) > timeout {
+			delete(co.clients, cid)
+		}
+	}
+
+	c, ok := co.clients[id]
+	if !ok {
+		c = &client{}
+		co.clients[id] = c
+	}
+	if !c.window.fresh(seq) {
+		return ErrReplay
+	}
+	c.window.mark(seq)
+	c.last = now
+	return nil
+}
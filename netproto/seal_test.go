@@ -0,0 +1,267 @@
+package netproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, KeySize)
+
+func newTestPair(t *testing.T, channel byte) (*Sealer, *Opener) {
+	t.Helper()
+	sealer, err := NewChannelSealer(testKey, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opener, err := NewChannelOpener(testKey, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer, opener
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	sealer, opener := newTestPair(t, ChannelStream)
+	for _, msg := range []string{"", "! 0 0\n", "1.0 2.0 3.0\n"} {
+		packet := sealer.Seal([]byte(msg))
+		if bytes.Contains(packet, []byte(msg)) && msg != "" {
+			t.Errorf("packet of %q contains the plaintext", msg)
+		}
+		data, err := opener.Open(packet)
+		if err != nil {
+			t.Fatalf("Open(Seal(%q)): %v", msg, err)
+		}
+		if string(data) != msg {
+			t.Errorf("Open(Seal(%q)) = %q", msg, data)
+		}
+	}
+	if opener.Stats.Accepted != 3 || opener.Stats.Rejected() != 0 {
+		t.Errorf("stats %+v, want 3 accepted", opener.Stats)
+	}
+}
+
+func TestOpenRejectsForgeries(t *testing.T) {
+	sealer, opener := newTestPair(t, ChannelStream)
+	other, err := NewChannelSealer(testKey, ChannelControlRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, err := NewSealer(bytes.Repeat([]byte{0x24}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(i int) []byte {
+		packet := sealer.Seal([]byte("data"))
+		if i < 0 {
+			i += len(packet)
+		}
+		packet[i] ^= 1
+		return packet
+	}
+	cases := []struct {
+		name   string
+		packet []byte
+		err    error
+	}{
+		{"plain", []byte("! 0 0\n"), ErrNotSealed},
+		{"short", sealer.Seal(nil)[:sealedHeaderSize], ErrNotSealed},
+		{"magic", tamper(0), ErrNotSealed},
+		{"sender", tamper(len(sealedMagic)), ErrAuth},
+		{"sequence number", tamper(sealedHeaderSize - 1), ErrAuth},
+		{"ciphertext", tamper(sealedHeaderSize), ErrAuth},
+		{"tag", tamper(-1), ErrAuth},
+		{"other channel", other.Seal([]byte("data")), ErrAuth},
+		{"wrong key", wrongKey.Seal([]byte("data")), ErrAuth},
+	}
+	for _, c := range cases {
+		if _, err := opener.Open(c.packet); err != c.err {
+			t.Errorf("%s: Open() error %v, want %v", c.name, err, c.err)
+		}
+	}
+	if opener.Stats.Unauthenticated != uint64(len(cases)) || opener.Stats.Accepted != 0 {
+		t.Errorf("stats %+v, want %d unauthenticated", opener.Stats, len(cases))
+	}
+
+	// a forgery doesn't mark its sequence number as seen
+	packet := sealer.Seal([]byte("data"))
+	forged := append([]byte(nil), packet...)
+	forged[len(forged)-1] ^= 1
+	if _, err := opener.Open(forged); err != ErrAuth {
+		t.Fatalf("forged packet: %v, want %v", err, ErrAuth)
+	}
+	if _, err := opener.Open(packet); err != nil {
+		t.Fatalf("packet after its forgery: %v", err)
+	}
+}
+
+func TestOpenRejectsReplays(t *testing.T) {
+	sealer, opener := newTestPair(t, ChannelStream)
+	first := sealer.Seal([]byte("first"))
+	second := sealer.Seal([]byte("second"))
+
+	// reordering within the window is fine
+	if _, err := opener.Open(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opener.Open(first); err != nil {
+		t.Fatalf("reordered packet: %v", err)
+	}
+	for _, packet := range [][]byte{first, second} {
+		if _, err := opener.Open(packet); err != ErrReplay {
+			t.Errorf("duplicate packet: %v, want %v", err, ErrReplay)
+		}
+	}
+
+	// a packet older than the window is rejected even if it wasn't seen
+	old := sealer.Seal([]byte("old"))
+	sealer.seq = clockSeq(time.Now().Add(2 * replayWindow))
+	if _, err := opener.Open(sealer.Seal([]byte("new"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opener.Open(old); err != ErrReplay {
+		t.Errorf("packet out of the window: %v, want %v", err, ErrReplay)
+	}
+
+	if opener.Stats.Accepted != 3 || opener.Stats.Replayed != 3 {
+		t.Errorf("stats %+v, want 3 accepted and 3 replayed", opener.Stats)
+	}
+}
+
+func TestOpenRejectsSkew(t *testing.T) {
+	sealer, opener := newTestPair(t, ChannelStream)
+	opener.MaxSkew = time.Second
+
+	sealer.seq = clockSeq(time.Now().Add(time.Minute))
+	ahead := sealer.Seal([]byte("ahead"))
+	if _, err := opener.Open(ahead); err != ErrSkew {
+		t.Errorf("packet a minute ahead: %v, want %v", err, ErrSkew)
+	}
+
+	// a packet of a session recorded a minute ago
+	behind, _ := newTestPair(t, ChannelStream)
+	behind.seq = clockSeq(time.Now().Add(-time.Minute))
+	packet := make([]byte, 0, 64)
+	packet = append(packet, sealedMagic...)
+	packet = append(packet, behind.sender[:]...)
+	packet = append(packet, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(packet[len(sealedMagic)+senderSize:], behind.seq)
+	header := packet[:sealedHeaderSize]
+	packet = behind.aead.Seal(packet, nonce(header), []byte("behind"), header)
+	if _, err := opener.Open(packet); err != ErrSkew {
+		t.Errorf("packet a minute behind: %v, want %v", err, ErrSkew)
+	}
+	if opener.Stats.Replayed != 2 {
+		t.Errorf("stats %+v, want 2 replayed", opener.Stats)
+	}
+
+	opener.MaxSkew = 0
+	if _, err := opener.Open(packet); err != nil {
+		t.Errorf("packet a minute behind without the check: %v", err)
+	}
+	if _, err := opener.Open(ahead); err != nil {
+		t.Errorf("packet a minute ahead without the check: %v", err)
+	}
+}
+
+func TestSendersDoNotShareNonces(t *testing.T) {
+	a, _ := newTestPair(t, ChannelSubscription)
+	b, _ := newTestPair(t, ChannelSubscription)
+	co, err := NewClientOpener(testKey, ChannelSubscription)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clocks giving the same sequence number
+	seq := clockSeq(time.Now())
+	a.seq, b.seq = seq, seq
+	pa, pb := a.Seal([]byte("a")), b.Seal([]byte("b"))
+	if bytes.Equal(nonce(pa[:sealedHeaderSize]), nonce(pb[:sealedHeaderSize])) {
+		t.Fatal("senders with the same sequence number share the nonce")
+	}
+	for _, packet := range [][]byte{pa, pb} {
+		if _, _, err := co.Open(packet); err != nil {
+			t.Errorf("Open(): %v", err)
+		}
+	}
+}
+
+func TestClientOpenerAccept(t *testing.T) {
+	co, err := NewClientOpener(testKey, ChannelSubscription)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := NewClientID(), NewClientID()
+	sealerA, _ := newTestPair(t, ChannelSubscription)
+	sealerB, _ := newTestPair(t, ChannelSubscription)
+	sealerB.seq = sealerA.seq + 1000 // B's clock is ahead
+
+	first := sealerA.Seal([]byte("a1"))
+	second := sealerA.Seal([]byte("a2"))
+	other := sealerB.Seal([]byte("b1"))
+
+	data, seqA2, err := co.Open(second)
+	if err != nil || string(data) != "a2" {
+		t.Fatalf("Open() = %q, %v", data, err)
+	}
+	if err := co.Accept(a, seqA2); err != nil {
+		t.Fatal(err)
+	}
+	_, seqB1, err := co.Open(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := co.Accept(b, seqB1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A's older packet still fits its own window, B's newer one doesn't move it
+	_, seqA1, err := co.Open(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := co.Accept(a, seqA1); err != nil {
+		t.Errorf("reordered packet of a client: %v", err)
+	}
+
+	// replays are rejected per client, another client may use the same number
+	if err := co.Accept(a, seqA2); err != ErrReplay {
+		t.Errorf("replayed packet of a client: %v, want %v", err, ErrReplay)
+	}
+	if err := co.Accept(b, seqA2); err != nil {
+		t.Errorf("sequence number of another client: %v", err)
+	}
+	if err := co.Accept(b, seqB1); err != ErrReplay {
+		t.Errorf("replayed packet of another client: %v, want %v", err, ErrReplay)
+	}
+
+	forged := append([]byte(nil), other...)
+	forged[len(forged)-1] ^= 1
+	if _, _, err := co.Open(forged); err != ErrAuth {
+		t.Errorf("forged packet: %v, want %v", err, ErrAuth)
+	}
+}
+
+func TestClientOpenerForgetsIdleClients(t *testing.T) {
+	co, err := NewClientOpener(testKey, ChannelSubscription)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, active := NewClientID(), NewClientID()
+	now := clockSeq(time.Now())
+	if err := co.Accept(idle, now); err != nil {
+		t.Fatal(err)
+	}
+	co.clients[idle].last = time.Now().Add(-2*co.opener.MaxSkew - 2*replayWindow)
+	if err := co.Accept(active, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := co.clients[idle]; ok {
+		t.Error("idle client was not forgotten")
+	}
+	if _, ok := co.clients[active]; !ok {
+		t.Error("active client was forgotten")
+	}
+}
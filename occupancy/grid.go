@@ -0,0 +1,199 @@
+// Package occupancy builds probabilistic 2D occupancy grids from planar scans,
+// saved as PGM + YAML maps (the format of the ROS map_server), and 3D voxel
+// maps from 3D scans.
+package occupancy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Log-odds updates and limits, the limits keep cells able to change when the
+// scene changes.
+const (
+	LogOddsHit  = 0.85
+	LogOddsMiss = -0.4
+	LogOddsMin  = -2.0
+	LogOddsMax  = 3.5
+)
+
+// Thresholds written to the YAML file, tools treat cells with the occupancy
+// probability above OccupiedThreshold as occupied and below FreeThreshold as
+// free.
+const (
+	OccupiedThreshold = 0.65
+	FreeThreshold     = 0.196
+)
+
+// pgmUnknown is the PGM value of cells which were never observed, as written by
+// ROS map_saver.
+const pgmUnknown = 205
+
+// Pose is a 2D pose of the sensor in the map, meters and radians.
+type Pose struct {
+	X, Y, Yaw float64
+}
+
+// Point is a 2D point in meters.
+type Point struct {
+	X, Y float64
+}
+
+// Grid is an occupancy grid. Cells hold the log-odds of being occupied, zero
+// (probability 0.5) for unknown.
+type Grid struct {
+	Resolution float64 // cell size in meters
+	Width      int     // cells along X
+	Height     int     // cells along Y
+	Origin     Point   // position of the lower-left corner of cell (0, 0) in meters
+	logOdds    []float32
+}
+
+// NewGrid creates a square grid of the size in meters centered at the origin.
+func NewGrid(resolution, size float64) *Grid {
+	n := int(math.Ceil(size / resolution))
+	return &Grid{
+		Resolution: resolution,
+		Width:      n,
+		Height:     n,
+		Origin:     Point{-float64(n) * resolution / 2, -float64(n) * resolution / 2},
+		logOdds:    make([]float32, n*n),
+	}
+}
+
+// cell returns the cell containing the point, which may be outside the grid.
+func (g *Grid) cell(p Point) (x, y int) {
+	return int(math.Floor((p.X - g.Origin.X) / g.Resolution)), int(math.Floor((p.Y - g.Origin.Y) / g.Resolution))
+}
+
+func (g *Grid) inside(x, y int) bool {
+	return x >= 0 && y >= 0 && x < g.Width && y < g.Height
+}
+
+func (g *Grid) update(x, y int, delta float32) {
+	i := y*g.Width + x
+	l := g.logOdds[i] + delta
+	if l < LogOddsMin {
+		l = LogOddsMin
+	} else if l > LogOddsMax {
+		l = LogOddsMax
+	}
+	g.logOdds[i] = l
+}
+
+// Probability returns the occupancy probability of the cell.
+func (g *Grid) Probability(x, y int) float64 {
+	return 1 - 1/(1+math.Exp(float64(g.logOdds[y*g.Width+x])))
+}
+
+// Integrate adds a scan taken from the pose. Points are hits in the sensor
+// frame. Cells along each ray are marked more likely free and the hit cell
+// more likely occupied. Parts of rays outside the grid are ignored.
+func (g *Grid) Integrate(pose Pose, points []Point) {
+	sx, sy := g.cell(Point{pose.X, pose.Y})
+	sin, cos := math.Sincos(pose.Yaw)
+	for _, p := range points {
+		world := Point{pose.X + cos*p.X - sin*p.Y, pose.Y + sin*p.X + cos*p.Y}
+		hx, hy := g.cell(world)
+		g.traceRay(sx, sy, hx, hy)
+		if g.inside(hx, hy) {
+			g.update(hx, hy, LogOddsHit)
+		}
+	}
+}
+
+// traceRay marks cells from (x0, y0) to (x1, y1), excluding the last one, as
+// observed free (Bresenham's line algorithm).
+func (g *Grid) traceRay(x0, y0, x1, y1 int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	stepX, stepY := 1, 1
+	if x0 > x1 {
+		stepX = -1
+	}
+	if y0 > y1 {
+		stepY = -1
+	}
+	e := dx + dy
+	for x, y := x0, y0; x != x1 || y != y1; {
+		if g.inside(x, y) {
+			g.update(x, y, LogOddsMiss)
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += stepX
+		}
+		if e2 <= dx {
+			e += dx
+			y += stepY
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// WritePGM writes the grid as a binary PGM image, the top row is the largest
+// Y. Occupied cells are dark, free cells light, unknown cells gray.
+func (g *Grid) WritePGM(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n# lidar-tools occupancy grid, resolution %g m\n%d %d\n255\n", g.Resolution, g.Width, g.Height)
+	row := make([]byte, g.Width)
+	for y := g.Height - 1; y >= 0; y-- {
+		for x := 0; x < g.Width; x++ {
+			if g.logOdds[y*g.Width+x] == 0 {
+				row[x] = pgmUnknown
+				continue
+			}
+			row[x] = byte(math.Round((1 - g.Probability(x, y)) * 254))
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
+
+// WriteYAML writes the map metadata referring to the image file.
+func (g *Grid) WriteYAML(w io.Writer, image string) error {
+	_, err := fmt.Fprintf(w, "image: %s\nresolution: %f\norigin: [%f, %f, 0.0]\nnegate: 0\noccupied_thresh: %g\nfree_thresh: %g\n",
+		image, g.Resolution, g.Origin.X, g.Origin.Y, OccupiedThreshold, FreeThreshold)
+	return err
+}
+
+// Save writes the map to base.pgm and base.yaml. The files are replaced
+// atomically, so tools watching them never read a partial map.
+func (g *Grid) Save(base string) error {
+	if err := writeFileAtomic(base+".pgm", g.WritePGM); err != nil {
+		return err
+	}
+	image := filepath.Base(base) + ".pgm"
+	return writeFileAtomic(base+".yaml", func(w io.Writer) error {
+		return g.WriteYAML(w, image)
+	})
+}
+
+// writeFileAtomic writes a temporary file and renames it to path.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
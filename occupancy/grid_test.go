@@ -0,0 +1,108 @@
+package occupancy
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGridIntegrate(t *testing.T) {
+	g := NewGrid(0.1, 2) // 20x20 cells, the sensor at the origin is in the cell (10, 10)
+	if g.Width != 20 || g.Height != 20 || g.Origin != (Point{-1, -1}) {
+		t.Fatalf("grid %dx%d at %v", g.Width, g.Height, g.Origin)
+	}
+
+	g.Integrate(Pose{}, []Point{{0.55, 0.05}})
+	for x := 10; x < 15; x++ {
+		if p := g.Probability(x, 10); p >= 0.5 {
+			t.Errorf("cell (%d, 10) along the ray has the probability %g", x, p)
+		}
+	}
+	if p := g.Probability(15, 10); p <= 0.5 {
+		t.Errorf("hit cell has the probability %g", p)
+	}
+	if p := g.Probability(16, 10); p != 0.5 {
+		t.Errorf("cell behind the hit has the probability %g", p)
+	}
+
+	// the points are in the sensor frame
+	g.Integrate(Pose{Yaw: math.Pi / 2}, []Point{{0.55, 0.05}})
+	if p := g.Probability(9, 15); p <= 0.5 {
+		t.Errorf("hit of the turned sensor has the probability %g", p)
+	}
+
+	// repeated hits saturate at the limit
+	for i := 0; i < 10; i++ {
+		g.Integrate(Pose{}, []Point{{0.55, 0.05}})
+	}
+	if p, max := g.Probability(15, 10), 1-1/(1+math.Exp(LogOddsMax)); math.Abs(p-max) > 1e-6 {
+		t.Errorf("saturated cell has the probability %g, want %g", p, max)
+	}
+
+	// rays leaving the grid mark the cells inside only
+	g.Integrate(Pose{X: 0.05, Y: -0.55}, []Point{{5, 0}, {-5, -5}})
+	if p := g.Probability(19, 4); p >= 0.5 {
+		t.Errorf("cell at the edge along the ray has the probability %g", p)
+	}
+}
+
+func TestGridSave(t *testing.T) {
+	g := NewGrid(0.1, 2)
+	g.Integrate(Pose{}, []Point{{0.55, 0.05}})
+	base := filepath.Join(t.TempDir(), "map")
+	if err := g.Save(base); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(base + ".pgm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var magic, comment string
+	var width, height, max int
+	if magic, err = br.ReadString('\n'); err != nil || magic != "P5\n" {
+		t.Fatalf("PGM magic %q, %v", magic, err)
+	}
+	if comment, err = br.ReadString('\n'); err != nil || !strings.HasPrefix(comment, "#") {
+		t.Fatalf("PGM comment %q, %v", comment, err)
+	}
+	if _, err := fmt.Fscanf(br, "%d %d\n%d\n", &width, &height, &max); err != nil || width != 20 || height != 20 || max != 255 {
+		t.Fatalf("PGM of %dx%d, max %d: %v", width, height, max, err)
+	}
+	pixels, err := ioutil.ReadAll(br)
+	if err != nil || len(pixels) != width*height {
+		t.Fatalf("%d PGM pixels: %v", len(pixels), err)
+	}
+	pixel := func(x, y int) byte { return pixels[(height-1-y)*width+x] } // the top row is the largest Y
+	if v := pixel(15, 10); v >= 127 {
+		t.Errorf("hit cell is %d, want dark", v)
+	}
+	if v := pixel(12, 10); v <= 127 || v == pgmUnknown {
+		t.Errorf("free cell is %d, want light", v)
+	}
+	if v := pixel(0, 0); v != pgmUnknown {
+		t.Errorf("unknown cell is %d, want %d", v, pgmUnknown)
+	}
+
+	yaml, err := ioutil.ReadFile(base + ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"image: map.pgm", "resolution: 0.100000", "origin: [-1.000000, -1.000000, 0.0]", "negate: 0", "occupied_thresh: 0.65", "free_thresh: 0.196"} {
+		if !strings.Contains(string(yaml), line+"\n") {
+			t.Errorf("YAML without %q:\n%s", line, yaml)
+		}
+	}
+
+	files, err := filepath.Glob(base + "*")
+	if err != nil || len(files) != 2 {
+		t.Errorf("saved %v, want the PGM and the YAML only", files)
+	}
+}
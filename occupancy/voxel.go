@@ -0,0 +1,296 @@
+package occupancy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// VoxelState is the state of a voxel of a VoxelMap.
+type VoxelState int
+
+const (
+	Unknown  VoxelState = iota // never observed
+	Free                       // more likely free
+	Occupied                   // more likely occupied
+)
+
+func (s VoxelState) String() string {
+	switch s {
+	case Free:
+		return "free"
+	case Occupied:
+		return "occupied"
+	}
+	return "unknown"
+}
+
+// VoxelKey identifies a voxel, it is the position divided by the resolution
+// and rounded down.
+type VoxelKey [3]int32
+
+// VoxelMap is a probabilistic 3D occupancy map in the manner of OctoMap. Only
+// observed voxels are stored, they hold the log-odds of being occupied and use
+// the same updates and limits as Grid.
+type VoxelMap struct {
+	Resolution float64 // voxel edge length in the units of the points
+	voxels     map[VoxelKey]float32
+}
+
+// NewVoxelMap creates an empty map.
+func NewVoxelMap(resolution float64) *VoxelMap {
+	return &VoxelMap{Resolution: resolution, voxels: make(map[VoxelKey]float32)}
+}
+
+// Key returns the key of the voxel containing the point.
+func (m *VoxelMap) Key(p spatial.Point) VoxelKey {
+	return VoxelKey{
+		int32(math.Floor(p.X / m.Resolution)),
+		int32(math.Floor(p.Y / m.Resolution)),
+		int32(math.Floor(p.Z / m.Resolution)),
+	}
+}
+
+// Center returns the center of the voxel.
+func (m *VoxelMap) Center(k VoxelKey) spatial.Point {
+	return spatial.Point{
+		X: (float64(k[0]) + 0.5) * m.Resolution,
+		Y: (float64(k[1]) + 0.5) * m.Resolution,
+		Z: (float64(k[2]) + 0.5) * m.Resolution,
+	}
+}
+
+// Len returns the number of observed voxels.
+func (m *VoxelMap) Len() int {
+	return len(m.voxels)
+}
+
+// Integrate adds a scan taken from the sensor origin. Voxels along each ray
+// are marked more likely free and the voxels of hits more likely occupied.
+// Every voxel is updated at most once per scan and a hit wins over a miss, so
+// dense scans do not carve away the surfaces they hit at grazing angles. Rays
+// longer than maxRange (if positive) mark free space up to maxRange only.
+func (m *VoxelMap) Integrate(origin spatial.Point, points []spatial.Point, maxRange float64) {
+	free := make(map[VoxelKey]bool)
+	hits := make(map[VoxelKey]bool)
+	for _, p := range points {
+		ray := p.Sub(origin)
+		if maxRange > 0 && ray.Norm() > maxRange {
+			m.traceRay(origin, origin.Add(ray.Scale(maxRange/ray.Norm())), free)
+			continue
+		}
+		m.traceRay(origin, p, free)
+		hits[m.Key(p)] = true
+	}
+
+	for k := range free {
+		if !hits[k] {
+			m.update(k, LogOddsMiss)
+		}
+	}
+	for k := range hits {
+		m.update(k, LogOddsHit)
+	}
+}
+
+func (m *VoxelMap) update(k VoxelKey, delta float32) {
+	l := m.voxels[k] + delta
+	if l < LogOddsMin {
+		l = LogOddsMin
+	} else if l > LogOddsMax {
+		l = LogOddsMax
+	}
+	m.voxels[k] = l
+}
+
+// traceRay adds voxels crossed by the segment from -> to, excluding the voxel
+// of to, to the set (Amanatides & Woo voxel traversal).
+func (m *VoxelMap) traceRay(from, to spatial.Point, set map[VoxelKey]bool) {
+	key, end := m.Key(from), m.Key(to)
+	start := [3]float64{from.X, from.Y, from.Z}
+	dir := [3]float64{to.X - from.X, to.Y - from.Y, to.Z - from.Z}
+
+	// tMax is the ray parameter of the next voxel boundary along the axis,
+	// tDelta the parameter length of a voxel, the ray ends at 1
+	var step [3]int32
+	var tMax, tDelta [3]float64
+	for a := 0; a < 3; a++ {
+		switch {
+		case dir[a] > 0:
+			step[a] = 1
+			tMax[a] = ((float64(key[a])+1)*m.Resolution - start[a]) / dir[a]
+			tDelta[a] = m.Resolution / dir[a]
+		case dir[a] < 0:
+			step[a] = -1
+			tMax[a] = (float64(key[a])*m.Resolution - start[a]) / dir[a]
+			tDelta[a] = -m.Resolution / dir[a]
+		default:
+			tMax[a] = math.Inf(1)
+		}
+	}
+
+	for key != end {
+		set[key] = true
+		a := 0
+		if tMax[1] < tMax[a] {
+			a = 1
+		}
+		if tMax[2] < tMax[a] {
+			a = 2
+		}
+		if tMax[a] > 1 {
+			break // rounding, the end voxel was missed by a hair
+		}
+		key[a] += step[a]
+		tMax[a] += tDelta[a]
+	}
+}
+
+// Probability returns the occupancy probability of the voxel containing the
+// point, 0.5 if it was never observed.
+func (m *VoxelMap) Probability(p spatial.Point) float64 {
+	return 1 - 1/(1+math.Exp(float64(m.voxels[m.Key(p)])))
+}
+
+// State returns the state of the voxel containing the point.
+func (m *VoxelMap) State(p spatial.Point) VoxelState {
+	l, ok := m.voxels[m.Key(p)]
+	switch {
+	case !ok:
+		return Unknown
+	case l > 0:
+		return Occupied
+	}
+	return Free
+}
+
+// keys returns keys of observed voxels in a stable order.
+func (m *VoxelMap) keys() []VoxelKey {
+	keys := make([]VoxelKey, 0, len(m.voxels))
+	for k := range m.voxels {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a[2] != b[2] {
+			return a[2] < b[2]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[0] < b[0]
+	})
+	return keys
+}
+
+// Occupied returns centers of occupied voxels.
+func (m *VoxelMap) Occupied() []spatial.Point {
+	var centers []spatial.Point
+	for _, k := range m.keys() {
+		if m.voxels[k] > 0 {
+			centers = append(centers, m.Center(k))
+		}
+	}
+	return centers
+}
+
+const voxelMapMagic = "# lidar-tools voxel map"
+
+// WriteTo writes the map in the binary map format: a text header with the
+// resolution and the number of voxels, followed by a record for every
+// observed voxel of int32 x, y, z key and float32 log-odds, little-endian.
+func (m *VoxelMap) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	n, _ := fmt.Fprintf(bw, "%s\nresolution %g\nvoxels %d\ndata\n", voxelMapMagic, m.Resolution, len(m.voxels))
+	var buf [16]byte
+	for _, k := range m.keys() {
+		for a := 0; a < 3; a++ {
+			binary.LittleEndian.PutUint32(buf[4*a:], uint32(k[a]))
+		}
+		binary.LittleEndian.PutUint32(buf[12:], math.Float32bits(m.voxels[k]))
+		bw.Write(buf[:])
+		n += len(buf)
+	}
+	return int64(n), bw.Flush()
+}
+
+// ReadVoxelMap reads a map written by WriteTo.
+func ReadVoxelMap(r io.Reader) (*VoxelMap, error) {
+	br := bufio.NewReader(r)
+	header := make(map[string]string)
+	for first := true; ; first = false {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("voxel map: header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if first {
+			if line != voxelMapMagic {
+				return nil, errors.New("voxel map: not a lidar-tools voxel map")
+			}
+			continue
+		}
+		if line == "data" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("voxel map: bad header line %q", line)
+		}
+		header[fields[0]] = fields[1]
+	}
+
+	resolution, err := strconv.ParseFloat(header["resolution"], 64)
+	if err != nil || resolution <= 0 {
+		return nil, fmt.Errorf("voxel map: bad resolution %q", header["resolution"])
+	}
+	n, err := strconv.Atoi(header["voxels"])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("voxel map: bad voxel count %q", header["voxels"])
+	}
+
+	m := NewVoxelMap(resolution)
+	var buf [16]byte
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return nil, fmt.Errorf("voxel map: voxel %d: %v", i, err)
+		}
+		var k VoxelKey
+		for a := 0; a < 3; a++ {
+			k[a] = int32(binary.LittleEndian.Uint32(buf[4*a:]))
+		}
+		m.voxels[k] = math.Float32frombits(binary.LittleEndian.Uint32(buf[12:]))
+	}
+	return m, nil
+}
+
+// Save writes the map to the file atomically.
+func (m *VoxelMap) Save(path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := m.WriteTo(w)
+		return err
+	})
+}
+
+// LoadVoxelMap reads a map from the file.
+func LoadVoxelMap(path string) (*VoxelMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ReadVoxelMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
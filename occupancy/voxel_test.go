@@ -0,0 +1,148 @@
+package occupancy
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knei-knurow/lidar-tools/spatial"
+)
+
+// along returns the point x along the X axis of the voxels (0, 0, 0).
+func along(x float64) spatial.Point {
+	return spatial.Point{X: x, Y: 0.05, Z: 0.05}
+}
+
+func TestVoxelMapIntegrate(t *testing.T) {
+	m := NewVoxelMap(0.1)
+	origin := along(0.05)
+
+	m.Integrate(origin, []spatial.Point{along(0.55)}, 0)
+	for x := 0.05; x < 0.5; x += 0.1 {
+		if s := m.State(along(x)); s != Free {
+			t.Errorf("voxel at %g along the ray is %v", x, s)
+		}
+	}
+	if s := m.State(along(0.55)); s != Occupied {
+		t.Errorf("hit voxel is %v", s)
+	}
+	if s := m.State(along(0.65)); s != Unknown {
+		t.Errorf("voxel behind the hit is %v", s)
+	}
+
+	// the second ray crosses the voxel hit by the first one in the same scan
+	m = NewVoxelMap(0.1)
+	m.Integrate(origin, []spatial.Point{along(0.35), along(0.55)}, 0)
+	if p, want := m.Probability(along(0.35)), 1-1/(1+math.Exp(LogOddsHit)); math.Abs(p-want) > 1e-6 {
+		t.Errorf("voxel hit and crossed has the probability %g, want %g of a single hit", p, want)
+	}
+	if p, want := m.Probability(along(0.25)), 1-1/(1+math.Exp(LogOddsMiss)); math.Abs(p-want) > 1e-6 {
+		t.Errorf("voxel crossed twice has the probability %g, want %g of a single miss", p, want)
+	}
+
+	// long rays mark free space up to the range only
+	m = NewVoxelMap(0.1)
+	m.Integrate(origin, []spatial.Point{along(1.05)}, 0.5)
+	if s := m.State(along(0.45)); s != Free {
+		t.Errorf("voxel within the range is %v", s)
+	}
+	for _, x := range []float64{0.65, 1.05} {
+		if s := m.State(along(x)); s != Unknown {
+			t.Errorf("voxel at %g beyond the range is %v", x, s)
+		}
+	}
+	if m.Len() != 5 {
+		t.Errorf("%d voxels observed, want 5", m.Len())
+	}
+}
+
+func TestTraceRay(t *testing.T) {
+	m := NewVoxelMap(0.1)
+	from, to := spatial.Point{X: 0.01, Y: 0.02, Z: 0.03}, spatial.Point{X: -0.47, Y: 0.93, Z: 0.31}
+	set := make(map[VoxelKey]bool)
+	m.traceRay(from, to, set)
+
+	start, end := m.Key(from), m.Key(to)
+	if !set[start] || set[end] {
+		t.Errorf("the start voxel is traced %v, the end voxel %v, want only the start", set[start], set[end])
+	}
+	// the voxels form a 6-connected path from the start to a neighbor of the end
+	steps := 0
+	for a := 0; a < 3; a++ {
+		steps += int(math.Abs(float64(end[a] - start[a])))
+	}
+	if len(set) != steps {
+		t.Errorf("traced %d voxels, want %d", len(set), steps)
+	}
+	for k := range set {
+		// every voxel lies within the bounding box of the path
+		for a := 0; a < 3; a++ {
+			lo, hi := start[a], end[a]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if k[a] < lo || k[a] > hi {
+				t.Errorf("voxel %v is off the ray", k)
+			}
+		}
+	}
+	reachesEnd := false
+	for a := 0; a < 3; a++ {
+		for _, d := range []int32{-1, 1} {
+			k := end
+			k[a] += d
+			reachesEnd = reachesEnd || set[k]
+		}
+	}
+	if !reachesEnd {
+		t.Error("no traced voxel neighbors the end voxel")
+	}
+
+	set = make(map[VoxelKey]bool)
+	m.traceRay(from, spatial.Point{X: 0.09, Y: 0.09, Z: 0.09}, set)
+	if len(set) != 0 {
+		t.Errorf("a ray within a voxel traced %v", set)
+	}
+}
+
+func TestVoxelMapRoundTrip(t *testing.T) {
+	m := NewVoxelMap(0.25)
+	m.Integrate(spatial.Point{}, []spatial.Point{{X: 2, Y: -1, Z: 0.5}, {X: -3, Y: 0.2, Z: -0.7}, {X: 0.1, Y: 4, Z: 1}}, 0)
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadVoxelMap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Resolution != m.Resolution || !reflect.DeepEqual(read.voxels, m.voxels) {
+		t.Errorf("read %d voxels of %g, want %d of %g", read.Len(), read.Resolution, m.Len(), m.Resolution)
+	}
+
+	path := filepath.Join(t.TempDir(), "room.vox")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadVoxelMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Occupied(), m.Occupied()) {
+		t.Errorf("loaded occupied voxels %v, want %v", loaded.Occupied(), m.Occupied())
+	}
+
+	for _, data := range []string{
+		"# not a voxel map\n",
+		voxelMapMagic + "\nresolution 0\nvoxels 0\ndata\n",
+		voxelMapMagic + "\nresolution 0.1\nvoxels 2\ndata\n0123456789abcdef",
+	} {
+		if _, err := ReadVoxelMap(strings.NewReader(data)); err == nil {
+			t.Errorf("ReadVoxelMap(%q) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package odometry estimates the trajectory of a moving lidar by registering
+// every scan against a map built from the earlier scans.
+package odometry
+
+import (
+	"math"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// Options configure the odometry. Distances are in millimeters.
+type Options struct {
+	InitTime     time.Duration    // scans of the first InitTime form the initial map, the rig must be at rest
+	ScanVoxel    float64          // voxel size scans are downsampled to before registration
+	MapVoxel     float64          // voxel size of the map
+	MapRange     float64          // map points farther from the rig are not registered against
+	RebuildScans int              // registered scans between rebuilds of the registration target
+	MinPoints    int              // scans with fewer points after downsampling are not registered
+	MinFitness   float64          // registrations with a lower fitness are rejected
+	ICP          cloud.ICPOptions // registration
+}
+
+// DefaultOptions are suitable for sync's clouds of a rig walked through a
+// building.
+var DefaultOptions = Options{
+	InitTime:     40 * time.Second,
+	ScanVoxel:    50,
+	MapVoxel:     100,
+	MapRange:     20000,
+	RebuildScans: 10,
+	MinPoints:    50,
+	MinFitness:   0.5,
+	ICP: cloud.ICPOptions{
+		MaxIterations:   20,
+		MaxDistance:     300,
+		Tolerance:       1e-3,
+		NormalNeighbors: 12,
+		Damping:         0.1,
+	},
+}
+
+// Pose is the pose of the rig at a time, it moves points of the rig frame to
+// the map frame.
+type Pose struct {
+	Time time.Time
+	cloud.Transform
+}
+
+// voxel is a map voxel, its point is the centroid of the points in it.
+type voxel struct {
+	sum   cloud.Point
+	count int
+}
+
+// Odometry registers scans against the map and grows the map with the
+// registered scans. The rotation between scans is predicted to be zero, so
+// scans should be rotated by the IMU attitude (sync --fusion servo+imu),
+// registration then only corrects the attitude drift. The translation is
+// predicted with a constant velocity. Registration is damped, so directions a
+// scan doesn't constrain (e.g. the height with the scan plane horizontal, or
+// the motion along a corridor with the scan plane across it) keep the
+// prediction, so the rig should be walked at a steady pace.
+type Odometry struct {
+	opts         Options
+	start        time.Time // of the first scan
+	poses        []Pose
+	registered   []Pose // the latest registered poses, the velocity is estimated from
+	voxels       map[[3]int64]*voxel
+	order        [][3]int64 // keeps the map deterministic
+	target       *cloud.Target
+	sinceRebuild int
+	Rejected     int // scans whose registration failed or was rejected
+}
+
+// New returns the odometry with an empty map.
+func New(opts Options) *Odometry {
+	return &Odometry{opts: opts, voxels: make(map[[3]int64]*voxel)}
+}
+
+// Update registers the scan (points in the rig frame) taken at t and returns
+// the pose of the rig. ok is false if the scan could not be registered, the
+// pose is predicted then and the scan is not added to the map. If the map near
+// the rig is too sparse to register against, the scan is added to it at the
+// predicted pose instead.
+func (o *Odometry) Update(t time.Time, points []cloud.Point) (pose Pose, ok bool) {
+	scan := cloud.VoxelGrid{Size: o.opts.ScanVoxel}.Apply(points)
+
+	if len(o.poses) == 0 {
+		o.start = t
+	}
+	if t.Sub(o.start) <= o.opts.InitTime {
+		pose = Pose{Time: t, Transform: cloud.IdentityTransform}
+		o.add(scan, pose.Transform)
+		o.poses = append(o.poses, pose)
+		o.register(pose)
+		return pose, true
+	}
+
+	pose = o.predict(t)
+	if o.target == nil {
+		o.rebuild(pose.T)
+	}
+	if o.target == nil {
+		// e.g. there were no returns at rest or the rig left the map, every
+		// later scan would be rejected if the map didn't grow
+		o.poses = append(o.poses, pose)
+		o.register(pose)
+		o.add(scan, pose.Transform)
+		o.rebuild(pose.T)
+		return pose, true
+	}
+	if len(scan) >= o.opts.MinPoints {
+		result, err := cloud.ICP(scan, o.target, pose.Transform, o.opts.ICP)
+		ok = err == nil && result.Fitness >= o.opts.MinFitness
+		if ok {
+			pose.Transform = result.Transform
+		}
+	}
+	o.poses = append(o.poses, pose)
+	if !ok {
+		o.Rejected++
+		return pose, false
+	}
+
+	o.register(pose)
+	o.add(scan, pose.Transform)
+	if o.sinceRebuild++; o.sinceRebuild >= o.opts.RebuildScans {
+		o.rebuild(pose.T)
+	}
+	return pose, true
+}
+
+// velocityScans is the number of registered poses the velocity is estimated
+// from. A longer baseline keeps the registration noise out of the prediction.
+const velocityScans = 10
+
+// register keeps the registered pose for the velocity estimate.
+func (o *Odometry) register(pose Pose) {
+	if len(o.registered) == velocityScans {
+		o.registered = append(o.registered[:0], o.registered[1:]...)
+	}
+	o.registered = append(o.registered, pose)
+}
+
+// predict returns the pose at t: the latest registered rotation and the
+// translation moved with the velocity between the oldest and the latest
+// registered pose.
+func (o *Odometry) predict(t time.Time) Pose {
+	if len(o.registered) == 0 {
+		return Pose{Time: t, Transform: cloud.IdentityTransform}
+	}
+	last := o.registered[len(o.registered)-1]
+	pose := Pose{Time: t, Transform: last.Transform}
+	if len(o.registered) < 2 {
+		return pose
+	}
+	prev := o.registered[0]
+	if dt := last.Time.Sub(prev.Time); dt > 0 {
+		scale := float64(t.Sub(last.Time)) / float64(dt)
+		pose.T = last.T.Add(last.T.Sub(prev.T).Scale(scale))
+	}
+	return pose
+}
+
+// add adds the scan moved by tf to the map.
+func (o *Odometry) add(scan []cloud.Point, tf cloud.Transform) {
+	for _, p := range scan {
+		p = tf.Apply(p)
+		key := [3]int64{
+			int64(math.Floor(p.X / o.opts.MapVoxel)),
+			int64(math.Floor(p.Y / o.opts.MapVoxel)),
+			int64(math.Floor(p.Z / o.opts.MapVoxel)),
+		}
+		v, ok := o.voxels[key]
+		if !ok {
+			v = &voxel{}
+			o.voxels[key] = v
+			o.order = append(o.order, key)
+		}
+		v.sum = v.sum.Add(p)
+		v.count++
+	}
+}
+
+// rebuild replaces the registration target by the map points within MapRange
+// of the center.
+func (o *Odometry) rebuild(center cloud.Point) {
+	var points []cloud.Point
+	r2 := o.opts.MapRange * o.opts.MapRange
+	for _, key := range o.order {
+		v := o.voxels[key]
+		if p := v.sum.Scale(1 / float64(v.count)); p.Dist2(center) <= r2 {
+			points = append(points, p)
+		}
+	}
+	o.target = nil
+	if len(points) >= o.opts.MinPoints {
+		o.target = cloud.NewTarget(points, o.opts.ICP.NormalNeighbors)
+	}
+	o.sinceRebuild = 0
+}
+
+// Map returns the points of the map, one per voxel.
+func (o *Odometry) Map() []cloud.Point {
+	points := make([]cloud.Point, len(o.order))
+	for i, key := range o.order {
+		v := o.voxels[key]
+		points[i] = v.sum.Scale(1 / float64(v.count))
+	}
+	return points
+}
+
+// Trajectory returns the poses of all scans.
+func (o *Odometry) Trajectory() []Pose {
+	return o.poses
+}
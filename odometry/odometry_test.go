@@ -0,0 +1,135 @@
+package odometry
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// room is the box the synthetic scans are taken in, in millimeters.
+var roomMin, roomMax = cloud.Point{X: -4000, Y: -3000, Z: -1200}, cloud.Point{X: 6000, Y: 3500, Z: 1800}
+
+// raycast returns the distance from o along the unit vector u to the room walls.
+func raycast(o, u cloud.Point) float64 {
+	t := math.Inf(1)
+	for _, face := range [][3]float64{
+		{u.X, o.X, roomMin.X}, {u.X, o.X, roomMax.X},
+		{u.Y, o.Y, roomMin.Y}, {u.Y, o.Y, roomMax.Y},
+		{u.Z, o.Z, roomMin.Z}, {u.Z, o.Z, roomMax.Z},
+	} {
+		if face[0] != 0 {
+			if s := (face[2] - face[1]) / face[0]; s > 0 && s < t {
+				t = s
+			}
+		}
+	}
+	return t
+}
+
+// scan returns the room scanned from the pose in the rig frame, the distances
+// have gaussian noise of the standard deviation.
+func scan(pose cloud.Transform, noise float64, rng *rand.Rand) []cloud.Point {
+	var points []cloud.Point
+	for elevation := -80.0; elevation <= 80; elevation += 4 {
+		for azimuth := 0.0; azimuth < 360; azimuth += 2 {
+			sinE, cosE := math.Sincos(elevation * math.Pi / 180)
+			sinA, cosA := math.Sincos(azimuth * math.Pi / 180)
+			u := cloud.Point{X: cosE * cosA, Y: cosE * sinA, Z: sinE}
+			dist := raycast(pose.T, pose.R.MulVec(u)) + rng.NormFloat64()*noise
+			points = append(points, u.Scale(dist))
+		}
+	}
+	return points
+}
+
+// trajectory returns the pose of the rig at the time since the start: at rest
+// for a second, then walking at a steady pace while slowly turning.
+func trajectory(t time.Duration) cloud.Transform {
+	s := math.Max(0, (t - time.Second).Seconds())
+	return cloud.Transform{
+		R: cloud.RotationFromEuler(0, 0, 0.05*s),
+		T: cloud.Point{X: 500 * s, Y: -150 * s},
+	}
+}
+
+// rotationAngle returns the angle in degrees of the rotation between a and b.
+func rotationAngle(a, b cloud.Mat3) float64 {
+	d := a.Transpose().Mul(b)
+	c := (d[0][0] + d[1][1] + d[2][2] - 1) / 2
+	return math.Acos(math.Max(-1, math.Min(1, c))) * 180 / math.Pi
+}
+
+func TestOdometry(t *testing.T) {
+	opts := DefaultOptions
+	opts.InitTime = time.Second
+	o := New(opts)
+
+	rng := rand.New(rand.NewSource(1))
+	start := time.Unix(1600000000, 0)
+	for dt := time.Duration(0); dt <= 5*time.Second; dt += 100 * time.Millisecond {
+		truth := trajectory(dt)
+		pose, ok := o.Update(start.Add(dt), scan(truth, 5, rng))
+		if !ok {
+			t.Errorf("%v: scan not registered", dt)
+			continue
+		}
+		if !pose.Time.Equal(start.Add(dt)) {
+			t.Errorf("%v: pose at %v", dt, pose.Time)
+		}
+		// the damped registration lags a few centimeters behind once the rig
+		// starts walking, the predicted velocity catches up
+		if d := pose.T.Sub(truth.T).Norm(); d > 50 {
+			t.Errorf("%v: position %v, want %v", dt, pose.T, truth.T)
+		}
+		if d := rotationAngle(pose.R, truth.R); d > 0.5 {
+			t.Errorf("%v: rotation %g deg off", dt, d)
+		}
+	}
+	if o.Rejected != 0 {
+		t.Errorf("%d scans rejected", o.Rejected)
+	}
+	if n := len(o.Trajectory()); n != 51 {
+		t.Errorf("trajectory of %d poses, want 51", n)
+	}
+	for _, p := range o.Map() {
+		if p.X < roomMin.X-50 || p.X > roomMax.X+50 || p.Y < roomMin.Y-50 || p.Y > roomMax.Y+50 || p.Z < roomMin.Z-50 || p.Z > roomMax.Z+50 {
+			t.Fatalf("map point %v outside the room", p)
+		}
+	}
+}
+
+func TestWriteTUM(t *testing.T) {
+	cases := []struct {
+		pose Pose
+		want string
+	}{
+		{
+			Pose{time.Unix(1600000000, 250000000), cloud.Transform{R: cloud.RotationFromEuler(0, 0, 0), T: cloud.Point{X: 1500, Y: -20, Z: 3}}},
+			"1600000000.250000 1.500000 -0.020000 0.003000 0.000000 0.000000 0.000000 1.000000",
+		},
+		{
+			// a half turn about Z takes the branch of the largest z component
+			Pose{time.Unix(1600000001, 0), cloud.Transform{R: cloud.RotationFromEuler(0, 0, math.Pi)}},
+			"1600000001.000000 0.000000 0.000000 0.000000 0.000000 0.000000 1.000000 0.000000",
+		},
+		{
+			Pose{time.Unix(1600000002, 0), cloud.Transform{R: cloud.RotationFromEuler(math.Pi/2, 0, 0)}},
+			fmt.Sprintf("1600000002.000000 0.000000 0.000000 0.000000 %.6f 0.000000 0.000000 %.6f", math.Sqrt(0.5), math.Sqrt(0.5)),
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := WriteTUM(&buf, c.pose); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != c.want {
+			t.Errorf("WriteTUM() = %q, want %q", got, c.want)
+		}
+	}
+}
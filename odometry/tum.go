@@ -0,0 +1,45 @@
+package odometry
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// The TUM trajectory format has one "timestamp tx ty tz qx qy qz qw" line per
+// pose: seconds, the translation in meters and the rotation quaternion.
+
+// WriteTUM writes the pose as a line of a TUM trajectory.
+func WriteTUM(w io.Writer, pose Pose) error {
+	qw, qx, qy, qz := quaternion(pose.R)
+	t := pose.T.Scale(0.001)
+	_, err := fmt.Fprintf(w, "%.6f %.6f %.6f %.6f %.6f %.6f %.6f %.6f\n",
+		float64(pose.Time.UnixNano())/1e9, t.X, t.Y, t.Z, qx, qy, qz, qw)
+	return err
+}
+
+// quaternion returns the unit quaternion of the rotation.
+func quaternion(m cloud.Mat3) (w, x, y, z float64) {
+	// the largest of the four components is computed first for stability
+	trace := m[0][0] + m[1][1] + m[2][2]
+	switch {
+	case trace > 0:
+		s := 2 * math.Sqrt(trace+1)
+		w, x, y, z = s/4, (m[2][1]-m[1][2])/s, (m[0][2]-m[2][0])/s, (m[1][0]-m[0][1])/s
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		w, x, y, z = (m[2][1]-m[1][2])/s, s/4, (m[0][1]+m[1][0])/s, (m[0][2]+m[2][0])/s
+	case m[1][1] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		w, x, y, z = (m[0][2]-m[2][0])/s, (m[0][1]+m[1][0])/s, s/4, (m[1][2]+m[2][1])/s
+	default:
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		w, x, y, z = (m[1][0]-m[0][1])/s, (m[0][2]+m[2][0])/s, (m[1][2]+m[2][1])/s, s/4
+	}
+	if w < 0 {
+		w, x, y, z = -w, -x, -y, -z
+	}
+	return w, x, y, z
+}
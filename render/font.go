@@ -0,0 +1,80 @@
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+// Glyphs of a 5x7 bitmap font, only characters used in annotations are defined,
+// others are drawn as spaces.
+var glyphs = map[rune][7]string{
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'-': {"     ", "     ", "     ", " ### ", "     ", "     ", "     "},
+	'.': {"     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "},
+	'a': {"     ", "     ", " ### ", "    #", " ####", "#   #", " ####"},
+	'c': {"     ", "     ", " ### ", "#    ", "#    ", "#   #", " ### "},
+	'd': {"    #", "    #", " ## #", "#  ##", "#   #", "#   #", " ####"},
+	'e': {"     ", "     ", " ### ", "#   #", "#####", "#    ", " ### "},
+	'g': {"     ", " ####", "#   #", "#   #", " ####", "    #", " ### "},
+	'h': {"#    ", "#    ", "# ## ", "##  #", "#   #", "#   #", "#   #"},
+	'i': {"  #  ", "     ", " ##  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'k': {"#    ", "#    ", "#  # ", "# #  ", "##   ", "# #  ", "#  # "},
+	'm': {"     ", "     ", "## # ", "# # #", "# # #", "#   #", "#   #"},
+	'n': {"     ", "     ", "# ## ", "##  #", "#   #", "#   #", "#   #"},
+	'o': {"     ", "     ", " ### ", "#   #", "#   #", "#   #", " ### "},
+	'p': {"     ", "     ", "#### ", "#   #", "#### ", "#    ", "#    "},
+	'r': {"     ", "     ", "# ## ", "##  #", "#    ", "#    ", "#    "},
+	's': {"     ", "     ", " ####", "#    ", " ### ", "    #", "#### "},
+	't': {" #   ", " #   ", "###  ", " #   ", " #   ", " #  #", "  ## "},
+	'v': {"     ", "     ", "#   #", "#   #", "#   #", " # # ", "  #  "},
+}
+
+// Font metrics in pixels (before scaling).
+const (
+	glyphHeight  = 7
+	glyphAdvance = 6
+	fontScale    = 2
+)
+
+// textWidth returns the width of the drawn text in pixels.
+func textWidth(s string) int {
+	return len([]rune(s)) * glyphAdvance * fontScale
+}
+
+// textHeight is the height of the drawn text in pixels.
+const textHeight = glyphHeight * fontScale
+
+// drawText draws s with its top-left corner at (x, y).
+func drawText(img *image.RGBA, x, y int, s string, c color.Color) {
+	for _, r := range s {
+		glyph := glyphs[r]
+		for row, line := range glyph {
+			for col, ch := range line {
+				if ch != '#' {
+					continue
+				}
+				fillRect(img, x+col*fontScale, y+row*fontScale, fontScale, fontScale, c)
+			}
+		}
+		x += glyphAdvance * fontScale
+	}
+}
+
+// fillRect fills the rectangle clipped to the image.
+func fillRect(img *image.RGBA, x, y, w, h int, c color.Color) {
+	r := image.Rect(x, y, x+w, y+h).Intersect(img.Bounds())
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			img.Set(px, py, c)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package render draws point clouds to images without a GPU, so previews can be
+// produced by CI jobs and field laptops.
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/knei-knurow/lidar-tools/cloud"
+)
+
+// View is the direction the cloud is looked at from.
+type View int
+
+// Views.
+const (
+	ViewTop         View = iota // from above, X right, Y up
+	ViewSide                    // from -Y, X right, Z up
+	ViewPerspective             // from Options.Azimuth and Options.Elevation
+)
+
+var viewNames = []string{"top", "side", "perspective"}
+
+func (v View) String() string {
+	if v >= 0 && int(v) < len(viewNames) {
+		return viewNames[v]
+	}
+	return fmt.Sprintf("View(%d)", int(v))
+}
+
+// ParseView parses a view name.
+func ParseView(s string) (View, error) {
+	for i, name := range viewNames {
+		if s == name {
+			return View(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown view %q", s)
+}
+
+// ColorMode is the value points are colorized by.
+type ColorMode int
+
+// Color modes.
+const (
+	ColorHeight ColorMode = iota // Z coordinate
+	ColorRange                   // distance from the origin (the lidar)
+)
+
+var colorModeNames = []string{"height", "range"}
+
+func (m ColorMode) String() string {
+	if m >= 0 && int(m) < len(colorModeNames) {
+		return colorModeNames[m]
+	}
+	return fmt.Sprintf("ColorMode(%d)", int(m))
+}
+
+// ParseColorMode parses a color mode name.
+func ParseColorMode(s string) (ColorMode, error) {
+	for i, name := range colorModeNames {
+		if s == name {
+			return ColorMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown color mode %q", s)
+}
+
+// Options of rendering.
+type Options struct {
+	Width, Height int       // image size in pixels
+	View          View      //
+	Color         ColorMode //
+	PointSize     int       // side of the square drawn for each point, in pixels
+	Azimuth       float64   // perspective camera azimuth in degrees, 0 is looking from +X
+	Elevation     float64   // perspective camera elevation in degrees
+	Unit          float64   // meters per cloud unit, e.g. 0.001 for millimeters
+}
+
+// DefaultOptions are the options used by cloudtool.
+var DefaultOptions = Options{
+	Width:     1024,
+	Height:    768,
+	View:      ViewTop,
+	Color:     ColorHeight,
+	PointSize: 2,
+	Azimuth:   -60,
+	Elevation: 30,
+	Unit:      0.001,
+}
+
+// Layout in pixels.
+const (
+	margin      = 16
+	legendBand  = 40 // band at the bottom reserved for the scale bar and color legend
+	legendWidth = 160
+	legendBarH  = 8
+)
+
+var (
+	background = color.RGBA{0x11, 0x11, 0x11, 0xff}
+	foreground = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+)
+
+// projected is a point in view coordinates.
+type projected struct {
+	u, v  float64 // right, up
+	depth float64 // distance along the view direction, lower is nearer
+	value float64 // colorized value
+}
+
+// Render draws the cloud.
+func Render(c *cloud.Cloud, opts Options) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	fillRect(img, 0, 0, opts.Width, opts.Height, background)
+	drawText(img, margin, margin, opts.View.String(), foreground)
+
+	points := project(c, opts)
+	if len(points) == 0 {
+		return img
+	}
+
+	// fit the projected points into the image preserving the aspect ratio
+	umin, umax := points[0].u, points[0].u
+	vmin, vmax := points[0].v, points[0].v
+	for _, p := range points {
+		umin, umax = math.Min(umin, p.u), math.Max(umax, p.u)
+		vmin, vmax = math.Min(vmin, p.v), math.Max(vmax, p.v)
+	}
+	areaW := float64(opts.Width - 2*margin)
+	areaH := float64(opts.Height - 2*margin - legendBand)
+	scale := math.Min(areaW/math.Max(umax-umin, 1e-9), areaH/math.Max(vmax-vmin, 1e-9))
+	offsetX := margin + (areaW-(umax-umin)*scale)/2
+	offsetY := margin + (areaH-(vmax-vmin)*scale)/2
+
+	lo, hi := valueRange(points)
+	zbuf := make([]float64, opts.Width*opts.Height)
+	for i := range zbuf {
+		zbuf[i] = math.Inf(1)
+	}
+
+	size := opts.PointSize
+	if size < 1 {
+		size = 1
+	}
+	for _, p := range points {
+		x := int(offsetX+(p.u-umin)*scale) - size/2
+		y := int(offsetY+(vmax-p.v)*scale) - size/2
+		col := colormap((p.value - lo) / math.Max(hi-lo, 1e-9))
+		for py := y; py < y+size; py++ {
+			for px := x; px < x+size; px++ {
+				if px < 0 || py < 0 || px >= opts.Width || py >= opts.Height {
+					continue
+				}
+				if i := py*opts.Width + px; p.depth < zbuf[i] {
+					zbuf[i] = p.depth
+					img.SetRGBA(px, py, col)
+				}
+			}
+		}
+	}
+
+	if opts.View != ViewPerspective { // the scale differs with depth in perspective
+		drawScaleBar(img, opts.Unit/scale)
+	}
+	drawLegend(img, opts.Color.String(), lo*opts.Unit, hi*opts.Unit)
+	return img
+}
+
+// project transforms points to view coordinates.
+func project(c *cloud.Cloud, opts Options) []projected {
+	points := make([]projected, 0, c.Len())
+	value := func(p cloud.Point) float64 {
+		if opts.Color == ColorRange {
+			return p.Norm()
+		}
+		return p.Z
+	}
+
+	switch opts.View {
+	case ViewTop:
+		for _, p := range c.Points {
+			points = append(points, projected{p.X, p.Y, -p.Z, value(p)})
+		}
+	case ViewSide:
+		for _, p := range c.Points {
+			points = append(points, projected{p.X, p.Z, p.Y, value(p)})
+		}
+	case ViewPerspective:
+		min, max := c.Bounds()
+		center := min.Add(max).Scale(0.5)
+		radius := math.Max(max.Sub(min).Norm()/2, 1e-9)
+
+		az, el := opts.Azimuth*math.Pi/180, opts.Elevation*math.Pi/180
+		dir := cloud.Point{X: math.Cos(el) * math.Cos(az), Y: math.Cos(el) * math.Sin(az), Z: math.Sin(el)}
+		eye := center.Add(dir.Scale(2.5 * radius))
+
+		forward := dir.Scale(-1)
+		right := forward.Cross(cloud.Point{Z: 1})
+		if right.Norm() < 1e-9 { // looking straight up or down
+			right = cloud.Point{X: 1}
+		}
+		right = right.Scale(1 / right.Norm())
+		up := right.Cross(forward)
+
+		for _, p := range c.Points {
+			d := p.Sub(eye)
+			z := d.Dot(forward)
+			if z <= 1e-6*radius {
+				continue
+			}
+			points = append(points, projected{d.Dot(right) / z, d.Dot(up) / z, z, value(p)})
+		}
+	}
+	return points
+}
+
+// valueRange returns the 2nd and 98th percentile of values, so a few outliers
+// do not wash out the colors.
+func valueRange(points []projected) (lo, hi float64) {
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.value
+	}
+	sort.Float64s(values)
+	return values[len(values)*2/100], values[(len(values)-1)*98/100]
+}
+
+// colormap maps t in [0, 1] from blue (low) to red (high).
+func colormap(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	h := (1 - t) * 0.7 * 6
+	x := 1 - math.Abs(math.Mod(h, 2)-1)
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	default:
+		r, b = x, 1
+	}
+	return color.RGBA{uint8(r * 255), uint8(g * 255), uint8(b * 255), 0xff}
+}
+
+// drawScaleBar draws a bar of a round length at the bottom-left corner.
+func drawScaleBar(img *image.RGBA, metersPerPixel float64) {
+	target := float64(img.Bounds().Dx()) / 5 * metersPerPixel
+	base := math.Pow(10, math.Floor(math.Log10(target)))
+	length := base
+	if 5*base <= target {
+		length = 5 * base
+	} else if 2*base <= target {
+		length = 2 * base
+	}
+
+	w := int(math.Round(length / metersPerPixel))
+	x := margin
+	y := img.Bounds().Dy() - margin - legendBarH
+	fillRect(img, x, y, w, legendBarH/2, foreground)
+	fillRect(img, x, y-legendBarH/2, 2, legendBarH, foreground)
+	fillRect(img, x+w-2, y-legendBarH/2, 2, legendBarH, foreground)
+	drawText(img, x, y-legendBarH/2-textHeight-4, formatLength(length), foreground)
+}
+
+// drawLegend draws the colormap with the value range at the bottom-right corner.
+func drawLegend(img *image.RGBA, name string, lo, hi float64) {
+	x := img.Bounds().Dx() - margin - legendWidth
+	y := img.Bounds().Dy() - margin - legendBarH
+	for i := 0; i < legendWidth; i++ {
+		fillRect(img, x+i, y, 1, legendBarH, colormap(float64(i)/(legendWidth-1)))
+	}
+
+	label := fmt.Sprintf("%s %s .. %s", name, formatLength(lo), formatLength(hi))
+	drawText(img, x+legendWidth-textWidth(label), y-textHeight-4, label, foreground)
+}
+
+// formatLength formats meters using a suitable unit.
+func formatLength(m float64) string {
+	a := math.Abs(m)
+	switch {
+	case a >= 1000:
+		return fmt.Sprintf("%.3g km", m/1000)
+	case a >= 1 || a == 0:
+		return fmt.Sprintf("%.3g m", m)
+	case a >= 0.01:
+		return fmt.Sprintf("%.3g cm", m*100)
+	default:
+		return fmt.Sprintf("%.3g mm", m*1000)
+	}
+}
+
+// WritePNG saves the image.
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,332 @@
+// Package rosbag writes ROS1 bag files (format version 2.0), so recorded sessions
+// can be opened in rviz, Foxglove Studio and other ROS tools without a ROS install.
+//
+// Format specification: http://wiki.ros.org/Bags/Format/2.0
+package rosbag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Record opcodes.
+const (
+	opMessageData = 0x02
+	opBagHeader   = 0x03
+	opIndexData   = 0x04
+	opChunk       = 0x05
+	opChunkInfo   = 0x06
+	opConnection  = 0x07
+)
+
+const (
+	bagMagic = "#ROSBAG V2.0\n"
+
+	// bagHeaderSize is the size of the whole bag header record. The record is
+	// padded, so it can be rewritten in place when the bag is closed.
+	bagHeaderSize = 4096
+
+	// DefaultChunkSize is the uncompressed chunk size after which a new chunk is started.
+	DefaultChunkSize = 768 * 1024
+)
+
+// Connection describes a topic and its message type.
+type Connection struct {
+	ID         uint32
+	Topic      string
+	Type       string // e.g. "sensor_msgs/PointCloud2"
+	MD5Sum     string
+	Definition string // full message definition text
+}
+
+type indexEntry struct {
+	time   time.Time
+	offset uint32 // relative to the beginning of the chunk data
+}
+
+// Writer writes a bag file. Messages are buffered in uncompressed chunks. Close
+// must be called to write the index, otherwise the bag cannot be opened.
+// It is not safe for concurrent use.
+type Writer struct {
+	w           io.WriteSeeker
+	pos         int64 // current position in w
+	connections []*Connection
+	chunkInfos  []chunkInfo
+
+	// the current chunk
+	chunk      bytes.Buffer
+	chunkIndex map[uint32][]indexEntry
+	chunkStart time.Time
+	chunkEnd   time.Time
+	written    map[uint32]bool // connections written to any chunk so far
+
+	// ChunkSize is the uncompressed chunk size after which a new chunk is started.
+	ChunkSize int
+}
+
+type chunkInfo struct {
+	pos    uint64
+	start  time.Time
+	end    time.Time
+	counts map[uint32]uint32
+}
+
+// NewWriter writes the bag file header to w and returns the Writer.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	writer := &Writer{
+		w:          w,
+		chunkIndex: make(map[uint32][]indexEntry),
+		written:    make(map[uint32]bool),
+		ChunkSize:  DefaultChunkSize,
+	}
+
+	if err := writer.write([]byte(bagMagic)); err != nil {
+		return nil, err
+	}
+	if err := writer.writeBagHeader(0); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// AddConnection registers a new topic and returns its connection.
+func (writer *Writer) AddConnection(topic string, msgType string, md5sum string, definition string) *Connection {
+	conn := &Connection{
+		ID:         uint32(len(writer.connections)),
+		Topic:      topic,
+		Type:       msgType,
+		MD5Sum:     md5sum,
+		Definition: definition,
+	}
+	writer.connections = append(writer.connections, conn)
+	return conn
+}
+
+// WriteMessage writes a serialized message received at t on the connection.
+func (writer *Writer) WriteMessage(conn *Connection, t time.Time, data []byte) error {
+	if !writer.written[conn.ID] {
+		// connection records must precede the first message of the connection
+		writeRecord(&writer.chunk, connectionHeader(conn), connectionData(conn))
+		writer.written[conn.ID] = true
+	}
+
+	if len(writer.chunkIndex) == 0 || t.Before(writer.chunkStart) {
+		writer.chunkStart = t
+	}
+	if t.After(writer.chunkEnd) {
+		writer.chunkEnd = t
+	}
+
+	writer.chunkIndex[conn.ID] = append(writer.chunkIndex[conn.ID], indexEntry{t, uint32(writer.chunk.Len())})
+	writeRecord(&writer.chunk, header(
+		field("op", []byte{opMessageData}),
+		field("conn", uint32Bytes(conn.ID)),
+		field("time", timeBytes(t)),
+	), data)
+
+	if writer.chunk.Len() >= writer.ChunkSize {
+		return writer.flushChunk()
+	}
+	return nil
+}
+
+// Close writes the last chunk and the index. It does not close the underlying writer.
+func (writer *Writer) Close() error {
+	if err := writer.flushChunk(); err != nil {
+		return err
+	}
+
+	indexPos := writer.pos
+	var buf bytes.Buffer
+	for _, conn := range writer.connections {
+		writeRecord(&buf, connectionHeader(conn), connectionData(conn))
+	}
+	for _, info := range writer.chunkInfos {
+		ids := make([]uint32, 0, len(info.counts))
+		for id := range info.counts {
+			ids = append(ids, id)
+		}
+		sortIDs(ids)
+
+		var data bytes.Buffer
+		for _, id := range ids {
+			data.Write(uint32Bytes(id))
+			data.Write(uint32Bytes(info.counts[id]))
+		}
+		writeRecord(&buf, header(
+			field("op", []byte{opChunkInfo}),
+			field("ver", uint32Bytes(1)),
+			field("chunk_pos", uint64Bytes(info.pos)),
+			field("start_time", timeBytes(info.start)),
+			field("end_time", timeBytes(info.end)),
+			field("count", uint32Bytes(uint32(len(info.counts)))),
+		), data.Bytes())
+	}
+	if err := writer.write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if _, err := writer.w.Seek(int64(len(bagMagic)), io.SeekStart); err != nil {
+		return fmt.Errorf("seek to bag header: %v", err)
+	}
+	if err := writer.writeBagHeader(uint64(indexPos)); err != nil {
+		return err
+	}
+	_, err := writer.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// flushChunk writes the current chunk followed by its index records.
+func (writer *Writer) flushChunk() error {
+	if len(writer.chunkIndex) == 0 {
+		return nil
+	}
+
+	info := chunkInfo{
+		pos:    uint64(writer.pos),
+		start:  writer.chunkStart,
+		end:    writer.chunkEnd,
+		counts: make(map[uint32]uint32),
+	}
+
+	var buf bytes.Buffer
+	writeRecord(&buf, header(
+		field("op", []byte{opChunk}),
+		field("compression", []byte("none")),
+		field("size", uint32Bytes(uint32(writer.chunk.Len()))),
+	), writer.chunk.Bytes())
+
+	ids := make([]uint32, 0, len(writer.chunkIndex))
+	for id := range writer.chunkIndex {
+		ids = append(ids, id)
+	}
+	sortIDs(ids)
+
+	for _, id := range ids {
+		entries := writer.chunkIndex[id]
+		info.counts[id] = uint32(len(entries))
+
+		var data bytes.Buffer
+		for _, entry := range entries {
+			data.Write(timeBytes(entry.time))
+			data.Write(uint32Bytes(entry.offset))
+		}
+		writeRecord(&buf, header(
+			field("op", []byte{opIndexData}),
+			field("ver", uint32Bytes(1)),
+			field("conn", uint32Bytes(id)),
+			field("count", uint32Bytes(uint32(len(entries)))),
+		), data.Bytes())
+	}
+
+	if err := writer.write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	writer.chunkInfos = append(writer.chunkInfos, info)
+	writer.chunk.Reset()
+	writer.chunkIndex = make(map[uint32][]indexEntry)
+	return nil
+}
+
+// writeBagHeader writes the bag header record padded to bagHeaderSize bytes.
+func (writer *Writer) writeBagHeader(indexPos uint64) error {
+	h := header(
+		field("op", []byte{opBagHeader}),
+		field("index_pos", uint64Bytes(indexPos)),
+		field("conn_count", uint32Bytes(uint32(len(writer.connections)))),
+		field("chunk_count", uint32Bytes(uint32(len(writer.chunkInfos)))),
+	)
+	padding := bagHeaderSize - 4 - len(h) - 4
+	if padding < 0 {
+		return errors.New("bag header too long")
+	}
+
+	var buf bytes.Buffer
+	writeRecord(&buf, h, bytes.Repeat([]byte(" "), padding))
+	_, err := writer.w.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write bag header: %v", err)
+	}
+	if writer.pos < int64(len(bagMagic)+bagHeaderSize) {
+		writer.pos = int64(len(bagMagic) + bagHeaderSize)
+	}
+	return nil
+}
+
+func (writer *Writer) write(data []byte) error {
+	n, err := writer.w.Write(data)
+	writer.pos += int64(n)
+	if err != nil {
+		return fmt.Errorf("write bag: %v", err)
+	}
+	return nil
+}
+
+func connectionHeader(conn *Connection) []byte {
+	return header(
+		field("op", []byte{opConnection}),
+		field("conn", uint32Bytes(conn.ID)),
+		field("topic", []byte(conn.Topic)),
+	)
+}
+
+func connectionData(conn *Connection) []byte {
+	return header(
+		field("topic", []byte(conn.Topic)),
+		field("type", []byte(conn.Type)),
+		field("md5sum", []byte(conn.MD5Sum)),
+		field("message_definition", []byte(conn.Definition)),
+	)
+}
+
+// writeRecord writes a record: header length, header, data length and data.
+func writeRecord(buf *bytes.Buffer, header []byte, data []byte) {
+	buf.Write(uint32Bytes(uint32(len(header))))
+	buf.Write(header)
+	buf.Write(uint32Bytes(uint32(len(data))))
+	buf.Write(data)
+}
+
+// header concatenates header fields.
+func header(fields ...[]byte) []byte {
+	return bytes.Join(fields, nil)
+}
+
+// field encodes a single header field: length of "name=value", name, '=' and value.
+func field(name string, value []byte) []byte {
+	f := make([]byte, 0, 4+len(name)+1+len(value))
+	f = append(f, uint32Bytes(uint32(len(name)+1+len(value)))...)
+	f = append(f, name...)
+	f = append(f, '=')
+	return append(f, value...)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+// timeBytes encodes t as ROS time: seconds and nanoseconds as uint32.
+func timeBytes(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return b
+}
+
+func sortIDs(ids []uint32) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
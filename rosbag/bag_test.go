@@ -0,0 +1,235 @@
+package rosbag
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// record is a record read back from a bag.
+type record struct {
+	pos    int               // of the record in the data it was read from
+	fields map[string][]byte // header fields
+	data   []byte
+}
+
+func (r *record) op() byte {
+	return r.fields["op"][0]
+}
+
+func (r *record) uint32(name string) uint32 {
+	return binary.LittleEndian.Uint32(r.fields[name])
+}
+
+func (r *record) uint64(name string) uint64 {
+	return binary.LittleEndian.Uint64(r.fields[name])
+}
+
+// parseTime decodes ROS time.
+func parseTime(b []byte) time.Time {
+	return time.Unix(int64(binary.LittleEndian.Uint32(b)), int64(binary.LittleEndian.Uint32(b[4:])))
+}
+
+// parseFields decodes header fields.
+func parseFields(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+	fields := make(map[string][]byte)
+	for len(b) > 0 {
+		if len(b) < 4 || int(binary.LittleEndian.Uint32(b)) > len(b)-4 {
+			t.Fatalf("truncated header field %q", b)
+		}
+		n := binary.LittleEndian.Uint32(b)
+		f := b[4 : 4+n]
+		b = b[4+n:]
+		for i, c := range f {
+			if c == '=' {
+				fields[string(f[:i])] = f[i+1:]
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// parseRecords decodes the records in b, pos is the position of b in the file.
+func parseRecords(t *testing.T, b []byte, pos int) []record {
+	t.Helper()
+	var records []record
+	for start := 0; start < len(b); {
+		if len(b)-start < 4 {
+			t.Fatalf("record at %d: truncated", pos+start)
+		}
+		i := start
+		n := int(binary.LittleEndian.Uint32(b[i:]))
+		fields := parseFields(t, b[i+4:i+4+n])
+		i += 4 + n
+		n = int(binary.LittleEndian.Uint32(b[i:]))
+		records = append(records, record{pos + start, fields, b[i+4 : i+4+n]})
+		start = i + 4 + n
+	}
+	return records
+}
+
+func TestWriterReadBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.bag")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer, err := NewWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.ChunkSize = 200 // a few messages per chunk
+	conns := []*Connection{
+		writer.AddConnection("/a", "std_msgs/String", "992ce8a1687cec8c8bd883ec73ca41d1", "string data\n"),
+		writer.AddConnection("/b", "std_msgs/Empty", "d41d8cd98f00b204e9800998ecf8427e", ""),
+		writer.AddConnection("/unused", "std_msgs/Empty", "d41d8cd98f00b204e9800998ecf8427e", ""),
+	}
+	type message struct {
+		conn uint32
+		time time.Time
+		data string
+	}
+	var written []message
+	start := time.Unix(1600000000, 500)
+	for i := 0; i < 20; i++ {
+		m := message{uint32(i % 3 % 2), start.Add(time.Duration(i) * 10 * time.Millisecond), string(rune('a' + i))}
+		if err := writer.WriteMessage(conns[m.conn], m.time, []byte(m.data)); err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, m)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:len(bagMagic)]) != bagMagic {
+		t.Fatalf("magic %q", b[:len(bagMagic)])
+	}
+	headerEnd := len(bagMagic) + bagHeaderSize
+	bagHeader := parseRecords(t, b[len(bagMagic):headerEnd], len(bagMagic))
+	if len(bagHeader) != 1 || bagHeader[0].op() != opBagHeader {
+		t.Fatalf("bag header of %d records", len(bagHeader))
+	}
+	h := bagHeader[0]
+	indexPos := int(h.uint64("index_pos"))
+	if indexPos <= headerEnd || indexPos >= len(b) {
+		t.Fatalf("index at %d of %d bytes", indexPos, len(b))
+	}
+	if n := h.uint32("conn_count"); n != 3 {
+		t.Errorf("conn_count %d, want 3", n)
+	}
+
+	// chunks followed by their index records
+	var read []message
+	chunks := make(map[uint64]map[uint32]uint32) // position to message counts
+	var chunk *record
+	seen := make(map[uint32]bool) // connection records, written before the first message of each
+	for _, r := range parseRecords(t, b[headerEnd:indexPos], headerEnd) {
+		r := r
+		switch r.op() {
+		case opChunk:
+			if c := string(r.fields["compression"]); c != "none" || int(r.uint32("size")) != len(r.data) {
+				t.Fatalf("chunk at %d: compression %q, size %d of %d", r.pos, c, r.uint32("size"), len(r.data))
+			}
+			chunk = &r
+			chunks[uint64(r.pos)] = make(map[uint32]uint32)
+			for _, m := range parseRecords(t, r.data, 0) {
+				switch m.op() {
+				case opConnection:
+					seen[m.uint32("conn")] = true
+				case opMessageData:
+					conn := m.uint32("conn")
+					if !seen[conn] {
+						t.Errorf("message of connection %d before its connection record", conn)
+					}
+					read = append(read, message{conn, parseTime(m.fields["time"]), string(m.data)})
+				default:
+					t.Errorf("record op %d in a chunk", m.op())
+				}
+			}
+		case opIndexData:
+			if chunk == nil {
+				t.Fatalf("index record at %d before a chunk", r.pos)
+			}
+			conn, count := r.uint32("conn"), r.uint32("count")
+			chunks[uint64(chunk.pos)][conn] = count
+			if int(count)*12 != len(r.data) {
+				t.Fatalf("index of %d entries in %d bytes", count, len(r.data))
+			}
+			for i := 0; i < int(count); i++ {
+				entry := r.data[12*i:]
+				msg := parseRecords(t, chunk.data[binary.LittleEndian.Uint32(entry[8:]):], 0)[0]
+				if msg.op() != opMessageData || msg.uint32("conn") != conn || !parseTime(msg.fields["time"]).Equal(parseTime(entry)) {
+					t.Errorf("index entry %d of connection %d points to %v", i, conn, msg.fields)
+				}
+			}
+		default:
+			t.Errorf("record op %d at %d between chunks", r.op(), r.pos)
+		}
+	}
+	if len(read) != len(written) {
+		t.Fatalf("read %d messages, want %d", len(read), len(written))
+	}
+	for i := range read {
+		if read[i].conn != written[i].conn || !read[i].time.Equal(written[i].time) || read[i].data != written[i].data {
+			t.Errorf("message %d is %+v, want %+v", i, read[i], written[i])
+		}
+	}
+	if n := h.uint32("chunk_count"); int(n) != len(chunks) || n < 2 {
+		t.Errorf("chunk_count %d, %d chunks", n, len(chunks))
+	}
+
+	// the index: connection records, then chunk info records
+	var connections, infos int
+	for _, r := range parseRecords(t, b[indexPos:], indexPos) {
+		switch r.op() {
+		case opConnection:
+			if infos != 0 {
+				t.Errorf("connection record after chunk infos")
+			}
+			c := conns[connections]
+			data := parseFields(t, r.data)
+			if r.uint32("conn") != c.ID || string(r.fields["topic"]) != c.Topic || string(data["topic"]) != c.Topic ||
+				string(data["type"]) != c.Type || string(data["md5sum"]) != c.MD5Sum || string(data["message_definition"]) != c.Definition {
+				t.Errorf("connection record %d: %q %q", connections, r.fields, data)
+			}
+			connections++
+		case opChunkInfo:
+			pos := r.uint64("chunk_pos")
+			counts, ok := chunks[pos]
+			if !ok {
+				t.Errorf("chunk info of a chunk at %d", pos)
+				continue
+			}
+			if int(r.uint32("count")) != len(counts) || len(r.data) != 8*len(counts) {
+				t.Errorf("chunk info at %d: %d connections, want %d", pos, r.uint32("count"), len(counts))
+			}
+			for i := 0; i+8 <= len(r.data); i += 8 {
+				conn, n := binary.LittleEndian.Uint32(r.data[i:]), binary.LittleEndian.Uint32(r.data[i+4:])
+				if counts[conn] != n {
+					t.Errorf("chunk info at %d: %d messages of connection %d, want %d", pos, n, conn, counts[conn])
+				}
+			}
+			if start, end := parseTime(r.fields["start_time"]), parseTime(r.fields["end_time"]); end.Before(start) || start.Before(written[0].time) || end.After(written[len(written)-1].time) {
+				t.Errorf("chunk info at %d: from %v to %v", pos, start, end)
+			}
+			infos++
+		default:
+			t.Errorf("record op %d at %d in the index", r.op(), r.pos)
+		}
+	}
+	if connections != 3 || infos != len(chunks) {
+		t.Errorf("index of %d connections and %d chunk infos, want 3 and %d", connections, infos, len(chunks))
+	}
+}
@@ -0,0 +1,308 @@
+package rosbag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Message types written by this package.
+const (
+	TypePointCloud2 = "sensor_msgs/PointCloud2"
+	TypeLaserScan   = "sensor_msgs/LaserScan"
+	TypeImu         = "sensor_msgs/Imu"
+	TypeJointState  = "sensor_msgs/JointState"
+)
+
+// MD5 sums of the message definitions, as computed by ROS.
+const (
+	MD5PointCloud2 = "1158d486dd51d683ce2f1be655c3c181"
+	MD5LaserScan   = "90c7ef2dc6895d81024acba2ac42f369"
+	MD5Imu         = "6a62c6daae103f4ff57a132d6f95cec2"
+	MD5JointState  = "3066dcd76a6cfaef579bd0f34173e9fd"
+)
+
+const definitionSeparator = "\n================================================================================\n"
+
+const headerDefinition = `MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+
+// Full message definitions (including the definitions of nested types).
+const (
+	DefinitionPointCloud2 = `Header header
+uint32 height
+uint32 width
+PointField[] fields
+bool is_bigendian
+uint32 point_step
+uint32 row_step
+uint8[] data
+bool is_dense
+` + definitionSeparator + headerDefinition + definitionSeparator + `MSG: sensor_msgs/PointField
+uint8 INT8    = 1
+uint8 UINT8   = 2
+uint8 INT16   = 3
+uint8 UINT16  = 4
+uint8 INT32   = 5
+uint8 UINT32  = 6
+uint8 FLOAT32 = 7
+uint8 FLOAT64 = 8
+string name
+uint32 offset
+uint8 datatype
+uint32 count
+`
+
+	DefinitionLaserScan = `Header header
+float32 angle_min
+float32 angle_max
+float32 angle_increment
+float32 time_increment
+float32 scan_time
+float32 range_min
+float32 range_max
+float32[] ranges
+float32[] intensities
+` + definitionSeparator + headerDefinition
+
+	DefinitionImu = `Header header
+geometry_msgs/Quaternion orientation
+float64[9] orientation_covariance
+geometry_msgs/Vector3 angular_velocity
+float64[9] angular_velocity_covariance
+geometry_msgs/Vector3 linear_acceleration
+float64[9] linear_acceleration_covariance
+` + definitionSeparator + headerDefinition + definitionSeparator + `MSG: geometry_msgs/Quaternion
+float64 x
+float64 y
+float64 z
+float64 w
+` + definitionSeparator + `MSG: geometry_msgs/Vector3
+float64 x
+float64 y
+float64 z
+`
+
+	DefinitionJointState = `Header header
+string[] name
+float64[] position
+float64[] velocity
+float64[] effort
+` + definitionSeparator + headerDefinition
+)
+
+// PointField datatypes.
+const (
+	PointFieldFloat32 = 7
+	PointFieldFloat64 = 8
+)
+
+// Header is std_msgs/Header.
+type Header struct {
+	Seq     uint32
+	Stamp   time.Time
+	FrameID string
+}
+
+// PointField is sensor_msgs/PointField.
+type PointField struct {
+	Name     string
+	Offset   uint32
+	Datatype uint8
+	Count    uint32
+}
+
+// PointCloud2 is sensor_msgs/PointCloud2.
+type PointCloud2 struct {
+	Header      Header
+	Height      uint32
+	Width       uint32
+	Fields      []PointField
+	IsBigEndian bool
+	PointStep   uint32
+	RowStep     uint32
+	Data        []byte
+	IsDense     bool
+}
+
+// NewXYZCloud creates an unorganized cloud with float32 x, y, z fields.
+func NewXYZCloud(header Header, points [][3]float32) *PointCloud2 {
+	data := make([]byte, 12*len(points))
+	for i, p := range points {
+		for j, v := range p {
+			binary.LittleEndian.PutUint32(data[12*i+4*j:], math.Float32bits(v))
+		}
+	}
+
+	return &PointCloud2{
+		Header: header,
+		Height: 1,
+		Width:  uint32(len(points)),
+		Fields: []PointField{
+			{Name: "x", Offset: 0, Datatype: PointFieldFloat32, Count: 1},
+			{Name: "y", Offset: 4, Datatype: PointFieldFloat32, Count: 1},
+			{Name: "z", Offset: 8, Datatype: PointFieldFloat32, Count: 1},
+		},
+		PointStep: 12,
+		RowStep:   uint32(len(data)),
+		Data:      data,
+		IsDense:   true,
+	}
+}
+
+// Marshal serializes the message.
+func (msg *PointCloud2) Marshal() []byte {
+	var e encoder
+	e.header(&msg.Header)
+	e.uint32(msg.Height)
+	e.uint32(msg.Width)
+	e.uint32(uint32(len(msg.Fields)))
+	for _, f := range msg.Fields {
+		e.string(f.Name)
+		e.uint32(f.Offset)
+		e.uint8(f.Datatype)
+		e.uint32(f.Count)
+	}
+	e.bool(msg.IsBigEndian)
+	e.uint32(msg.PointStep)
+	e.uint32(msg.RowStep)
+	e.uint32(uint32(len(msg.Data)))
+	e.buf.Write(msg.Data)
+	e.bool(msg.IsDense)
+	return e.buf.Bytes()
+}
+
+// LaserScan is sensor_msgs/LaserScan.
+type LaserScan struct {
+	Header         Header
+	AngleMin       float32 // rad
+	AngleMax       float32 // rad
+	AngleIncrement float32 // rad
+	TimeIncrement  float32 // s
+	ScanTime       float32 // s
+	RangeMin       float32 // m
+	RangeMax       float32 // m
+	Ranges         []float32
+	Intensities    []float32
+}
+
+// Marshal serializes the message.
+func (msg *LaserScan) Marshal() []byte {
+	var e encoder
+	e.header(&msg.Header)
+	e.float32(msg.AngleMin)
+	e.float32(msg.AngleMax)
+	e.float32(msg.AngleIncrement)
+	e.float32(msg.TimeIncrement)
+	e.float32(msg.ScanTime)
+	e.float32(msg.RangeMin)
+	e.float32(msg.RangeMax)
+	e.float32s(msg.Ranges)
+	e.float32s(msg.Intensities)
+	return e.buf.Bytes()
+}
+
+// Imu is sensor_msgs/Imu. Covariances are row-major 3x3 matrices, all zeros
+// mean unknown covariance and -1 as the first element means no estimate.
+type Imu struct {
+	Header                       Header
+	Orientation                  [4]float64 // x, y, z, w
+	OrientationCovariance        [9]float64
+	AngularVelocity              [3]float64 // rad/s
+	AngularVelocityCovariance    [9]float64
+	LinearAcceleration           [3]float64 // m/s^2
+	LinearAccelerationCovariance [9]float64
+}
+
+// Marshal serializes the message.
+func (msg *Imu) Marshal() []byte {
+	var e encoder
+	e.header(&msg.Header)
+	e.float64s(msg.Orientation[:])
+	e.float64s(msg.OrientationCovariance[:])
+	e.float64s(msg.AngularVelocity[:])
+	e.float64s(msg.AngularVelocityCovariance[:])
+	e.float64s(msg.LinearAcceleration[:])
+	e.float64s(msg.LinearAccelerationCovariance[:])
+	return e.buf.Bytes()
+}
+
+// JointState is sensor_msgs/JointState.
+type JointState struct {
+	Header   Header
+	Name     []string
+	Position []float64 // rad
+	Velocity []float64 // rad/s
+	Effort   []float64 // Nm
+}
+
+// Marshal serializes the message.
+func (msg *JointState) Marshal() []byte {
+	var e encoder
+	e.header(&msg.Header)
+	e.uint32(uint32(len(msg.Name)))
+	for _, name := range msg.Name {
+		e.string(name)
+	}
+	for _, values := range [][]float64{msg.Position, msg.Velocity, msg.Effort} {
+		e.uint32(uint32(len(values)))
+		e.float64s(values)
+	}
+	return e.buf.Bytes()
+}
+
+// encoder serializes ROS messages (little endian, length-prefixed strings and
+// variable-length arrays).
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf.WriteByte(v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf.Write(uint32Bytes(v))
+}
+
+func (e *encoder) float32(v float32) {
+	e.uint32(math.Float32bits(v))
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) header(h *Header) {
+	e.uint32(h.Seq)
+	e.buf.Write(timeBytes(h.Stamp))
+	e.string(h.FrameID)
+}
+
+// float32s writes a variable-length array.
+func (e *encoder) float32s(values []float32) {
+	e.uint32(uint32(len(values)))
+	for _, v := range values {
+		e.float32(v)
+	}
+}
+
+// float64s writes values without the length prefix (fixed-length arrays).
+func (e *encoder) float64s(values []float64) {
+	for _, v := range values {
+		e.buf.Write(uint64Bytes(math.Float64bits(v)))
+	}
+}
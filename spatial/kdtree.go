@@ -0,0 +1,235 @@
+package spatial
+
+import "math"
+
+// kdRebuildDepth is the depth, relative to log2 of the number of points, at
+// which the tree is considered unbalanced by insertions and rebuilt.
+const kdRebuildDepth = 3
+
+// KDTree is a 3D k-d tree. Building is O(n log n). Points can be inserted
+// later, the tree is rebuilt when insertions unbalance it too much.
+type KDTree struct {
+	points   []Point
+	nodes    []kdNode
+	root     int
+	maxDepth int
+}
+
+type kdNode struct {
+	index       int // index of the point
+	axis        int // 0 - X, 1 - Y, 2 - Z
+	left, right int // child nodes, -1 if none
+}
+
+// NewKDTree builds a balanced tree of the points (which are copied).
+func NewKDTree(points []Point) *KDTree {
+	tree := &KDTree{points: append([]Point(nil), points...)}
+	tree.rebuild()
+	return tree
+}
+
+func (tree *KDTree) rebuild() {
+	tree.nodes = make([]kdNode, 0, cap(tree.points))
+	tree.maxDepth = 0
+	indices := make([]int, len(tree.points))
+	for i := range indices {
+		indices[i] = i
+	}
+	tree.root = tree.build(indices, 0)
+}
+
+func (tree *KDTree) build(indices []int, depth int) int {
+	if len(indices) == 0 {
+		return -1
+	}
+	if depth > tree.maxDepth {
+		tree.maxDepth = depth
+	}
+
+	axis := depth % 3
+	mid := len(indices) / 2
+	tree.selectNth(indices, mid, axis)
+
+	n := len(tree.nodes)
+	tree.nodes = append(tree.nodes, kdNode{index: indices[mid], axis: axis})
+	left := tree.build(indices[:mid], depth+1)
+	right := tree.build(indices[mid+1:], depth+1)
+	tree.nodes[n].left, tree.nodes[n].right = left, right
+	return n
+}
+
+// selectNth partially sorts indices, so the n-th one is at its sorted position
+// by the axis coordinate (quickselect).
+func (tree *KDTree) selectNth(indices []int, n int, axis int) {
+	lo, hi := 0, len(indices)-1
+	for lo < hi {
+		// median of three as the pivot
+		mid := lo + (hi-lo)/2
+		a := tree.points[indices[lo]].coord(axis)
+		b := tree.points[indices[mid]].coord(axis)
+		c := tree.points[indices[hi]].coord(axis)
+		pivotIdx := mid
+		if (b <= a) == (a <= c) {
+			pivotIdx = lo
+		} else if (a <= c) == (c <= b) {
+			pivotIdx = hi
+		}
+		pivot := tree.points[indices[pivotIdx]].coord(axis)
+		indices[pivotIdx], indices[hi] = indices[hi], indices[pivotIdx]
+
+		store := lo
+		for i := lo; i < hi; i++ {
+			if tree.points[indices[i]].coord(axis) < pivot {
+				indices[i], indices[store] = indices[store], indices[i]
+				store++
+			}
+		}
+		indices[store], indices[hi] = indices[hi], indices[store]
+
+		switch {
+		case store == n:
+			return
+		case store < n:
+			lo = store + 1
+		default:
+			hi = store - 1
+		}
+	}
+}
+
+// Insert adds the point as a new leaf and returns its index.
+func (tree *KDTree) Insert(p Point) int {
+	index := len(tree.points)
+	tree.points = append(tree.points, p)
+
+	if tree.root < 0 {
+		tree.rebuild()
+		return index
+	}
+
+	n, depth := tree.root, 0
+	for {
+		node := &tree.nodes[n]
+		next := &node.right
+		if p.coord(node.axis) < tree.points[node.index].coord(node.axis) {
+			next = &node.left
+		}
+		depth++
+		if *next < 0 {
+			*next = len(tree.nodes)
+			tree.nodes = append(tree.nodes, kdNode{index: index, axis: depth % 3, left: -1, right: -1})
+			break
+		}
+		n = *next
+	}
+
+	if depth > tree.maxDepth {
+		tree.maxDepth = depth
+	}
+	if tree.maxDepth > kdRebuildDepth*(bitLen(len(tree.points))+1) {
+		tree.rebuild()
+	}
+	return index
+}
+
+// bitLen returns the number of bits needed to represent n, i.e. about log2(n).
+func bitLen(n int) int {
+	bits := 0
+	for ; n > 0; n >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+// Point returns the point with the index.
+func (tree *KDTree) Point(i int) Point {
+	return tree.points[i]
+}
+
+// Len returns the number of points in the tree.
+func (tree *KDTree) Len() int {
+	return len(tree.points)
+}
+
+// Nearest returns up to k nearest points sorted by distance, the nearest first.
+func (tree *KDTree) Nearest(q Point, k int) []Neighbor {
+	if k <= 0 {
+		return nil
+	}
+	h := make(neighborHeap, 0, k)
+	tree.nearest(tree.root, q, k, &h)
+	return h.sorted()
+}
+
+func (tree *KDTree) nearest(n int, q Point, k int, h *neighborHeap) {
+	if n < 0 {
+		return
+	}
+	node := tree.nodes[n]
+	p := tree.points[node.index]
+	h.offer(k, Neighbor{node.index, q.Dist2(p)})
+
+	diff := q.coord(node.axis) - p.coord(node.axis)
+	near, far := node.left, node.right
+	if diff >= 0 {
+		near, far = far, near
+	}
+	tree.nearest(near, q, k, h)
+	if len(*h) < k || diff*diff < h.worst() {
+		tree.nearest(far, q, k, h)
+	}
+}
+
+// NearestOne returns the nearest point. ok is false if the tree is empty.
+func (tree *KDTree) NearestOne(q Point) (n Neighbor, ok bool) {
+	n.Dist2 = math.Inf(1)
+	tree.nearestOne(tree.root, q, &n)
+	return n, !math.IsInf(n.Dist2, 1)
+}
+
+func (tree *KDTree) nearestOne(n int, q Point, best *Neighbor) {
+	if n < 0 {
+		return
+	}
+	node := tree.nodes[n]
+	p := tree.points[node.index]
+	if d := q.Dist2(p); d < best.Dist2 {
+		*best = Neighbor{node.index, d}
+	}
+
+	diff := q.coord(node.axis) - p.coord(node.axis)
+	near, far := node.left, node.right
+	if diff >= 0 {
+		near, far = far, near
+	}
+	tree.nearestOne(near, q, best)
+	if diff*diff < best.Dist2 {
+		tree.nearestOne(far, q, best)
+	}
+}
+
+// Radius returns indices of points within r (inclusive), unsorted.
+func (tree *KDTree) Radius(q Point, r float64) []int {
+	var result []int
+	tree.radius(tree.root, q, r*r, &result)
+	return result
+}
+
+func (tree *KDTree) radius(n int, q Point, r2 float64, result *[]int) {
+	if n < 0 {
+		return
+	}
+	node := tree.nodes[n]
+	p := tree.points[node.index]
+	if q.Dist2(p) <= r2 {
+		*result = append(*result, node.index)
+	}
+
+	diff := q.coord(node.axis) - p.coord(node.axis)
+	if diff <= 0 || diff*diff <= r2 {
+		tree.radius(node.left, q, r2, result)
+	}
+	if diff >= 0 || diff*diff <= r2 {
+		tree.radius(node.right, q, r2, result)
+	}
+}
@@ -0,0 +1,206 @@
+package spatial
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// randomPoints returns n points in a 10 m cube (millimeters), every tenth one a
+// duplicate of an earlier point.
+func randomPoints(rng *rand.Rand, n int) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		if i > 0 && i%10 == 0 {
+			points[i] = points[rng.Intn(i)]
+			continue
+		}
+		points[i] = Point{rng.Float64() * 10000, rng.Float64() * 10000, rng.Float64() * 10000}
+	}
+	return points
+}
+
+// bruteNearest returns the squared distances of the k nearest points.
+func bruteNearest(points []Point, q Point, k int) []float64 {
+	dists := make([]float64, len(points))
+	for i, p := range points {
+		dists[i] = p.Dist2(q)
+	}
+	sort.Float64s(dists)
+	if k < len(dists) {
+		dists = dists[:k]
+	}
+	return dists
+}
+
+// bruteRadius returns the sorted indices of the points within r.
+func bruteRadius(points []Point, q Point, r float64) []int {
+	var indices []int
+	for i, p := range points {
+		if p.Dist2(q) <= r*r {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// indexes returns the indexes to test, all holding points.
+func indexes(points []Point) map[string]Index {
+	inserted := NewKDTree(nil)
+	octree := NewOctree(DefaultOctreeLeafSize)
+	for _, p := range points {
+		inserted.Insert(p)
+		octree.Insert(p)
+	}
+	return map[string]Index{
+		"kdtree":          NewKDTree(points),
+		"kdtree inserted": inserted,
+		"octree":          octree,
+	}
+}
+
+func TestNearest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := randomPoints(rng, 5000)
+	for name, index := range indexes(points) {
+		if index.Len() != len(points) {
+			t.Fatalf("%s: Len() = %d, want %d", name, index.Len(), len(points))
+		}
+		for q := 0; q < 200; q++ {
+			query := Point{rng.Float64()*12000 - 1000, rng.Float64()*12000 - 1000, rng.Float64()*12000 - 1000}
+			for _, k := range []int{1, 8, 50} {
+				got := index.Nearest(query, k)
+				want := bruteNearest(points, query, k)
+				if len(got) != len(want) {
+					t.Fatalf("%s: Nearest(%v, %d) returned %d points, want %d", name, query, k, len(got), len(want))
+				}
+				for i, n := range got {
+					// ties can be returned in any order, so only distances are compared
+					if n.Dist2 != want[i] {
+						t.Fatalf("%s: Nearest(%v, %d)[%d] at %g, want %g", name, query, k, i, n.Dist2, want[i])
+					}
+					if d := index.Point(n.Index).Dist2(query); d != n.Dist2 {
+						t.Fatalf("%s: Nearest(%v, %d)[%d] has distance %g, its point is at %g", name, query, k, i, n.Dist2, d)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNearestOne(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	points := randomPoints(rng, 5000)
+	tree := NewKDTree(points)
+	for q := 0; q < 500; q++ {
+		query := Point{rng.Float64() * 10000, rng.Float64() * 10000, rng.Float64() * 10000}
+		got, ok := tree.NearestOne(query)
+		if want := bruteNearest(points, query, 1)[0]; !ok || got.Dist2 != want {
+			t.Fatalf("NearestOne(%v) at %g (%v), want %g", query, got.Dist2, ok, want)
+		}
+	}
+
+	if _, ok := NewKDTree(nil).NearestOne(Point{}); ok {
+		t.Fatal("NearestOne found a point in an empty tree")
+	}
+}
+
+func TestRadius(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	points := randomPoints(rng, 5000)
+	for name, index := range indexes(points) {
+		for q := 0; q < 200; q++ {
+			query := points[rng.Intn(len(points))]
+			if q%2 == 1 {
+				query = query.Add(Point{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}.Scale(300))
+			}
+			for _, r := range []float64{0, 200, 1000} {
+				got := index.Radius(query, r)
+				sort.Ints(got)
+				want := bruteRadius(points, query, r)
+				if len(got) != len(want) {
+					t.Fatalf("%s: Radius(%v, %g) returned %d points, want %d", name, query, r, len(got), len(want))
+				}
+				for i := range got {
+					if got[i] != want[i] {
+						t.Fatalf("%s: Radius(%v, %g) returned point %d, want %d", name, query, r, got[i], want[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+var (
+	benchOnce  sync.Once
+	benchCloud []Point
+)
+
+// benchPoints returns the cloud of the benchmarks, about as large as a few
+// minutes of sync output. It is generated on the first call, so tests don't
+// pay for it.
+func benchPoints() []Point {
+	benchOnce.Do(func() {
+		benchCloud = randomPoints(rand.New(rand.NewSource(1)), 1000000)
+	})
+	return benchCloud
+}
+
+// benchQueries returns queries near the benchmark points.
+func benchQueries(n int) []Point {
+	points := benchPoints()
+	rng := rand.New(rand.NewSource(2))
+	queries := make([]Point, n)
+	for i := range queries {
+		p := points[rng.Intn(len(points))]
+		queries[i] = p.Add(Point{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}.Scale(10))
+	}
+	return queries
+}
+
+// benchQuery runs the k nearest neighbor and radius queries of the index.
+func benchQuery(b *testing.B, index Index) {
+	queries := benchQueries(10000)
+	b.ResetTimer()
+	b.Run("Nearest16", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			index.Nearest(queries[i%len(queries)], 16)
+		}
+	})
+	b.Run("Radius100", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			index.Radius(queries[i%len(queries)], 100)
+		}
+	})
+}
+
+func BenchmarkKDTree(b *testing.B) {
+	points := benchPoints()
+	b.ResetTimer()
+	b.Run("Build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewKDTree(points)
+		}
+	})
+	b.Run("Insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree := NewKDTree(nil)
+			for _, p := range points {
+				tree.Insert(p)
+			}
+		}
+	})
+	benchQuery(b, NewKDTree(points))
+}
+
+func BenchmarkOctree(b *testing.B) {
+	points := benchPoints()
+	b.ResetTimer()
+	b.Run("Insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewOctreeFrom(points, DefaultOctreeLeafSize)
+		}
+	})
+	benchQuery(b, NewOctreeFrom(points, DefaultOctreeLeafSize))
+}
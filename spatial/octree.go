@@ -0,0 +1,228 @@
+package spatial
+
+import "math"
+
+// Octree parameters.
+const (
+	DefaultOctreeLeafSize = 16   // points in a leaf before it is split
+	octreeMinHalf         = 1e-6 // leaves smaller than that are not split (duplicate points)
+	octreeInitialHalf     = 1.0  // half of the root edge when the first point is inserted
+)
+
+// Octree is a 3D octree which grows as points are inserted, no bounds have to
+// be known in advance. It suits clouds built incrementally, e.g. maps
+// accumulated scan by scan.
+type Octree struct {
+	points   []Point
+	root     *octNode
+	leafSize int
+}
+
+type octNode struct {
+	center   Point
+	half     float64      // half of the edge length
+	children *[8]*octNode // nil for leaves
+	indices  []int        // points of a leaf
+}
+
+// NewOctree creates an empty octree. Leaves are split when they contain more
+// than leafSize points.
+func NewOctree(leafSize int) *Octree {
+	if leafSize < 1 {
+		leafSize = DefaultOctreeLeafSize
+	}
+	return &Octree{leafSize: leafSize}
+}
+
+// NewOctreeFrom creates an octree with the points inserted.
+func NewOctreeFrom(points []Point, leafSize int) *Octree {
+	tree := NewOctree(leafSize)
+	tree.points = make([]Point, 0, len(points))
+	for _, p := range points {
+		tree.Insert(p)
+	}
+	return tree
+}
+
+// octant returns the index of the child of a node with the center containing p.
+func octant(center, p Point) int {
+	i := 0
+	if p.X >= center.X {
+		i |= 1
+	}
+	if p.Y >= center.Y {
+		i |= 2
+	}
+	if p.Z >= center.Z {
+		i |= 4
+	}
+	return i
+}
+
+// childCenter returns the center of the i-th child.
+func (node *octNode) childCenter(i int) Point {
+	offset := func(bit int) float64 {
+		if i&bit != 0 {
+			return node.half / 2
+		}
+		return -node.half / 2
+	}
+	return node.center.Add(Point{offset(1), offset(2), offset(4)})
+}
+
+func (node *octNode) contains(p Point) bool {
+	return math.Abs(p.X-node.center.X) <= node.half &&
+		math.Abs(p.Y-node.center.Y) <= node.half &&
+		math.Abs(p.Z-node.center.Z) <= node.half
+}
+
+// boxDist2 returns the squared distance from p to the node's box.
+func (node *octNode) boxDist2(p Point) float64 {
+	var d2 float64
+	for axis := 0; axis < 3; axis++ {
+		d := math.Abs(p.coord(axis)-node.center.coord(axis)) - node.half
+		if d > 0 {
+			d2 += d * d
+		}
+	}
+	return d2
+}
+
+// Insert adds the point and returns its index.
+func (tree *Octree) Insert(p Point) int {
+	index := len(tree.points)
+	tree.points = append(tree.points, p)
+
+	if tree.root == nil {
+		tree.root = &octNode{center: p, half: octreeInitialHalf}
+	}
+	for !tree.root.contains(p) {
+		tree.grow(p)
+	}
+
+	node := tree.root
+	for node.children != nil {
+		node = node.children[octant(node.center, p)]
+	}
+	node.indices = append(node.indices, index)
+	tree.split(node)
+	return index
+}
+
+// grow doubles the root towards p, the old root becomes one of the children.
+func (tree *Octree) grow(p Point) {
+	old := tree.root
+	center := old.center
+	for axis, d := range [3]*float64{&center.X, &center.Y, &center.Z} {
+		if p.coord(axis) >= old.center.coord(axis) {
+			*d += old.half
+		} else {
+			*d -= old.half
+		}
+	}
+
+	root := &octNode{center: center, half: 2 * old.half, children: &[8]*octNode{}}
+	for i := range root.children {
+		root.children[i] = &octNode{center: root.childCenter(i), half: old.half}
+	}
+	if old.children != nil || len(old.indices) != 0 {
+		root.children[octant(center, old.center)] = old
+	}
+	tree.root = root
+}
+
+// split turns a full leaf into an inner node.
+func (tree *Octree) split(node *octNode) {
+	if len(node.indices) <= tree.leafSize || node.half < octreeMinHalf {
+		return
+	}
+
+	node.children = &[8]*octNode{}
+	for i := range node.children {
+		node.children[i] = &octNode{center: node.childCenter(i), half: node.half / 2}
+	}
+	for _, index := range node.indices {
+		child := node.children[octant(node.center, tree.points[index])]
+		child.indices = append(child.indices, index)
+	}
+	node.indices = nil
+	for _, child := range node.children {
+		tree.split(child)
+	}
+}
+
+// Point returns the point with the index.
+func (tree *Octree) Point(i int) Point {
+	return tree.points[i]
+}
+
+// Len returns the number of points.
+func (tree *Octree) Len() int {
+	return len(tree.points)
+}
+
+// Nearest returns up to k nearest points sorted by distance, the nearest first.
+func (tree *Octree) Nearest(q Point, k int) []Neighbor {
+	if k <= 0 || tree.root == nil {
+		return nil
+	}
+	h := make(neighborHeap, 0, k)
+	tree.nearest(tree.root, q, k, &h)
+	return h.sorted()
+}
+
+func (tree *Octree) nearest(node *octNode, q Point, k int, h *neighborHeap) {
+	if node.children == nil {
+		for _, index := range node.indices {
+			h.offer(k, Neighbor{index, q.Dist2(tree.points[index])})
+		}
+		return
+	}
+
+	// visit the children from the nearest one, so the farther ones are pruned
+	type candidate struct {
+		node  *octNode
+		dist2 float64
+	}
+	var order [8]candidate
+	for i, child := range node.children {
+		c := candidate{child, child.boxDist2(q)}
+		j := i
+		for ; j > 0 && order[j-1].dist2 > c.dist2; j-- { // insertion sort
+			order[j] = order[j-1]
+		}
+		order[j] = c
+	}
+	for _, o := range order {
+		if len(*h) == k && o.dist2 >= h.worst() {
+			break
+		}
+		tree.nearest(o.node, q, k, h)
+	}
+}
+
+// Radius returns indices of points within r (inclusive), unsorted.
+func (tree *Octree) Radius(q Point, r float64) []int {
+	var result []int
+	if tree.root != nil {
+		tree.radius(tree.root, q, r*r, &result)
+	}
+	return result
+}
+
+func (tree *Octree) radius(node *octNode, q Point, r2 float64, result *[]int) {
+	if node.boxDist2(q) > r2 {
+		return
+	}
+	if node.children == nil {
+		for _, index := range node.indices {
+			if q.Dist2(tree.points[index]) <= r2 {
+				*result = append(*result, index)
+			}
+		}
+		return
+	}
+	for _, child := range node.children {
+		tree.radius(child, q, r2, result)
+	}
+}
@@ -0,0 +1,142 @@
+// Package spatial contains the 3D point type and spatial indexes for fast
+// nearest neighbor and radius queries over point clouds.
+package spatial
+
+import "math"
+
+// Point is a single cartesian point. Units are the ones of the source, sync
+// outputs millimeters.
+type Point struct {
+	X, Y, Z float64
+}
+
+// Add returns p + q.
+func (p Point) Add(q Point) Point {
+	return Point{p.X + q.X, p.Y + q.Y, p.Z + q.Z}
+}
+
+// Sub returns p - q.
+func (p Point) Sub(q Point) Point {
+	return Point{p.X - q.X, p.Y - q.Y, p.Z - q.Z}
+}
+
+// Scale returns p * s.
+func (p Point) Scale(s float64) Point {
+	return Point{p.X * s, p.Y * s, p.Z * s}
+}
+
+// Dot returns the dot product of p and q.
+func (p Point) Dot(q Point) float64 {
+	return p.X*q.X + p.Y*q.Y + p.Z*q.Z
+}
+
+// Cross returns the cross product of p and q.
+func (p Point) Cross(q Point) Point {
+	return Point{p.Y*q.Z - p.Z*q.Y, p.Z*q.X - p.X*q.Z, p.X*q.Y - p.Y*q.X}
+}
+
+// Norm returns the length of p.
+func (p Point) Norm() float64 {
+	return math.Sqrt(p.Dot(p))
+}
+
+// Dist2 returns the squared distance between p and q.
+func (p Point) Dist2(q Point) float64 {
+	d := p.Sub(q)
+	return d.Dot(d)
+}
+
+// coord returns the coordinate on the axis (0 - X, 1 - Y, 2 - Z).
+func (p Point) coord(axis int) float64 {
+	switch axis {
+	case 0:
+		return p.X
+	case 1:
+		return p.Y
+	default:
+		return p.Z
+	}
+}
+
+// Neighbor is a point found by a search.
+type Neighbor struct {
+	Index int     // index of the point
+	Dist2 float64 // squared distance to the query point
+}
+
+// Index is a spatial index of points. Points are identified by their insertion
+// order.
+type Index interface {
+	// Insert adds the point and returns its index.
+	Insert(p Point) int
+	// Nearest returns up to k nearest points sorted by distance, the nearest first.
+	Nearest(q Point, k int) []Neighbor
+	// Radius returns indices of points within r (inclusive), unsorted.
+	Radius(q Point, r float64) []int
+	// Point returns the point with the index.
+	Point(i int) Point
+	// Len returns the number of points.
+	Len() int
+}
+
+// neighborHeap is a max-heap by distance, it keeps the k nearest points found
+// so far. It is not a container/heap, which would allocate on every push.
+type neighborHeap []Neighbor
+
+// offer adds the neighbor to the heap of the k nearest ones if it is nearer
+// than the farthest one.
+func (h *neighborHeap) offer(k int, n Neighbor) {
+	switch {
+	case len(*h) < k:
+		*h = append(*h, n)
+		h.up(len(*h) - 1)
+	case n.Dist2 < (*h)[0].Dist2:
+		(*h)[0] = n
+		h.down(0)
+	}
+}
+
+// worst returns the distance of the farthest neighbor kept.
+func (h neighborHeap) worst() float64 {
+	return h[0].Dist2
+}
+
+func (h neighborHeap) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h[parent].Dist2 >= h[i].Dist2 {
+			return
+		}
+		h[parent], h[i] = h[i], h[parent]
+		i = parent
+	}
+}
+
+func (h neighborHeap) down(i int) {
+	for {
+		largest := i
+		for _, child := range [2]int{2*i + 1, 2*i + 2} {
+			if child < len(h) && h[child].Dist2 > h[largest].Dist2 {
+				largest = child
+			}
+		}
+		if largest == i {
+			return
+		}
+		h[i], h[largest] = h[largest], h[i]
+		i = largest
+	}
+}
+
+// sorted empties the heap and returns the neighbors, the nearest first.
+func (h *neighborHeap) sorted() []Neighbor {
+	result := make([]Neighbor, len(*h))
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = (*h)[0]
+		last := len(*h) - 1
+		(*h)[0] = (*h)[last]
+		*h = (*h)[:last]
+		h.down(0)
+	}
+	return result
+}
@@ -0,0 +1,150 @@
+// Package webview serves a self-contained WebGL point cloud viewer and streams
+// clouds to it with server-sent events. All assets are embedded in the binary,
+// so the viewer works without internet access.
+package webview
+
+import (
+	"embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed static
+var static embed.FS
+
+// clientQueueSize is the number of clouds waiting to be sent to a client. When
+// it is exceeded (slow network), new clouds for the client are dropped.
+const clientQueueSize = 16
+
+// Cloud is a single cloud sent to viewers.
+type Cloud struct {
+	ID     int
+	Time   time.Time
+	Points [][3]float32 // meters, Z up
+}
+
+// event is the JSON form of Cloud, points are base64 encoded little-endian
+// float32 x, y, z triples.
+type event struct {
+	ID     int    `json:"id"`
+	Time   int64  `json:"time"` // ms since Unix epoch
+	Count  int    `json:"count"`
+	Points string `json:"points"`
+}
+
+// Server serves the viewer page on "/" and the stream of clouds on "/stream".
+// It is safe for concurrent use.
+type Server struct {
+	mux *http.ServeMux
+
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+}
+
+// NewServer creates a server without clients.
+func NewServer() *Server {
+	server := &Server{
+		mux:     http.NewServeMux(),
+		clients: make(map[chan []byte]bool),
+	}
+
+	root, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // static is embedded, so it is always there
+	}
+	server.mux.Handle("/", http.FileServer(http.FS(root)))
+	server.mux.HandleFunc("/stream", server.serveStream)
+	return server
+}
+
+// HasClients reports whether any viewer is connected.
+func (server *Server) HasClients() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return len(server.clients) != 0
+}
+
+// Publish sends the cloud to all connected viewers.
+func (server *Server) Publish(cloud Cloud) error {
+	if !server.HasClients() {
+		return nil
+	}
+
+	data := make([]byte, 12*len(cloud.Points))
+	for i, p := range cloud.Points {
+		for j, v := range p {
+			binary.LittleEndian.PutUint32(data[12*i+4*j:], math.Float32bits(v))
+		}
+	}
+	payload, err := json.Marshal(event{
+		ID:     cloud.ID,
+		Time:   cloud.Time.UnixNano() / int64(time.Millisecond),
+		Count:  len(cloud.Points),
+		Points: base64.StdEncoding.EncodeToString(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for send := range server.clients {
+		select {
+		case send <- payload:
+		default: // the client is too slow, drop the cloud
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the viewer.
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// serveStream sends clouds as "cloud" events until the client disconnects.
+func (server *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := make(chan []byte, clientQueueSize)
+	server.mu.Lock()
+	server.clients[send] = true
+	server.mu.Unlock()
+	log.Println("webview: viewer connected from", r.RemoteAddr)
+
+	defer func() {
+		server.mu.Lock()
+		delete(server.clients, send)
+		server.mu.Unlock()
+		log.Println("webview: viewer disconnected from", r.RemoteAddr)
+	}()
+
+	for {
+		select {
+		case payload := <-send:
+			if _, err := fmt.Fprintf(w, "event: cloud\ndata: %s\n\n", payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}